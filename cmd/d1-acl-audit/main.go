@@ -0,0 +1,188 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Command d1-acl-audit exports the ACLs of D1 objects and compares exports.
+
+Usage:
+
+	d1-acl-audit export [-objects file | -keyword keyword... | -registry file] [-format json|csv] [-out file]
+	d1-acl-audit diff old.json new.json
+
+The export command connects to the D1 service at $D1_ENDPOINT as the user $D1_UID with the password
+$D1_PASS. Objects are read from a file with one object ID per line, looked up in the secure index
+by keyword, or listed from the event log of a registry.FileStore. The diff command prints one line
+per group that was granted (+) or revoked (-) access to an object between the two exports. The
+format of each export is derived from its file extension.
+*/
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aclaudit"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/registry"
+)
+
+// errUsage is returned when the command line is invalid.
+var errUsage = errors.New("invalid usage")
+
+type keywords []string
+
+func (k *keywords) String() string     { return strings.Join(*k, ",") }
+func (k *keywords) Set(v string) error { *k = append(*k, v); return nil }
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	switch {
+	case errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp):
+		fmt.Fprintln(os.Stderr, "usage: d1-acl-audit export [-objects file | -keyword keyword... | -registry file] [-format json|csv] [-out file]")
+		fmt.Fprintln(os.Stderr, "       d1-acl-audit diff old new")
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "d1-acl-audit:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args, writing its output to stdout. The options are added to
+// those used to connect to D1.
+func run(args []string, stdout io.Writer, opts ...client.Option) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "export":
+		return export(args[1:], stdout, opts...)
+	case "diff":
+		return diff(args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+func export(args []string, stdout io.Writer, opts ...client.Option) error {
+	var keywordList keywords
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	objects := flags.String("objects", "", "file with one object ID per line")
+	flags.Var(&keywordList, "keyword", "index keyword to look up object IDs by (repeatable)")
+	registryLog := flags.String("registry", "", "registry event log to list object IDs from")
+	format := flags.String("format", "json", "output format, json or csv")
+	out := flags.String("out", "", "output file (default stdout)")
+	plaintext := flags.Bool("insecure", false, "connect without TLS")
+	concurrency := flags.Int("concurrency", 8, "number of concurrent requests")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	sources := 0
+	for _, given := range []bool{*objects != "", len(keywordList) > 0, *registryLog != ""} {
+		if given {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("exactly one of -objects, -keyword and -registry must be given")
+	}
+
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if *plaintext {
+		creds = insecure.NewCredentials()
+	}
+	// The password is destroyed once the client is closed.
+	password := client.NewSecretBytes([]byte(os.Getenv("D1_PASS")))
+	defer password.Destroy()
+	opts = append([]client.Option{
+		client.WithGrpcOption(grpc.WithTransportCredentials(creds)),
+		client.WithTokenRefreshSecret(os.Getenv("D1_UID"), password),
+	}, opts...)
+	c, err := client.NewBaseClient(os.Getenv("D1_ENDPOINT"), opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var source aclaudit.Source
+	switch {
+	case *objects != "":
+		source = aclaudit.FileSource(*objects)
+	case len(keywordList) > 0:
+		source = aclaudit.IndexSource(c.Index, keywordList...)
+	default:
+		reg, err := registry.New(registry.NewFileStore(*registryLog))
+		if err != nil {
+			return err
+		}
+		source = aclaudit.RegistrySource(reg)
+	}
+
+	result, err := aclaudit.Run(context.Background(), c.Authz, source, aclaudit.WithConcurrency(*concurrency))
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return aclaudit.Write(stdout, result, *format)
+	}
+	file, err := os.Create(filepath.Clean(*out))
+	if err != nil {
+		return err
+	}
+	if err := aclaudit.Write(file, result, *format); err != nil {
+		_ = file.Close()
+		return err
+	}
+	// Errors writing buffered data may only be reported on close.
+	return file.Close()
+}
+
+func diff(args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	before, err := readExport(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := readExport(args[1])
+	if err != nil {
+		return err
+	}
+
+	return aclaudit.WriteDiff(stdout, aclaudit.Diff(before, after))
+}
+
+func readExport(path string) (*aclaudit.Export, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return aclaudit.Read(file, strings.TrimPrefix(filepath.Ext(path), "."))
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/registry"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestExportAndDiff(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	other, _ := server.NewUser()
+	t.Setenv("D1_ENDPOINT", "bufnet")
+	t.Setenv("D1_UID", uid)
+	t.Setenv("D1_PASS", pwd)
+	var dialOpts []client.Option
+	for _, opt := range server.DialOptions() {
+		dialOpts = append(dialOpts, client.WithGrpcOption(opt))
+	}
+
+	// Record two objects in a registry log.
+	dir := t.TempDir()
+	log := filepath.Join(dir, "registry.jsonl")
+	reg, err := registry.New(registry.NewFileStore(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd),
+		client.WithGrpcOption(grpc.WithChainUnaryInterceptor(reg.UnaryClientInterceptor())),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	var objectIDs []string
+	for i := 0; i < 2; i++ {
+		res, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		objectIDs = append(objectIDs, res.ObjectId)
+	}
+
+	before := filepath.Join(dir, "before.json")
+	if err := run([]string{"export", "-insecure", "-registry", log, "-out", before}, nil, dialOpts...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: objectIDs[1], GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	var after bytes.Buffer
+	if err := run([]string{"export", "-insecure", "-registry", log, "-format", "csv"}, &after, dialOpts...); err != nil {
+		t.Fatal(err)
+	}
+	afterPath := filepath.Join(dir, "after.csv")
+	if err := os.WriteFile(afterPath, after.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"diff", before, afterPath}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(out.String()), "+ "+objectIDs[1]+" "+other; got != want {
+		t.Fatalf("got diff %q, want %q", got, want)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"diff", "only-one.json"},
+		{"export", "-unknown-flag"},
+	} {
+		if err := run(args, &bytes.Buffer{}); !errors.Is(err, errUsage) {
+			t.Errorf("%q: expected a usage error, got %v", args, err)
+		}
+	}
+	if err := run([]string{"export", "-objects", "objects.txt", "-keyword", "k"}, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for two object sources")
+	}
+}
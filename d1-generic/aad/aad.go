@@ -0,0 +1,251 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aad
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+)
+
+const (
+	magic   = "D1AD"
+	version = 1
+)
+
+// Well-known context keys.
+const (
+	KeyTenant  = "tenant"
+	KeyTable   = "table"
+	KeyColumn  = "column"
+	KeyRowID   = "row_id"
+	KeyPurpose = "purpose"
+)
+
+// ErrInvalid is returned when associated data is not in the canonical encoding.
+var ErrInvalid = errors.New("invalid associated data")
+
+// ErrEmptyKey is returned by Builder.Build when a key was set to the empty string.
+var ErrEmptyKey = errors.New("associated data key is empty")
+
+// MismatchError is returned when the context of associated data does not match the expected
+// context. An empty Expected or Actual means the key is missing.
+type MismatchError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("associated data mismatch for %q: expected %q, got %q", e.Key, e.Expected, e.Actual)
+}
+
+// Context is a set of key/value pairs describing the context of a ciphertext.
+type Context map[string]string
+
+// Marshal returns the canonical encoding of the context.
+func (c Context) Marshal() []byte {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data := append([]byte(magic), version)
+	data = appendUvarint(data, uint64(len(keys)))
+	for _, k := range keys {
+		data = appendString(data, k)
+		data = appendString(data, c[k])
+	}
+	return data
+}
+
+// Verify returns a *MismatchError if the context does not contain exactly the keys and values of
+// the expected context.
+func (c Context) Verify(expected Context) error {
+	keys := make([]string, 0, len(expected)+len(c))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	for k := range c {
+		if _, ok := expected[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		want, wantOK := expected[k]
+		got, gotOK := c[k]
+		if want != got || wantOK != gotOK {
+			return &MismatchError{Key: k, Expected: want, Actual: got}
+		}
+	}
+	return nil
+}
+
+// Parse decodes associated data in the canonical encoding.
+func Parse(data []byte) (Context, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, ErrInvalid
+	}
+	data = data[len(magic):]
+	if len(data) == 0 || data[0] != version {
+		return nil, fmt.Errorf("%w: unsupported version", ErrInvalid)
+	}
+	data = data[1:]
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, ErrInvalid
+	}
+	data = data[n:]
+
+	c := make(Context, count)
+	var previous string
+	for i := uint64(0); i < count; i++ {
+		var k, v string
+		var err error
+		if k, data, err = readString(data); err != nil {
+			return nil, err
+		}
+		if v, data, err = readString(data); err != nil {
+			return nil, err
+		}
+		if k == "" || (i > 0 && k <= previous) {
+			return nil, fmt.Errorf("%w: keys are not sorted and unique", ErrInvalid)
+		}
+		c[k] = v
+		previous = k
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalid)
+	}
+	return c, nil
+}
+
+// Verify parses the associated data and verifies that it matches the expected context.
+func Verify(data []byte, expected Context) error {
+	c, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return c.Verify(expected)
+}
+
+// Decrypt decrypts the request with the Generic service and verifies that the associated data of
+// the response matches the expected context. If it does not, the plaintext is discarded and an
+// error is returned.
+func Decrypt(ctx context.Context, c pb.GenericClient, request *pb.DecryptRequest, expected Context) (*pb.DecryptResponse, error) {
+	res, err := c.Decrypt(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(res.AssociatedData, expected); err != nil {
+		for i := range res.Plaintext {
+			res.Plaintext[i] = 0
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// Builder builds associated data from a context.
+type Builder struct {
+	context Context
+	err     error
+}
+
+// NewBuilder returns a Builder with an empty context.
+func NewBuilder() *Builder {
+	return &Builder{context: Context{}}
+}
+
+// Set sets the value of a key, replacing any previous value. Keys must not be empty; an empty key
+// is ignored and makes Build return ErrEmptyKey.
+func (b *Builder) Set(key, value string) *Builder {
+	if key == "" {
+		b.err = ErrEmptyKey
+		return b
+	}
+	b.context[key] = value
+	return b
+}
+
+// Tenant sets the tenant the data belongs to.
+func (b *Builder) Tenant(tenant string) *Builder {
+	return b.Set(KeyTenant, tenant)
+}
+
+// Table sets the table the data is stored in.
+func (b *Builder) Table(table string) *Builder {
+	return b.Set(KeyTable, table)
+}
+
+// Column sets the column the data is stored in.
+func (b *Builder) Column(column string) *Builder {
+	return b.Set(KeyColumn, column)
+}
+
+// RowID sets the ID of the row the data is stored in.
+func (b *Builder) RowID(rowID string) *Builder {
+	return b.Set(KeyRowID, rowID)
+}
+
+// Purpose sets what the data is used for.
+func (b *Builder) Purpose(purpose string) *Builder {
+	return b.Set(KeyPurpose, purpose)
+}
+
+// Context returns a copy of the context built so far.
+func (b *Builder) Context() Context {
+	c := make(Context, len(b.context))
+	for k, v := range b.context {
+		c[k] = v
+	}
+	return c
+}
+
+// Build returns the canonical encoding of the context, or ErrEmptyKey if an empty key was set.
+func (b *Builder) Build() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.context.Marshal(), nil
+}
+
+func appendUvarint(data []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(data, buf[:n]...)
+}
+
+func appendString(data []byte, s string) []byte {
+	return append(appendUvarint(data, uint64(len(s))), s...)
+}
+
+func readString(data []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return "", nil, ErrInvalid
+	}
+	data = data[n:]
+	return string(data[:size]), data[size:], nil
+}
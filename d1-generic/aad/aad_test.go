@@ -0,0 +1,140 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aad_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aad"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func build(t *testing.T, b *aad.Builder) []byte {
+	t.Helper()
+	data, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	b := aad.NewBuilder().Tenant("acme").Table("users").Column("email").RowID("42").Set("x", "")
+	data := build(t, b)
+
+	other := aad.NewBuilder().Set("x", "").RowID("42").Column("email").Table("users").Tenant("acme")
+	if !bytes.Equal(data, build(t, other)) {
+		t.Fatal("encoding depends on insertion order")
+	}
+
+	c, err := aad.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(b.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if c[aad.KeyRowID] != "42" || len(c) != 5 {
+		t.Fatalf("unexpected context: %v", c)
+	}
+}
+
+func TestBuildEmptyKey(t *testing.T) {
+	b := aad.NewBuilder().Tenant("acme").Set("", "x").RowID("42")
+	if _, err := b.Build(); !errors.Is(err, aad.ErrEmptyKey) {
+		t.Fatalf("expected ErrEmptyKey, got %v", err)
+	}
+	if c := b.Context(); len(c) != 2 {
+		t.Fatalf("unexpected context: %v", c)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid := aad.Context{"a": "1", "b": "2"}.Marshal()
+	// Swap the two entries so the keys are no longer sorted.
+	unsorted := append(append([]byte{}, valid[:6]...), "\x01b\x012\x01a\x011"...)
+	duplicate := append(append([]byte{}, valid[:6]...), "\x01a\x011\x01a\x012"...)
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"json":      []byte(`{"a": "1"}`),
+		"version":   append([]byte("D1AD"), 2, 0),
+		"truncated": valid[:len(valid)-1],
+		"trailing":  append(append([]byte{}, valid...), 0),
+		"unsorted":  unsorted,
+		"duplicate": duplicate,
+		"empty key": append([]byte("D1AD"), 1, 1, 0, 0),
+	} {
+		if _, err := aad.Parse(data); !errors.Is(err, aad.ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := build(t, aad.NewBuilder().Tenant("acme").RowID("42"))
+
+	for _, tc := range []struct {
+		expected aad.Context
+		key      string
+	}{
+		{aad.Context{aad.KeyTenant: "acme", aad.KeyRowID: "43"}, aad.KeyRowID},
+		{aad.Context{aad.KeyTenant: "acme"}, aad.KeyRowID},
+		{aad.Context{aad.KeyTenant: "acme", aad.KeyRowID: "42", aad.KeyTable: "users"}, aad.KeyTable},
+	} {
+		var mismatch *aad.MismatchError
+		if err := aad.Verify(data, tc.expected); !errors.As(err, &mismatch) || mismatch.Key != tc.key {
+			t.Errorf("expected mismatch for %q, got %v", tc.key, err)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	row := aad.NewBuilder().Tenant("acme").Table("users").Column("email").RowID("1")
+	res, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("alice@example.com"), AssociatedData: build(t, row)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &pb.DecryptRequest{ObjectId: res.ObjectId, Ciphertext: res.Ciphertext, AssociatedData: res.AssociatedData}
+
+	decrypted, err := aad.Decrypt(ctx, c.Generic, request, row.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted.Plaintext) != "alice@example.com" {
+		t.Fatalf("unexpected plaintext %q", decrypted.Plaintext)
+	}
+
+	// The ciphertext copied to another row is rejected.
+	other := aad.NewBuilder().Tenant("acme").Table("users").Column("email").RowID("2")
+	var mismatch *aad.MismatchError
+	if _, err := aad.Decrypt(ctx, c.Generic, request, other.Context()); !errors.As(err, &mismatch) || mismatch.Key != aad.KeyRowID {
+		t.Fatalf("expected a row ID mismatch, got %v", err)
+	}
+}
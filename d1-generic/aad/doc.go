@@ -0,0 +1,41 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package aad builds associated data which binds a ciphertext to the context it is stored in.
+
+D1 authenticates the associated data of an object along with its ciphertext, so a ciphertext only
+decrypts together with the exact associated data it was encrypted with. Encoding the context of the
+data, such as the tenant, table, column and row it belongs to, in the associated data and checking
+it after decryption prevents ciphertexts from being swapped between rows or tables unnoticed:
+
+	builder := aad.NewBuilder().Tenant("acme").Table("users").Column("email").RowID("42")
+	associatedData, err := builder.Build()
+	res, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: email, AssociatedData: associatedData})
+
+	// When reading the row back:
+	res, err := aad.Decrypt(ctx, c.Generic, request, builder.Context())
+
+The encoding is canonical, so the same context always produces the same bytes, and versioned:
+
+	magic    4 bytes  "D1AD"
+	version  1 byte   1
+	count    uvarint  number of entries
+	entry, repeated and sorted by key:
+	  key    uvarint length, followed by the key
+	  value  uvarint length, followed by the value
+
+Keys are non-empty and unique. Parse rejects any encoding that is not canonical.
+*/
+package aad
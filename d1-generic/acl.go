@@ -0,0 +1,186 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/parallel"
+)
+
+// ACLChange describes the difference between the current and the desired ACL of an object, and
+// the outcome of applying it.
+type ACLChange struct {
+	ObjectID  string
+	Added     []string
+	Removed   []string
+	Unchanged []string
+	// Applied is true if the changes were made, which is never the case in a dry run.
+	Applied bool
+	// Err is the error that prevented the object from being reconciled, if any.
+	Err error
+}
+
+// Changed reports whether the ACL differs from the desired state.
+func (c ACLChange) Changed() bool {
+	return len(c.Added) > 0 || len(c.Removed) > 0
+}
+
+// ACLReport is the result of reconciling the ACLs of several objects.
+type ACLReport struct {
+	DryRun bool
+	// Changes contains an entry for every object, ordered by object ID.
+	Changes []ACLChange
+}
+
+// Failed returns the changes that could not be applied.
+func (r ACLReport) Failed() []ACLChange {
+	var failed []ACLChange
+	for _, change := range r.Changes {
+		if change.Err != nil {
+			failed = append(failed, change)
+		}
+	}
+	return failed
+}
+
+// ReconcileOption is used to configure ACL reconciliation.
+type ReconcileOption func(*reconcileConfig)
+
+type reconcileConfig struct {
+	dryRun      bool
+	concurrency int
+}
+
+// WithDryRun returns a ReconcileOption which computes the changes without applying them.
+func WithDryRun() ReconcileOption {
+	return func(c *reconcileConfig) {
+		c.dryRun = true
+	}
+}
+
+// WithReconcileConcurrency returns a ReconcileOption which sets how many objects are reconciled
+// at the same time. The default is 8.
+func WithReconcileConcurrency(n int) ReconcileOption {
+	return func(c *reconcileConfig) {
+		c.concurrency = n
+	}
+}
+
+// ReconcileACL makes the set of groups with access to an object equal to desiredGroups, using at
+// most one AddPermission and one RemovePermission call. Groups are added before any are removed, so
+// the caller does not lose access midway. Note that a desired set without any of the caller's
+// groups revokes the caller's own access.
+func (b *BaseClient) ReconcileACL(ctx context.Context, objectID string, desiredGroups []string, opts ...ReconcileOption) (ACLChange, error) {
+	config := newReconcileConfig(opts)
+	change := b.reconcileACL(ctx, objectID, desiredGroups, config.dryRun)
+	return change, change.Err
+}
+
+// ReconcileACLs reconciles the ACLs of several objects concurrently, given as a map from object ID
+// to desired groups. The report contains the outcome for every object; if any of them failed, an
+// error is returned as well.
+func (b *BaseClient) ReconcileACLs(ctx context.Context, desired map[string][]string, opts ...ReconcileOption) (ACLReport, error) {
+	config := newReconcileConfig(opts)
+
+	objectIDs := make([]string, 0, len(desired))
+	for objectID := range desired {
+		objectIDs = append(objectIDs, objectID)
+	}
+	sort.Strings(objectIDs)
+
+	report := ACLReport{
+		DryRun:  config.dryRun,
+		Changes: make([]ACLChange, len(objectIDs)),
+	}
+	// Objects that are skipped because the context is cancelled keep this placeholder.
+	for i, objectID := range objectIDs {
+		report.Changes[i] = ACLChange{ObjectID: objectID, Err: context.Canceled}
+	}
+
+	parallel.Range(ctx, len(objectIDs), config.concurrency, func(i int) {
+		report.Changes[i] = b.reconcileACL(ctx, objectIDs[i], desired[objectIDs[i]], config.dryRun)
+	})
+
+	if failed := report.Failed(); len(failed) > 0 {
+		return report, fmt.Errorf("%d of %d objects could not be reconciled, first error: %w", len(failed), len(objectIDs), failed[0].Err)
+	}
+	return report, nil
+}
+
+func newReconcileConfig(opts []ReconcileOption) reconcileConfig {
+	config := reconcileConfig{concurrency: 8}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+func (b *BaseClient) reconcileACL(ctx context.Context, objectID string, desiredGroups []string, dryRun bool) ACLChange {
+	change := ACLChange{ObjectID: objectID}
+
+	res, err := b.Authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: objectID})
+	if err != nil {
+		change.Err = err
+		return change
+	}
+
+	desired := map[string]bool{}
+	for _, groupID := range desiredGroups {
+		desired[groupID] = true
+	}
+	current := map[string]bool{}
+	for _, groupID := range res.GroupIds {
+		current[groupID] = true
+		if desired[groupID] {
+			change.Unchanged = append(change.Unchanged, groupID)
+		} else {
+			change.Removed = append(change.Removed, groupID)
+		}
+	}
+	for groupID := range desired {
+		if !current[groupID] {
+			change.Added = append(change.Added, groupID)
+		}
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	sort.Strings(change.Unchanged)
+
+	if dryRun || !change.Changed() {
+		return change
+	}
+
+	if len(change.Added) > 0 {
+		_, err := b.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: objectID, GroupIds: change.Added})
+		if err != nil {
+			change.Err = err
+			return change
+		}
+	}
+	if len(change.Removed) > 0 {
+		_, err := b.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: objectID, GroupIds: change.Removed})
+		if err != nil {
+			change.Err = err
+			return change
+		}
+	}
+
+	change.Applied = true
+	return change
+}
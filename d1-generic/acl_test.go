@@ -0,0 +1,125 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func newTestClient(t *testing.T) (*d1test.Server, client.GenericClient, string) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return server, c, uid
+}
+
+func newGroups(t *testing.T, c client.GenericClient, n int) []string {
+	groups := make([]string, n)
+	for i := range groups {
+		res, err := c.Authn.CreateGroup(context.Background(), &pbauthn.CreateGroupRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups[i] = res.GroupId
+	}
+	return groups
+}
+
+func TestReconcileACL(t *testing.T) {
+	server, c, uid := newTestClient(t)
+	groups := newGroups(t, c, 3)
+	oid := server.NewObject(uid, groups[0], groups[1])
+
+	ctx := context.Background()
+	desired := []string{uid, groups[1], groups[2]}
+
+	change, err := c.ReconcileACL(ctx, oid, desired, client.WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Applied || !reflect.DeepEqual(change.Added, groups[2:]) || !reflect.DeepEqual(change.Removed, groups[:1]) {
+		t.Fatalf("unexpected dry run change: %+v", change)
+	}
+	if !reflect.DeepEqual(server.ObjectGroups(oid), []string{groups[0], groups[1], uid}) {
+		t.Fatalf("dry run modified the ACL: %v", server.ObjectGroups(oid))
+	}
+
+	change, err = c.ReconcileACL(ctx, oid, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !change.Applied {
+		t.Fatalf("change was not applied: %+v", change)
+	}
+	if !reflect.DeepEqual(server.ObjectGroups(oid), []string{groups[1], groups[2], uid}) {
+		t.Fatalf("unexpected ACL: %v", server.ObjectGroups(oid))
+	}
+
+	change, err = c.ReconcileACL(ctx, oid, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Changed() || change.Applied {
+		t.Fatalf("expected no changes: %+v", change)
+	}
+}
+
+func TestReconcileACLs(t *testing.T) {
+	server, c, uid := newTestClient(t)
+	groups := newGroups(t, c, 2)
+
+	desired := map[string][]string{}
+	for i := 0; i < 20; i++ {
+		desired[server.NewObject(uid, groups[0])] = []string{uid, groups[1]}
+	}
+	inaccessible := server.NewObject()
+	desired[inaccessible] = []string{uid}
+
+	report, err := c.ReconcileACLs(context.Background(), desired, client.WithReconcileConcurrency(4))
+	if err == nil {
+		t.Fatal("expected an error for the inaccessible object")
+	}
+
+	failed := report.Failed()
+	if len(failed) != 1 || failed[0].ObjectID != inaccessible {
+		t.Fatalf("unexpected failures: %+v", failed)
+	}
+	if len(report.Changes) != len(desired) {
+		t.Fatalf("expected %d changes, got %d", len(desired), len(report.Changes))
+	}
+	for _, change := range report.Changes {
+		if change.ObjectID == inaccessible {
+			continue
+		}
+		if !change.Applied {
+			t.Fatalf("change was not applied: %+v", change)
+		}
+		if !reflect.DeepEqual(server.ObjectGroups(change.ObjectID), []string{groups[1], uid}) {
+			t.Fatalf("unexpected ACL for %s: %v", change.ObjectID, server.ObjectGroups(change.ObjectID))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aclaudit_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aclaudit"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/registry"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestExportAndDiff(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	other, _ := server.NewUser()
+	c, err := client.NewBaseClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	shared := server.NewObject(uid, other)
+	private := server.NewObject(uid)
+	inaccessible := server.NewObject(other)
+
+	path := filepath.Join(t.TempDir(), "objects.txt")
+	list := "# objects to audit\n" + shared + "\n\n" + private + "\n" + inaccessible + "\n" + shared + "\n"
+	if err := os.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	before, err := aclaudit.Run(ctx, c.Authz, aclaudit.FileSource(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Entries) != 3 {
+		t.Fatalf("unexpected entries: %+v", before.Entries)
+	}
+	for _, entry := range before.Entries {
+		if (entry.Error != "") != (entry.ObjectID == inaccessible) {
+			t.Fatalf("unexpected entry: %+v", entry)
+		}
+	}
+
+	// Both formats round trip.
+	for _, format := range []string{"json", "csv"} {
+		var buf bytes.Buffer
+		if err := aclaudit.Write(&buf, before, format); err != nil {
+			t.Fatal(err)
+		}
+		read, err := aclaudit.Read(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read.Entries, before.Entries) {
+			t.Fatalf("%s round trip mismatch:\n%+v\n%+v", format, read.Entries, before.Entries)
+		}
+	}
+
+	if _, err := c.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: shared, GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: private, GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := aclaudit.Run(ctx, c.Authz, aclaudit.SliceSource(shared, private, inaccessible))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := aclaudit.WriteDiff(&out, aclaudit.Diff(before, after)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"- " + shared + " " + other,
+		"+ " + private + " " + other,
+	}
+	if shared > private {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected diff:\n%s", out.String())
+	}
+}
+
+func TestRegistrySource(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	reg, err := registry.New(&registry.MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd),
+		client.WithGrpcOption(grpc.WithChainUnaryInterceptor(reg.UnaryClientInterceptor())),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := aclaudit.Run(ctx, c.Authz, aclaudit.RegistrySource(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Entries) != 1 || export.Entries[0].ObjectID != encrypted.ObjectId || !reflect.DeepEqual(export.Entries[0].GroupIDs, []string{uid}) {
+		t.Fatalf("unexpected entries: %+v", export.Entries)
+	}
+}
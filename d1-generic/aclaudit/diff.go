@@ -0,0 +1,113 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aclaudit
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Change describes how the ACL of an object differs between two exports.
+type Change struct {
+	ObjectID string
+	// Granted contains the groups that have gained access.
+	Granted []string
+	// Revoked contains the groups that have lost access.
+	Revoked []string
+	// OnlyInOld and OnlyInNew are set if the object is only present in one of the exports, in which
+	// case all of its groups are reported as revoked or granted respectively.
+	OnlyInOld bool
+	OnlyInNew bool
+}
+
+// Diff compares two exports and returns the changes ordered by object ID. Objects whose ACL could
+// not be read in either export are skipped, as their ACL is unknown.
+func Diff(old, new *Export) []Change {
+	before := entriesByID(old)
+	after := entriesByID(new)
+
+	var changes []Change
+	for objectID, oldEntry := range before {
+		newEntry, ok := after[objectID]
+		if oldEntry.Error != "" || (ok && newEntry.Error != "") {
+			continue
+		}
+		if !ok {
+			if len(oldEntry.GroupIDs) > 0 {
+				changes = append(changes, Change{ObjectID: objectID, Revoked: oldEntry.GroupIDs, OnlyInOld: true})
+			}
+			continue
+		}
+
+		change := Change{
+			ObjectID: objectID,
+			Granted:  subtract(newEntry.GroupIDs, oldEntry.GroupIDs),
+			Revoked:  subtract(oldEntry.GroupIDs, newEntry.GroupIDs),
+		}
+		if len(change.Granted) > 0 || len(change.Revoked) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	for objectID, newEntry := range after {
+		if _, ok := before[objectID]; ok || newEntry.Error != "" || len(newEntry.GroupIDs) == 0 {
+			continue
+		}
+		changes = append(changes, Change{ObjectID: objectID, Granted: newEntry.GroupIDs, OnlyInNew: true})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ObjectID < changes[j].ObjectID })
+	return changes
+}
+
+// WriteDiff writes the changes as text, one line per granted (+) or revoked (-) group.
+func WriteDiff(w io.Writer, changes []Change) error {
+	for _, change := range changes {
+		for _, groupID := range change.Granted {
+			if _, err := fmt.Fprintf(w, "+ %s %s\n", change.ObjectID, groupID); err != nil {
+				return err
+			}
+		}
+		for _, groupID := range change.Revoked {
+			if _, err := fmt.Fprintf(w, "- %s %s\n", change.ObjectID, groupID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func entriesByID(export *Export) map[string]Entry {
+	entries := map[string]Entry{}
+	for _, entry := range export.Entries {
+		entries[entry.ObjectID] = entry
+	}
+	return entries
+}
+
+// subtract returns the elements of a that are not in b, preserving order.
+func subtract(a, b []string) []string {
+	set := map[string]bool{}
+	for _, v := range b {
+		set[v] = true
+	}
+	var out []string
+	for _, v := range a {
+		if !set[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package aclaudit exports the ACLs of D1 objects and compares exports.
+
+An export is built by calling GetPermissions for every object listed by a Source, and can be
+written and read back as JSON or CSV. Diff compares two exports and reports the groups that were
+granted or revoked access in between.
+
+The d1-acl-audit command in this repository wraps the package for use from the command line.
+*/
+package aclaudit
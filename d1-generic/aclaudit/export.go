@@ -0,0 +1,224 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aclaudit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/parallel"
+)
+
+// Entry is the ACL of a single object. If the ACL could not be read, Error describes why.
+type Entry struct {
+	ObjectID string   `json:"object_id"`
+	GroupIDs []string `json:"group_ids"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Export is a snapshot of the ACLs of a set of objects, ordered by object ID.
+type Export struct {
+	Time    time.Time `json:"time"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Option is used to configure optional settings on an export.
+type Option func(*config)
+
+type config struct {
+	concurrency int
+}
+
+// WithConcurrency returns an Option which sets how many GetPermissions calls are made at the same
+// time. The default is 8.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// Run exports the ACLs of the objects listed by the source. Objects whose ACL cannot be read are
+// included with an error, so they stand out in the export.
+func Run(ctx context.Context, authz pbauthz.AuthzClient, source Source, opts ...Option) (*Export, error) {
+	cfg := config{concurrency: 8}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	objectIDs, err := source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objectIDs = dedupe(objectIDs)
+
+	export := &Export{
+		Time:    time.Now().UTC(),
+		Entries: make([]Entry, len(objectIDs)),
+	}
+	parallel.Range(ctx, len(objectIDs), cfg.concurrency, func(i int) {
+		entry := Entry{ObjectID: objectIDs[i], GroupIDs: []string{}}
+		res, err := authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: objectIDs[i]})
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.GroupIDs = append(entry.GroupIDs, res.GroupIds...)
+			sort.Strings(entry.GroupIDs)
+		}
+		export.Entries[i] = entry
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// WriteJSON writes the export as an indented JSON document.
+func WriteJSON(w io.Writer, export *Export) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// ReadJSON reads an export written by WriteJSON.
+func ReadJSON(r io.Reader) (*Export, error) {
+	var export Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+	export.normalize()
+	return &export, nil
+}
+
+var csvHeader = []string{"object_id", "group_id", "error"}
+
+// WriteCSV writes the export as CSV with one row per object and group. Objects without any groups
+// get a single row with an empty group ID. The export time is not included.
+func WriteCSV(w io.Writer, export *Export) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, entry := range export.Entries {
+		if len(entry.GroupIDs) == 0 {
+			if err := writer.Write([]string{entry.ObjectID, "", entry.Error}); err != nil {
+				return err
+			}
+		}
+		for _, groupID := range entry.GroupIDs {
+			if err := writer.Write([]string{entry.ObjectID, groupID, entry.Error}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadCSV reads an export written by WriteCSV.
+func ReadCSV(r io.Reader) (*Export, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range csvHeader {
+		if header[i] != csvHeader[i] {
+			return nil, errors.New("unexpected CSV header")
+		}
+	}
+
+	entries := map[string]*Entry{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry, ok := entries[record[0]]
+		if !ok {
+			entry = &Entry{ObjectID: record[0], GroupIDs: []string{}, Error: record[2]}
+			entries[record[0]] = entry
+		}
+		if record[1] != "" {
+			entry.GroupIDs = append(entry.GroupIDs, record[1])
+		}
+	}
+
+	export := &Export{}
+	for _, entry := range entries {
+		export.Entries = append(export.Entries, *entry)
+	}
+	export.normalize()
+	return export, nil
+}
+
+// Read reads an export in the given format, either "json" or "csv".
+func Read(r io.Reader, format string) (*Export, error) {
+	switch format {
+	case "json":
+		return ReadJSON(r)
+	case "csv":
+		return ReadCSV(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Write writes an export in the given format, either "json" or "csv".
+func Write(w io.Writer, export *Export, format string) error {
+	switch format {
+	case "json":
+		return WriteJSON(w, export)
+	case "csv":
+		return WriteCSV(w, export)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// normalize sorts entries and groups so exports can be compared.
+func (e *Export) normalize() {
+	for i := range e.Entries {
+		if e.Entries[i].GroupIDs == nil {
+			e.Entries[i].GroupIDs = []string{}
+		}
+		sort.Strings(e.Entries[i].GroupIDs)
+	}
+	sort.Slice(e.Entries, func(i, j int) bool { return e.Entries[i].ObjectID < e.Entries[j].ObjectID })
+}
+
+func dedupe(objectIDs []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(objectIDs))
+	for _, id := range objectIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
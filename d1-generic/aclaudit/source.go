@@ -0,0 +1,93 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aclaudit
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pbindex "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/index"
+)
+
+// Source lists the IDs of the objects to audit.
+type Source func(ctx context.Context) ([]string, error)
+
+// ObjectLister is implemented by local registries that know which objects exist, such as
+// registry.Registry and collection.Manager.
+type ObjectLister interface {
+	ObjectIDs() []string
+}
+
+// SliceSource returns a Source listing the given object IDs.
+func SliceSource(objectIDs ...string) Source {
+	return func(context.Context) ([]string, error) {
+		return objectIDs, nil
+	}
+}
+
+// FileSource returns a Source reading object IDs from a text file with one ID per line. Empty
+// lines and lines starting with # are ignored.
+func FileSource(path string) Source {
+	return func(context.Context) ([]string, error) {
+		file, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		var objectIDs []string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			objectIDs = append(objectIDs, line)
+		}
+		return objectIDs, scanner.Err()
+	}
+}
+
+// IndexSource returns a Source listing the identifiers found in the secure index for any of the
+// given keywords. This requires that object IDs are used as index identifiers.
+func IndexSource(index pbindex.IndexClient, keywords ...string) Source {
+	return func(ctx context.Context) ([]string, error) {
+		seen := map[string]bool{}
+		var objectIDs []string
+		for _, keyword := range keywords {
+			res, err := index.Search(ctx, &pbindex.SearchRequest{Keyword: keyword})
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range res.Identifiers {
+				if !seen[id] {
+					seen[id] = true
+					objectIDs = append(objectIDs, id)
+				}
+			}
+		}
+		return objectIDs, nil
+	}
+}
+
+// RegistrySource returns a Source listing the objects known to a local registry.
+func RegistrySource(registry ObjectLister) Source {
+	return func(context.Context) ([]string, error) {
+		return registry.ObjectIDs(), nil
+	}
+}
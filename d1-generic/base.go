@@ -12,11 +12,6 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Code generated by copy-client.sh. DO NOT EDIT.
-// version: v2.0.0
-// source: https://github.com/cybercryptio/d1-service-generic.git
-// commit: 05a3b04a1916d3e6c1bfea65041b7536cf93778e
-
 package client
 
 import (
@@ -36,6 +31,10 @@ type BaseClient struct {
 	Health     grpc_health_v1.HealthClient
 	Index      pbindex.IndexClient
 	Connection *grpc.ClientConn
+
+	// state holds settings made by options which are needed after the client was created. It is
+	// shared by the copies of the client.
+	state *clientState
 }
 
 // Option is used configure optional settings on the client.
@@ -51,7 +50,7 @@ func WithGrpcOption(option grpc.DialOption) Option {
 // NewBaseClient creates a new client for the given endpoint, configured with the provided options.
 func NewBaseClient(endpoint string, opts ...Option) (BaseClient, error) {
 	var err error
-	baseClient := BaseClient{}
+	baseClient := BaseClient{state: &clientState{}}
 
 	grpcOpts := []grpc.DialOption{}
 	for _, opt := range opts {
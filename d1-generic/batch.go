@@ -0,0 +1,197 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/internal/parallel"
+)
+
+// BatchResult is the outcome of a batch operation on a single item.
+type BatchResult[T any] struct {
+	// Index is the position of the item in the batch.
+	Index    int
+	Response T
+	Err      error
+}
+
+// BatchError is returned by the batch operations when some of the items failed. The errors of the
+// individual items are reported in their results.
+type BatchError struct {
+	// Failed holds the indexes of the failed items in ascending order.
+	Failed []int
+	Total  int
+	first  error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d batch items failed, first error: %v", len(e.Failed), e.Total, e.first)
+}
+
+// Unwrap returns the error of the first failed item.
+func (e *BatchError) Unwrap() error {
+	return e.first
+}
+
+// BatchOption is used to configure batch operations.
+type BatchOption func(*batchConfig)
+
+type batchConfig struct {
+	concurrency int
+	progress    func(done, failed int)
+}
+
+// WithBatchConcurrency returns a BatchOption which sets how many calls are made at the same time.
+// The default is 8.
+func WithBatchConcurrency(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithProgress returns a BatchOption which calls fn each time an item has completed, with the
+// number of items completed so far and how many of them failed. Items complete in order, and fn is
+// never called concurrently.
+func WithProgress(fn func(done, failed int)) BatchOption {
+	return func(c *batchConfig) {
+		c.progress = fn
+	}
+}
+
+type batchItem[T any] struct {
+	index   int
+	request T
+}
+
+// Batch calls call for every request using a bounded number of concurrent calls, and returns a
+// result for every request in the same order. If any of the calls failed a *BatchError is returned
+// along with the results. If the context is cancelled, the requests that were not started are
+// given the context's error, and that error is returned.
+func Batch[Req, Res any](ctx context.Context, requests []Req, call func(context.Context, Req) (Res, error), opts ...BatchOption) ([]BatchResult[Res], error) {
+	ch := make(chan Req, len(requests))
+	for _, request := range requests {
+		ch <- request
+	}
+	close(ch)
+
+	results := make([]BatchResult[Res], 0, len(requests))
+	for result := range BatchStream(ctx, ch, call, opts...) {
+		results = append(results, result)
+	}
+	for i := len(results); i < len(requests); i++ {
+		results = append(results, BatchResult[Res]{Index: i, Err: ctx.Err()})
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	batchErr := &BatchError{Total: len(results)}
+	for _, result := range results {
+		if result.Err != nil {
+			if batchErr.first == nil {
+				batchErr.first = result.Err
+			}
+			batchErr.Failed = append(batchErr.Failed, result.Index)
+		}
+	}
+	if len(batchErr.Failed) > 0 {
+		return results, batchErr
+	}
+	return results, nil
+}
+
+// BatchStream calls call for every request received from requests using a bounded number of
+// concurrent calls, and sends the results in the order the requests were received. The result
+// channel is closed once requests is closed and all calls have finished. If the context is
+// cancelled no further requests are received. The caller must drain the result channel.
+func BatchStream[Req, Res any](ctx context.Context, requests <-chan Req, call func(context.Context, Req) (Res, error), opts ...BatchOption) <-chan BatchResult[Res] {
+	config := batchConfig{concurrency: 8}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	items := make(chan batchItem[Req])
+	go func() {
+		defer close(items)
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case request, ok := <-requests:
+				if !ok {
+					return
+				}
+				select {
+				case items <- batchItem[Req]{index: i, request: request}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	ordered := parallel.Ordered(ctx, items, config.concurrency, func(item batchItem[Req]) BatchResult[Res] {
+		response, err := call(ctx, item.request)
+		return BatchResult[Res]{Index: item.index, Response: response, Err: err}
+	})
+
+	results := make(chan BatchResult[Res])
+	go func() {
+		defer close(results)
+		done, failed := 0, 0
+		for result := range ordered {
+			done++
+			if result.Err != nil {
+				failed++
+			}
+			if config.progress != nil {
+				config.progress(done, failed)
+			}
+			results <- result
+		}
+	}()
+	return results
+}
+
+// EncryptBatch encrypts every request as described for Batch.
+func (c *GenericClient) EncryptBatch(ctx context.Context, requests []*pb.EncryptRequest, opts ...BatchOption) ([]BatchResult[*pb.EncryptResponse], error) {
+	return Batch(ctx, requests, c.encrypt, opts...)
+}
+
+// EncryptBatchStream encrypts every request received from requests as described for BatchStream.
+func (c *GenericClient) EncryptBatchStream(ctx context.Context, requests <-chan *pb.EncryptRequest, opts ...BatchOption) <-chan BatchResult[*pb.EncryptResponse] {
+	return BatchStream(ctx, requests, c.encrypt, opts...)
+}
+
+// DecryptBatch decrypts every request as described for Batch.
+func (c *GenericClient) DecryptBatch(ctx context.Context, requests []*pb.DecryptRequest, opts ...BatchOption) ([]BatchResult[*pb.DecryptResponse], error) {
+	return Batch(ctx, requests, c.decrypt, opts...)
+}
+
+// DecryptBatchStream decrypts every request received from requests as described for BatchStream.
+func (c *GenericClient) DecryptBatchStream(ctx context.Context, requests <-chan *pb.DecryptRequest, opts ...BatchOption) <-chan BatchResult[*pb.DecryptResponse] {
+	return BatchStream(ctx, requests, c.decrypt, opts...)
+}
+
+func (c *GenericClient) encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	return c.Generic.Encrypt(ctx, request)
+}
+
+func (c *GenericClient) decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	return c.Generic.Decrypt(ctx, request)
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	var requests []*pb.EncryptRequest
+	for i := 0; i < 20; i++ {
+		requests = append(requests, &pb.EncryptRequest{Plaintext: []byte(fmt.Sprint(i))})
+	}
+
+	var progress []int
+	encrypted, err := c.EncryptBatch(ctx, requests, client.WithBatchConcurrency(3), client.WithProgress(func(done, failed int) {
+		progress = append(progress, done)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != len(requests) || progress[len(progress)-1] != len(requests) {
+		t.Fatalf("unexpected progress: %v", progress)
+	}
+
+	var decryptRequests []*pb.DecryptRequest
+	for i, result := range encrypted {
+		if result.Index != i || result.Err != nil {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+		decryptRequests = append(decryptRequests, &pb.DecryptRequest{ObjectId: result.Response.ObjectId, Ciphertext: result.Response.Ciphertext})
+	}
+
+	decrypted, err := c.DecryptBatch(ctx, decryptRequests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range decrypted {
+		if !bytes.Equal(result.Response.Plaintext, []byte(fmt.Sprint(i))) {
+			t.Fatalf("result %d out of order: %q", i, result.Response.Plaintext)
+		}
+	}
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext}
+	bad := &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: []byte("garbage")}
+
+	var lastFailed int
+	results, err := c.DecryptBatch(ctx, []*pb.DecryptRequest{good, bad, good, bad}, client.WithProgress(func(done, failed int) {
+		lastFailed = failed
+	}))
+	var batchErr *client.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected a BatchError, got %v", err)
+	}
+	if fmt.Sprint(batchErr.Failed) != "[1 3]" || batchErr.Total != 4 || lastFailed != 2 {
+		t.Fatalf("unexpected failures: %+v, progress reported %d", batchErr, lastFailed)
+	}
+	for i, result := range results {
+		if (result.Err != nil) != (i%2 == 1) {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+	}
+}
+
+func TestBatchCancel(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	requests := make([]*pb.EncryptRequest, 5)
+	for i := range requests {
+		requests[i] = &pb.EncryptRequest{Plaintext: []byte("plaintext")}
+	}
+	results, err := c.EncryptBatch(ctx, requests)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("expected %d results, got %d", len(requests), len(results))
+	}
+	for i, result := range results {
+		if result.Index != i || result.Err == nil {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+	}
+}
+
+func TestBatchStream(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	requests := make(chan *pb.EncryptRequest)
+	go func() {
+		defer close(requests)
+		for i := 0; i < 10; i++ {
+			requests <- &pb.EncryptRequest{Plaintext: []byte(fmt.Sprint(i))}
+		}
+	}()
+
+	n := 0
+	for result := range c.EncryptBatchStream(ctx, requests, client.WithBatchConcurrency(4)) {
+		if result.Index != n || result.Err != nil {
+			t.Fatalf("unexpected result %d: %+v", n, result)
+		}
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 results, got %d", n)
+	}
+}
+
+// TestBatchTokenRefresh starts a batch on a client that has not logged in yet, so the first calls
+// all need a token at the same time. Run with -race to check the token refresh.
+func TestBatchTokenRefresh(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	var logins int32
+	countLogins := grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if method == "/d1.authn.Authn/LoginUser" {
+			atomic.AddInt32(&logins, 1)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithGrpcOption(countLogins))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	requests := make([]*pb.EncryptRequest, 32)
+	for i := range requests {
+		requests[i] = &pb.EncryptRequest{Plaintext: []byte(fmt.Sprint(i))}
+	}
+	if _, err := c.EncryptBatch(context.Background(), requests, client.WithBatchConcurrency(8)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Fatalf("expected one login, got %d", n)
+	}
+}
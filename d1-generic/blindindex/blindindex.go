@@ -0,0 +1,149 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blindindex
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pbindex "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/index"
+)
+
+// Normalizer maps a column value to the form used for equality matching.
+type Normalizer func(string) string
+
+// DefaultNormalizer trims surrounding white space and lower-cases the value.
+func DefaultNormalizer(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// Column is the blind index of a single column.
+type Column struct {
+	index     pbindex.IndexClient
+	name      string
+	normalize Normalizer
+}
+
+// Option is used to configure optional settings on a Column.
+type Option func(*Column)
+
+// WithNormalizer returns an Option which sets how values are normalised before they are indexed
+// and searched. The default is DefaultNormalizer.
+func WithNormalizer(normalize Normalizer) Option {
+	return func(c *Column) {
+		c.normalize = normalize
+	}
+}
+
+// NewColumn creates the blind index of the named column, such as "users.email".
+func NewColumn(index pbindex.IndexClient, name string, opts ...Option) *Column {
+	c := &Column{
+		index:     index,
+		name:      name,
+		normalize: DefaultNormalizer,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Keyword returns the keyword under which a value is indexed.
+func (c *Column) Keyword(value string) string {
+	return c.name + ":" + c.normalize(value)
+}
+
+// Add indexes the value of a row.
+func (c *Column) Add(ctx context.Context, rowID, value string) error {
+	_, err := c.index.Add(ctx, &pbindex.AddRequest{
+		Keywords:   []string{c.Keyword(value)},
+		Identifier: rowID,
+	})
+	return err
+}
+
+// Delete removes the value of a row from the index.
+func (c *Column) Delete(ctx context.Context, rowID, value string) error {
+	_, err := c.index.Delete(ctx, &pbindex.DeleteRequest{
+		Keywords:   []string{c.Keyword(value)},
+		Identifier: rowID,
+	})
+	return err
+}
+
+// Update replaces the indexed value of a row. The new value is added before the old one is
+// removed, so the row can always be found by at least one of them.
+func (c *Column) Update(ctx context.Context, rowID, oldValue, newValue string) error {
+	if c.Keyword(oldValue) == c.Keyword(newValue) {
+		return nil
+	}
+	if err := c.Add(ctx, rowID, newValue); err != nil {
+		return err
+	}
+	return c.Delete(ctx, rowID, oldValue)
+}
+
+// Lookup returns the IDs of the rows whose value equals the given value after normalisation.
+func (c *Column) Lookup(ctx context.Context, value string) ([]string, error) {
+	res, err := c.index.Search(ctx, &pbindex.SearchRequest{Keyword: c.Keyword(value)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Identifiers, nil
+}
+
+// Where looks up a value and returns a SQL condition selecting the matching rows by their ID
+// column, along with its arguments.
+func (c *Column) Where(ctx context.Context, idColumn, value string, placeholder Placeholder) (string, []interface{}, error) {
+	rowIDs, err := c.Lookup(ctx, value)
+	if err != nil {
+		return "", nil, err
+	}
+	clause, args := In(idColumn, rowIDs, placeholder)
+	return clause, args, nil
+}
+
+// Placeholder returns the SQL placeholder of the i'th argument of an IN clause, counting from 0.
+type Placeholder func(i int) string
+
+// Question is the Placeholder used by MySQL and SQLite.
+func Question(int) string {
+	return "?"
+}
+
+// Dollar returns the Placeholder used by PostgreSQL, numbering the arguments after the given
+// number of arguments already in the query.
+func Dollar(offset int) Placeholder {
+	return func(i int) string {
+		return fmt.Sprintf("$%d", offset+i+1)
+	}
+}
+
+// In returns the SQL condition "column IN (...)" for the given IDs, along with its arguments. An
+// empty list of IDs results in a condition that matches no rows.
+func In(column string, ids []string, placeholder Placeholder) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "1 = 0", nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = placeholder(i)
+		args[i] = id
+	}
+	return fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")), args
+}
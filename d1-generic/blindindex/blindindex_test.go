@@ -0,0 +1,87 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blindindex_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/blindindex"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestColumn(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewBaseClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	email := blindindex.NewColumn(c.Index, "users.email")
+	other := blindindex.NewColumn(c.Index, "users.backup_email")
+
+	for rowID, value := range map[string]string{"1": "Alice@Example.com", "2": "bob@example.com", "3": " alice@example.com "} {
+		if err := email.Add(ctx, rowID, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.Add(ctx, "4", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	clause, args, err := email.Where(ctx, "id", "ALICE@example.com", blindindex.Dollar(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id IN ($2, $3)"; clause != want {
+		t.Fatalf("got clause %q, want %q", clause, want)
+	}
+	if want := []interface{}{"1", "3"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("got args %v, want %v", args, want)
+	}
+
+	if err := email.Update(ctx, "1", "alice@example.com", "carol@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := email.Delete(ctx, "3", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := email.Lookup(ctx, "alice@example.com"); err != nil || len(ids) != 0 {
+		t.Fatalf("expected no rows, got %v, %v", ids, err)
+	}
+	if ids, err := email.Lookup(ctx, "carol@example.com"); err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("expected row 1, got %v, %v", ids, err)
+	}
+
+	clause, args, err = email.Where(ctx, "id", "nobody@example.com", blindindex.Question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clause != "1 = 0" || len(args) != 0 {
+		t.Fatalf("got %q, %v", clause, args)
+	}
+}
+
+func TestIn(t *testing.T) {
+	clause, args := blindindex.In("users.id", []string{"a", "b", "c"}, blindindex.Question)
+	if clause != "users.id IN (?, ?, ?)" || len(args) != 3 {
+		t.Fatalf("got %q, %v", clause, args)
+	}
+}
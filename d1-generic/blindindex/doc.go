@@ -0,0 +1,31 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package blindindex provides equality search over encrypted SQL columns using the D1 secure index.
+
+When a row is written, the normalised value of the encrypted column is added to the secure index as
+a keyword, with the row ID as identifier. An equality lookup searches the index for the keyword
+and turns the matching row IDs into a SQL IN clause:
+
+	email := blindindex.NewColumn(c.Index, "users.email")
+	err := email.Add(ctx, rowID, "Alice@Example.com")
+
+	clause, args, err := email.Where(ctx, "id", "alice@example.com", blindindex.Dollar(0))
+	rows, err := db.QueryContext(ctx, "SELECT id, email FROM users WHERE "+clause, args...)
+
+Keywords are prefixed with the column name, so equal values in different columns do not match.
+The index must be kept in sync with the table by calling Update and Delete when rows change.
+*/
+package blindindex
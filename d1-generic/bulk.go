@@ -0,0 +1,167 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/checkpoint"
+	"github.com/cybercryptio/d1-client-go/v2/internal/parallel"
+)
+
+// BulkResult is the outcome of a bulk operation on a single object.
+type BulkResult struct {
+	ObjectID string
+	// HasPermission is the result of the permission check. It is only set by CheckAll.
+	HasPermission bool
+	// Skipped is true if the object was already completed according to the checkpoint.
+	Skipped bool
+	Err     error
+}
+
+// BulkOption is used to configure bulk permission operations.
+type BulkOption func(*bulkConfig)
+
+type bulkConfig struct {
+	concurrency    int
+	rateLimit      float64
+	checkpointPath string
+}
+
+// WithBulkConcurrency returns a BulkOption which sets how many calls are made at the same time.
+// The default is 8.
+func WithBulkConcurrency(n int) BulkOption {
+	return func(c *bulkConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithRateLimit returns a BulkOption which limits the number of calls made per second.
+func WithRateLimit(perSecond float64) BulkOption {
+	return func(c *bulkConfig) {
+		c.rateLimit = perSecond
+	}
+}
+
+// WithCheckpoint returns a BulkOption which records completed objects in the file at path. When
+// the same operation is started again with the same checkpoint, objects that were already
+// completed are skipped. A checkpoint cannot be reused for a different operation or set of groups.
+func WithCheckpoint(path string) BulkOption {
+	return func(c *bulkConfig) {
+		c.checkpointPath = path
+	}
+}
+
+// ObjectIDsFromSlice returns a closed channel containing the given object IDs, for use with the
+// bulk operations.
+func ObjectIDsFromSlice(objectIDs []string) <-chan string {
+	ch := make(chan string, len(objectIDs))
+	for _, objectID := range objectIDs {
+		ch <- objectID
+	}
+	close(ch)
+	return ch
+}
+
+// GrantAll gives the groups access to every object received from objectIDs. A result is sent for
+// every object, and the result channel is closed once objectIDs is closed and all calls have
+// finished, or the context is cancelled. The caller must drain the result channel.
+func (b *BaseClient) GrantAll(ctx context.Context, objectIDs <-chan string, groupIDs []string, opts ...BulkOption) (<-chan BulkResult, error) {
+	return b.bulk(ctx, objectIDs, "grant:"+signature(groupIDs), opts, func(objectID string) BulkResult {
+		_, err := b.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: objectID, GroupIds: groupIDs})
+		return BulkResult{ObjectID: objectID, Err: err}
+	})
+}
+
+// RevokeAll removes the groups' access to every object received from objectIDs. Results are
+// reported as for GrantAll.
+func (b *BaseClient) RevokeAll(ctx context.Context, objectIDs <-chan string, groupIDs []string, opts ...BulkOption) (<-chan BulkResult, error) {
+	return b.bulk(ctx, objectIDs, "revoke:"+signature(groupIDs), opts, func(objectID string) BulkResult {
+		_, err := b.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: objectID, GroupIds: groupIDs})
+		return BulkResult{ObjectID: objectID, Err: err}
+	})
+}
+
+// CheckAll checks whether the caller has access to every object received from objectIDs. Results
+// are reported as for GrantAll. When resuming from a checkpoint, only the objects the caller was
+// found to have access to are skipped.
+func (b *BaseClient) CheckAll(ctx context.Context, objectIDs <-chan string, opts ...BulkOption) (<-chan BulkResult, error) {
+	return b.bulk(ctx, objectIDs, "check", opts, func(objectID string) BulkResult {
+		res, err := b.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: objectID})
+		if err != nil {
+			return BulkResult{ObjectID: objectID, Err: err}
+		}
+		return BulkResult{ObjectID: objectID, HasPermission: res.HasPermission}
+	})
+}
+
+func (b *BaseClient) bulk(ctx context.Context, objectIDs <-chan string, job string, opts []BulkOption, call func(string) BulkResult) (<-chan BulkResult, error) {
+	config := bulkConfig{concurrency: 8}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	var cp *checkpoint.File
+	if config.checkpointPath != "" {
+		var err error
+		cp, err = checkpoint.Open(config.checkpointPath, job)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(chan BulkResult)
+	go func() {
+		defer close(results)
+		if cp != nil {
+			defer cp.Close()
+		}
+
+		limiter := parallel.NewLimiter(config.rateLimit)
+		defer limiter.Stop()
+
+		parallel.ForEach(ctx, objectIDs, config.concurrency, func(objectID string) {
+			var result BulkResult
+			switch {
+			case cp != nil && cp.Done(objectID):
+				result = BulkResult{ObjectID: objectID, Skipped: true, HasPermission: job == "check"}
+			case limiter.Wait(ctx) != nil:
+				result = BulkResult{ObjectID: objectID, Err: ctx.Err()}
+			default:
+				result = call(objectID)
+				if cp != nil && result.Err == nil && (job != "check" || result.HasPermission) {
+					result.Err = cp.Mark(objectID)
+				}
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return results, nil
+}
+
+// signature returns a canonical representation of a set of group IDs.
+func signature(groupIDs []string) string {
+	sorted := append([]string(nil), groupIDs...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
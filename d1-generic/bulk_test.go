@@ -0,0 +1,113 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+)
+
+type bulkCounts struct {
+	ok, skipped, failed, allowed int
+}
+
+func collect(t *testing.T, results <-chan client.BulkResult, err error) bulkCounts {
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var counts bulkCounts
+	for result := range results {
+		switch {
+		case result.Err != nil:
+			counts.failed++
+		case result.Skipped:
+			counts.skipped++
+		default:
+			counts.ok++
+		}
+		if result.HasPermission {
+			counts.allowed++
+		}
+	}
+	return counts
+}
+
+func TestBulkResumesFromCheckpoint(t *testing.T) {
+	server, c, uid := newTestClient(t)
+	group := newGroups(t, c, 1)[0]
+
+	var objectIDs []string
+	for i := 0; i < 50; i++ {
+		objectIDs = append(objectIDs, server.NewObject(uid))
+	}
+	for i := 0; i < 5; i++ {
+		objectIDs = append(objectIDs, server.NewObject())
+	}
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "grant.checkpoint")
+
+	results, err := c.GrantAll(ctx, client.ObjectIDsFromSlice(objectIDs), []string{group},
+		client.WithCheckpoint(path), client.WithBulkConcurrency(4), client.WithRateLimit(10000))
+	if counts := collect(t, results, err); counts != (bulkCounts{ok: 50, failed: 5}) {
+		t.Fatalf("unexpected first run: %+v", counts)
+	}
+	for _, oid := range objectIDs[:50] {
+		if groups := server.ObjectGroups(oid); len(groups) != 2 {
+			t.Fatalf("unexpected ACL for %s: %v", oid, groups)
+		}
+	}
+
+	results, err = c.GrantAll(ctx, client.ObjectIDsFromSlice(objectIDs), []string{group}, client.WithCheckpoint(path))
+	if counts := collect(t, results, err); counts != (bulkCounts{skipped: 50, failed: 5}) {
+		t.Fatalf("unexpected resumed run: %+v", counts)
+	}
+
+	// The checkpoint cannot be reused for another operation.
+	if _, err := c.RevokeAll(ctx, client.ObjectIDsFromSlice(objectIDs), []string{group}, client.WithCheckpoint(path)); err == nil {
+		t.Fatal("expected checkpoint mismatch")
+	}
+}
+
+func TestCheckAllAndRevokeAll(t *testing.T) {
+	server, c, uid := newTestClient(t)
+	group := newGroups(t, c, 1)[0]
+
+	var objectIDs []string
+	for i := 0; i < 10; i++ {
+		objectIDs = append(objectIDs, server.NewObject(uid, group))
+	}
+	objectIDs = append(objectIDs, server.NewObject())
+
+	ctx := context.Background()
+	results, err := c.CheckAll(ctx, client.ObjectIDsFromSlice(objectIDs))
+	if counts := collect(t, results, err); counts != (bulkCounts{ok: 11, allowed: 10}) {
+		t.Fatalf("unexpected check results: %+v", counts)
+	}
+
+	results, err = c.RevokeAll(ctx, client.ObjectIDsFromSlice(objectIDs[:10]), []string{group})
+	if counts := collect(t, results, err); counts != (bulkCounts{ok: 10}) {
+		t.Fatalf("unexpected revoke results: %+v", counts)
+	}
+	for _, oid := range objectIDs[:10] {
+		if groups := server.ObjectGroups(oid); len(groups) != 1 || groups[0] != uid {
+			t.Fatalf("unexpected ACL for %s: %v", oid, groups)
+		}
+	}
+}
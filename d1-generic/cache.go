@@ -0,0 +1,267 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// PermissionCache caches the results of CheckPermission calls, keyed by identity and object ID.
+// It is safe for concurrent use and can be shared between clients.
+type PermissionCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	now         func() time.Time
+
+	entries    map[cacheKey]*list.Element
+	lru        *list.List
+	generation uint64
+	stats      CacheStats
+}
+
+type cacheKey struct {
+	identity string
+	objectID string
+}
+
+type cacheEntry struct {
+	key           cacheKey
+	hasPermission bool
+	expiry        time.Time
+}
+
+// CacheStats contains counters describing the effectiveness of a PermissionCache.
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	Entries       int
+}
+
+// HitRate returns the fraction of lookups that were answered from the cache.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// CacheOption is used to configure optional settings on a PermissionCache.
+type CacheOption func(*PermissionCache)
+
+// WithNegativeTTL returns a CacheOption which sets how long denied permissions are cached. A
+// value of zero disables negative caching. The default is the TTL of the cache.
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(c *PermissionCache) {
+		c.negativeTTL = ttl
+	}
+}
+
+// WithMaxEntries returns a CacheOption which bounds the number of cached decisions. The least
+// recently used entries are evicted first. The default is 10000.
+func WithMaxEntries(n int) CacheOption {
+	return func(c *PermissionCache) {
+		c.maxEntries = n
+	}
+}
+
+// NewPermissionCache creates a cache that keeps granted permissions for the duration of ttl.
+func NewPermissionCache(ttl time.Duration, opts ...CacheOption) *PermissionCache {
+	c := &PermissionCache{
+		ttl:         ttl,
+		negativeTTL: ttl,
+		maxEntries:  10000,
+		now:         time.Now,
+		entries:     map[cacheKey]*list.Element{},
+		lru:         list.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// cacheClients numbers the clients using a PermissionCache. The address of the client cannot be
+// used, since it may be reused by a later client with different credentials.
+var cacheClients uint64
+
+// WithPermissionCache returns an Option which answers CheckPermission calls from the cache when
+// possible. Cached decisions for an object are dropped when the client calls AddPermission or
+// RemovePermission on it, and all cached decisions are dropped when the client changes group
+// memberships or removes a user. Changes made through other clients are only picked up once the
+// entries expire.
+//
+// Decisions are cached per access token when one is attached to the outgoing context, and per
+// client otherwise.
+func WithPermissionCache(cache *PermissionCache) Option {
+	return func(bc *BaseClient) grpc.DialOption {
+		clientIdentity := fmt.Sprintf("client:%d", atomic.AddUint64(&cacheClients, 1))
+		return grpc.WithChainUnaryInterceptor(cache.interceptor(clientIdentity))
+	}
+}
+
+// Stats returns the current cache statistics.
+func (c *PermissionCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Purge drops all cached decisions.
+func (c *PermissionCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+}
+
+// InvalidateObject drops all cached decisions for an object.
+func (c *PermissionCache) InvalidateObject(objectID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, element := range c.entries {
+		if key.objectID == objectID {
+			c.remove(element)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+func (c *PermissionCache) interceptor(clientIdentity string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		switch method {
+		case "/d1.authz.Authz/CheckPermission":
+			return c.checkPermission(ctx, clientIdentity, method, req, reply, cc, invoker, opts...)
+		case "/d1.authz.Authz/AddPermission":
+			defer c.InvalidateObject(req.(*pbauthz.AddPermissionRequest).ObjectId)
+		case "/d1.authz.Authz/RemovePermission":
+			defer c.InvalidateObject(req.(*pbauthz.RemovePermissionRequest).ObjectId)
+		case "/d1.authn.Authn/AddUserToGroups", "/d1.authn.Authn/RemoveUserFromGroups", "/d1.authn.Authn/RemoveUser":
+			defer c.Purge()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (c *PermissionCache) checkPermission(ctx context.Context, clientIdentity, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	key := cacheKey{
+		identity: identity(ctx, clientIdentity),
+		objectID: req.(*pbauthz.CheckPermissionRequest).ObjectId,
+	}
+
+	hasPermission, generation, ok := c.get(key)
+	if ok {
+		reply.(*pbauthz.CheckPermissionResponse).HasPermission = hasPermission
+		return nil
+	}
+
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return err
+	}
+	c.put(key, reply.(*pbauthz.CheckPermissionResponse).HasPermission, generation)
+	return nil
+}
+
+// get looks up a decision. If there is none, it returns the generation to pass to put.
+func (c *PermissionCache) get(key cacheKey) (bool, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*cacheEntry)
+		if c.now().Before(entry.expiry) {
+			c.lru.MoveToFront(element)
+			c.stats.Hits++
+			return entry.hasPermission, 0, true
+		}
+		c.remove(element)
+	}
+
+	c.stats.Misses++
+	return false, c.generation, false
+}
+
+// put stores a decision unless the cache was invalidated since the lookup that preceded it, in
+// which case the decision may already be stale.
+func (c *PermissionCache) put(key cacheKey, hasPermission bool, generation uint64) {
+	ttl := c.ttl
+	if !hasPermission {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &cacheEntry{key: key, hasPermission: hasPermission, expiry: c.now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// purge drops all entries. The caller must hold c.mu.
+func (c *PermissionCache) purge() {
+	c.generation++
+	c.stats.Invalidations += uint64(c.lru.Len())
+	c.entries = map[cacheKey]*list.Element{}
+	c.lru.Init()
+}
+
+// remove drops a single entry. The caller must hold c.mu.
+func (c *PermissionCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*cacheEntry).key)
+	c.lru.Remove(element)
+}
+
+// identity returns the cache identity of a call: a hash of the access token in the outgoing
+// context if there is one, and the identity of the client otherwise.
+func identity(ctx context.Context, clientIdentity string) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	tokens := md.Get("authorization")
+	if len(tokens) == 0 {
+		return clientIdentity
+	}
+	sum := sha256.Sum256([]byte(tokens[len(tokens)-1]))
+	return fmt.Sprintf("token:%x", sum)
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestPermissionCache(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	cache := client.NewPermissionCache(time.Hour, client.WithMaxEntries(2))
+	c, err := client.NewBaseClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithPermissionCache(cache))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	check := func(oid string, expected bool) {
+		t.Helper()
+		res, err := c.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: oid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.HasPermission != expected {
+			t.Fatalf("expected permission %v for %s, got %v", expected, oid, res.HasPermission)
+		}
+	}
+	expectStats := func(hits, misses uint64) {
+		t.Helper()
+		stats := cache.Stats()
+		if stats.Hits != hits || stats.Misses != misses {
+			t.Fatalf("expected %d hits and %d misses, got %+v", hits, misses, stats)
+		}
+	}
+
+	other, _ := server.NewUser()
+	granted := server.NewObject(uid)
+	denied := server.NewObject(other)
+
+	check(granted, true)
+	check(granted, true)
+	expectStats(1, 1)
+
+	// Denied decisions are cached as well.
+	check(denied, false)
+	check(denied, false)
+	expectStats(2, 2)
+
+	// Removing access through the same client invalidates the decision.
+	if _, err := c.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: granted, GroupIds: []string{uid}}); err != nil {
+		t.Fatal(err)
+	}
+	check(granted, false)
+	expectStats(2, 3)
+
+	// Membership changes invalidate everything.
+	if _, err := c.Authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{UserId: uid, GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	check(denied, true)
+	expectStats(2, 4)
+
+	// The least recently used decision is evicted once the cache is full.
+	check(server.NewObject(), false)
+	check(server.NewObject(), false)
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	check(denied, true)
+	expectStats(2, 7)
+	if rate := cache.Stats().HitRate(); rate != 2.0/9.0 {
+		t.Fatalf("unexpected hit rate %v", rate)
+	}
+}
+
+func TestPermissionCacheExpiry(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	cache := client.NewPermissionCache(20*time.Millisecond, client.WithNegativeTTL(0))
+	c, err := client.NewBaseClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithPermissionCache(cache))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	oid := server.NewObject(uid)
+	for _, id := range []string{oid, oid, server.NewObject(), oid} {
+		if _, err := c.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: oid}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 3 {
+		t.Fatalf("unexpected stats after expiry: %+v", stats)
+	}
+}
+
+func TestPermissionCacheSharedBetweenClients(t *testing.T) {
+	server := d1test.NewServer(t)
+	cache := client.NewPermissionCache(time.Hour)
+	ctx := context.Background()
+
+	owner, ownerPwd := server.NewUser(d1test.AllScopes()...)
+	oid := server.NewObject(owner)
+
+	// Each client is closed before the next is created, so they may be allocated at the same
+	// address, but must not share cached decisions.
+	for i, expected := range []bool{true, false} {
+		uid, pwd := owner, ownerPwd
+		if i > 0 {
+			uid, pwd = server.NewUser(d1test.AllScopes()...)
+		}
+		c, err := client.NewBaseClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithPermissionCache(cache))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: oid})
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.HasPermission != expected {
+			t.Fatalf("client %d: expected permission %v, got %v", i, expected, res.HasPermission)
+		}
+	}
+}
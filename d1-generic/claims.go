@@ -0,0 +1,93 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenClaims are the claims carried by a JWT access token.
+type TokenClaims struct {
+	Subject   string
+	ExpiresAt time.Time
+	// Scopes contains the names of the D1 scopes in the token, if present.
+	Scopes []string
+	// Raw contains all claims as decoded from the token.
+	Raw map[string]interface{}
+}
+
+// ParseTokenClaims decodes the claims of a JWT access token. The signature is not verified, so the
+// claims must only be used for diagnostics and client-side decisions, never to grant access.
+func ParseTokenClaims(token string) (*TokenClaims, error) {
+	parts := strings.Split(strings.TrimPrefix(token, "bearer "), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(payload, &claims.Raw); err != nil {
+		return nil, err
+	}
+
+	claims.Subject, _ = claims.Raw["sub"].(string)
+	if exp, ok := claims.Raw["exp"].(float64); ok {
+		claims.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+
+	switch value := claims.Raw["scopes"].(type) {
+	case []interface{}:
+		for _, scope := range value {
+			switch s := scope.(type) {
+			case string:
+				claims.Scopes = append(claims.Scopes, strings.ToUpper(s))
+			case float64:
+				claims.Scopes = append(claims.Scopes, scopes.Scope(s).String())
+			}
+		}
+	case string:
+		claims.Scopes = strings.Fields(strings.ToUpper(value))
+	}
+	if scope, ok := claims.Raw["scope"].(string); ok && claims.Scopes == nil {
+		claims.Scopes = strings.Fields(strings.ToUpper(scope))
+	}
+
+	return claims, nil
+}
+
+// accessToken returns the access token used for calls made with the given context: the token
+// attached to the outgoing context if there is one, and the token obtained through
+// WithTokenRefresh or WithTokenRefreshSecret otherwise.
+func (b *BaseClient) accessToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if tokens := md.Get("authorization"); len(tokens) > 0 {
+		return strings.TrimPrefix(tokens[len(tokens)-1], "bearer "), nil
+	}
+	if b.state != nil && b.state.tokenSource != nil {
+		return b.state.tokenSource(ctx)
+	}
+	return "", errors.New("no access token available")
+}
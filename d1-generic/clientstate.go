@@ -0,0 +1,59 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// clientState holds settings made by options which are needed after the client was created.
+type clientState struct {
+	// tokenSource is set if the client was configured with WithTokenRefresh or
+	// WithTokenRefreshSecret.
+	tokenSource perRPCToken
+	// messageSize is set if the client was configured with WithMaxMessageSize.
+	messageSize *messageSizeConfig
+	// compression is set if the client was configured with WithCompression.
+	compression *compressionConfig
+	// payloadInstalled is set once the interceptor shared by WithMaxMessageSize and
+	// WithCompression has been installed.
+	payloadInstalled bool
+}
+
+// payloadInterceptor returns a DialOption installing the interceptor shared by WithMaxMessageSize
+// and WithCompression the first time it is called, and an empty DialOption afterwards. The options
+// are all applied before the connection is dialled, so the interceptor sees both configurations and
+// compresses plaintexts before checking message sizes, whichever option came first.
+func (s *clientState) payloadInterceptor() grpc.DialOption {
+	if s.payloadInstalled {
+		return grpc.EmptyDialOption{}
+	}
+	s.payloadInstalled = true
+
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		next := invoker
+		if messageSize := s.messageSize; messageSize != nil {
+			next = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return messageSize.intercept(ctx, method, req, reply, cc, invoker, opts...)
+			}
+		}
+		if s.compression != nil {
+			return s.compression.intercept(ctx, method, req, reply, cc, next, opts...)
+		}
+		return next(ctx, method, req, reply, cc, opts...)
+	})
+}
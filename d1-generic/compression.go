@@ -0,0 +1,203 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+)
+
+// ErrDecompressedTooLarge is returned when a decompressed plaintext exceeds the configured limit.
+var ErrDecompressedTooLarge = envelope.ErrDecompressedTooLarge
+
+// CompressionOption is used to configure WithCompression.
+type CompressionOption func(*compressionConfig)
+
+type compressionConfig struct {
+	algorithm envelope.Compression
+	threshold int
+	maxSize   int64
+}
+
+// WithCompressionAlgorithm returns a CompressionOption which compresses plaintexts with the
+// algorithm, envelope.Gzip or envelope.Zstd. The default is envelope.Gzip.
+func WithCompressionAlgorithm(algorithm envelope.Compression) CompressionOption {
+	return func(cc *compressionConfig) {
+		cc.algorithm = algorithm
+	}
+}
+
+// WithCompressionThreshold returns a CompressionOption which only compresses plaintexts of at least
+// size bytes. The default is 1 KiB.
+func WithCompressionThreshold(size int) CompressionOption {
+	return func(cc *compressionConfig) {
+		cc.threshold = size
+	}
+}
+
+// WithMaxDecompressedSize returns a CompressionOption which limits the size of decompressed
+// plaintexts, to guard against decompression bombs. The default is
+// envelope.DefaultMaxDecompressedSize.
+func WithMaxDecompressedSize(size int64) CompressionOption {
+	return func(cc *compressionConfig) {
+		cc.maxSize = size
+	}
+}
+
+// WithCompression returns an Option which compresses plaintexts before they are encrypted by
+// Generic.Encrypt, Storage.Store and Storage.Update, and decompresses them after Generic.Decrypt
+// and Storage.Retrieve. Plaintexts are only compressed when they reach the threshold and
+// compression makes them smaller.
+//
+// Compressed plaintexts use the same format as package envelope: a header naming the algorithm is
+// prepended to the plaintext before encryption, so it is authenticated by D1 and the associated
+// data is left untouched. Compressed and uncompressed data can be mixed, and envelopes written with
+// compression can be read by this client. Clients without WithCompression return compressed
+// plaintexts as is. Plaintexts compressed with any supported algorithm are decompressed, whichever
+// algorithm the client compresses with.
+//
+// Calls made with the envelope.KeepCompressed call option are passed through unchanged. When used
+// with WithMaxMessageSize, sizes are checked after compression, whichever option comes first.
+func WithCompression(opts ...CompressionOption) Option {
+	config := &compressionConfig{
+		algorithm: envelope.Gzip,
+		threshold: 1 << 10,
+		maxSize:   envelope.DefaultMaxDecompressedSize,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return func(bc *BaseClient) grpc.DialOption {
+		bc.state.compression = config
+		return bc.state.payloadInterceptor()
+	}
+}
+
+// intercept compresses the plaintext of requests and decompresses the plaintext of responses.
+func (c *compressionConfig) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if hasCallOption[envelope.KeepCompressed](opts) || hasCallOption[*envelope.KeepCompressed](opts) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	switch method {
+	case "/d1.generic.Generic/Encrypt", "/d1.storage.Storage/Store", "/d1.storage.Storage/Update":
+		compressed, err := c.compress(req)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, compressed, reply, cc, opts...)
+	case "/d1.generic.Generic/Decrypt", "/d1.storage.Storage/Retrieve":
+		if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+			return err
+		}
+		if err := c.decompress(reply); err != nil {
+			if m, ok := reply.(proto.Message); ok {
+				proto.Reset(m)
+			}
+			return err
+		}
+		return nil
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// hasCallOption reports whether the call options include one of type T.
+func hasCallOption[T grpc.CallOption](opts []grpc.CallOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(T); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compress returns a copy of the request with the plaintext compressed, or the request itself if
+// it is not compressed. A plaintext which already starts with a compression header is escaped, so
+// it is not mistaken for a compressed one when decrypted.
+func (c *compressionConfig) compress(req interface{}) (interface{}, error) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return req, nil
+	}
+	r := m.ProtoReflect()
+	plaintext, ok := plaintextField(r)
+	if !ok {
+		return req, nil
+	}
+
+	data := r.Get(plaintext).Bytes()
+	var result []byte
+	if len(data) >= c.threshold {
+		compressed, err := envelope.Compress(data, c.algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			result = compressed
+		}
+	}
+	if result == nil {
+		if !envelope.HasCompressionHeader(data) {
+			return req, nil
+		}
+		escaped, err := envelope.Compress(data, envelope.NoCompression)
+		if err != nil {
+			return nil, err
+		}
+		result = escaped
+	}
+
+	clone := proto.Clone(m).ProtoReflect()
+	clone.Set(plaintext, protoreflect.ValueOfBytes(result))
+	return clone.Interface(), nil
+}
+
+// decompress decompresses the plaintext of a response in place if it has a compression header.
+func (c *compressionConfig) decompress(reply interface{}) error {
+	m, ok := reply.(proto.Message)
+	if !ok {
+		return nil
+	}
+	r := m.ProtoReflect()
+	plaintext, ok := plaintextField(r)
+	if !ok {
+		return nil
+	}
+
+	data := r.Get(plaintext).Bytes()
+	if !envelope.HasCompressionHeader(data) {
+		return nil
+	}
+	decompressed, _, err := envelope.Decompress(data, c.maxSize)
+	if err != nil {
+		return err
+	}
+	r.Set(plaintext, protoreflect.ValueOfBytes(decompressed))
+	return nil
+}
+
+// plaintextField returns the plaintext field of a message, if it has one.
+func plaintextField(r protoreflect.Message) (protoreflect.FieldDescriptor, bool) {
+	plaintext := r.Descriptor().Fields().ByName("plaintext")
+	if plaintext == nil || plaintext.Kind() != protoreflect.BytesKind {
+		return nil, false
+	}
+	return plaintext, true
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	sclient "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestCompression(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	plain, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	ctx := context.Background()
+
+	document := bytes.Repeat([]byte("highly compressible document "), 1000)
+	request := &pb.EncryptRequest{Plaintext: document, AssociatedData: []byte("context")}
+	encrypted, err := c.Generic.Encrypt(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request.Plaintext, document) || string(request.AssociatedData) != "context" {
+		t.Fatal("the request was modified")
+	}
+	if len(encrypted.Ciphertext) >= len(document)/10 {
+		t.Fatalf("ciphertext of %d bytes was not compressed", len(encrypted.Ciphertext))
+	}
+
+	decrypted, err := c.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext, AssociatedData: encrypted.AssociatedData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Plaintext, document) || string(decrypted.AssociatedData) != "context" {
+		t.Fatalf("unexpected decryption: %d bytes, associated data %q", len(decrypted.Plaintext), decrypted.AssociatedData)
+	}
+
+	// Small plaintexts are not compressed, so clients without WithCompression can read them.
+	small, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("small"), AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = plain.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: small.ObjectId, Ciphertext: small.Ciphertext, AssociatedData: small.AssociatedData})
+	if err != nil || string(decrypted.Plaintext) != "small" {
+		t.Fatalf("unexpected decryption: %v, %q", err, decrypted.GetPlaintext())
+	}
+
+	// A plaintext which looks compressed is escaped.
+	lookalike, err := envelope.Compress([]byte("inner"), envelope.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escaped, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: lookalike})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = c.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: escaped.ObjectId, Ciphertext: escaped.Ciphertext, AssociatedData: escaped.AssociatedData})
+	if err != nil || !bytes.Equal(decrypted.Plaintext, lookalike) {
+		t.Fatalf("unexpected decryption: %v, %q", err, decrypted.GetPlaintext())
+	}
+
+	// Envelopes handle compression themselves and are left alone by the interceptor.
+	e, err := envelope.Encrypt(ctx, c.Generic, document, nil, envelope.WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := e.Decrypt(ctx, c.Generic)
+	if err != nil || !bytes.Equal(opened, document) {
+		t.Fatalf("unexpected envelope decryption: %v", err)
+	}
+	e, err = envelope.Encrypt(ctx, plain.Generic, document, nil, envelope.WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = c.Generic.Decrypt(ctx, e.DecryptRequest())
+	if err != nil || !bytes.Equal(decrypted.Plaintext, document) {
+		t.Fatalf("unexpected decryption of a compressed envelope: %v", err)
+	}
+
+	// Decompression is limited.
+	limited, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression(client.WithMaxDecompressedSize(1000)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+	decrypted, err = limited.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext, AssociatedData: encrypted.AssociatedData})
+	if !errors.Is(err, client.ErrDecompressedTooLarge) || decrypted != nil {
+		t.Fatalf("expected ErrDecompressedTooLarge, got %v", err)
+	}
+}
+
+func TestCompressionZstd(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression(client.WithCompressionAlgorithm(envelope.Zstd)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	document := bytes.Repeat([]byte("highly compressible document "), 1000)
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: document})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted.Ciphertext) >= len(document)/10 {
+		t.Fatalf("ciphertext of %d bytes was not compressed", len(encrypted.Ciphertext))
+	}
+	request := &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext, AssociatedData: encrypted.AssociatedData}
+
+	// Clients compressing with gzip decompress zstd as well.
+	gzipClient, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gzipClient.Close()
+	decrypted, err := gzipClient.Generic.Decrypt(ctx, request)
+	if err != nil || !bytes.Equal(decrypted.Plaintext, document) {
+		t.Fatalf("unexpected decryption: %v", err)
+	}
+
+	// Decompression is limited.
+	limited, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression(client.WithMaxDecompressedSize(1000)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+	if _, err := limited.Generic.Decrypt(ctx, request); !errors.Is(err, client.ErrDecompressedTooLarge) {
+		t.Fatalf("expected ErrDecompressedTooLarge, got %v", err)
+	}
+}
+
+func TestStorageCompression(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := sclient.NewStorageClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithCompression(client.WithCompressionThreshold(0)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	document := bytes.Repeat([]byte("a"), 100)
+	stored, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: document, AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrieved, err := c.Storage.Retrieve(ctx, &pbstorage.RetrieveRequest{ObjectId: stored.ObjectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(retrieved.Plaintext, document) || string(retrieved.AssociatedData) != "context" {
+		t.Fatalf("unexpected retrieval: %q, %q", retrieved.Plaintext, retrieved.AssociatedData)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+)
+
+// Compression identifies the algorithm a plaintext was compressed with before encryption.
+type Compression byte
+
+const (
+	// NoCompression means the plaintext was encrypted as is.
+	NoCompression Compression = 0
+	// Gzip means the plaintext was compressed with gzip.
+	Gzip Compression = 1
+	// Zstd means the plaintext was compressed with Zstandard.
+	Zstd Compression = 2
+
+	// DefaultMaxDecompressedSize is the default limit on the size of a decompressed plaintext.
+	DefaultMaxDecompressedSize = 64 << 20
+
+	// compressionHeader precedes a compressed plaintext and is followed by the algorithm. Since D1
+	// authenticates the plaintext, the header binds the algorithm to the ciphertext.
+	compressionHeader = "\x00D1Z"
+)
+
+// ErrDecompressedTooLarge is returned when a decompressed plaintext exceeds the size limit.
+var ErrDecompressedTooLarge = errors.New("decompressed plaintext exceeds the size limit")
+
+// KeepCompressed is a grpc.CallOption which asks interceptors that compress and decompress
+// plaintexts, such as the one installed by client.WithCompression, to leave the plaintext as is.
+// Encrypt and Decrypt use it, since they handle compression themselves.
+type KeepCompressed struct {
+	grpc.EmptyCallOption
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+func parseCompression(name string) (Compression, error) {
+	switch name {
+	case "", "none":
+		return NoCompression, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q", name)
+	}
+}
+
+// Compress compresses the plaintext with the algorithm and prepends a header identifying it. With
+// NoCompression the plaintext is left as is, but the header is still added, which keeps a
+// plaintext that happens to start with a header from being mistaken for compressed data.
+func Compress(plaintext []byte, algorithm Compression) ([]byte, error) {
+	out := append([]byte(compressionHeader), byte(algorithm))
+	switch algorithm {
+	case NoCompression:
+		return append(out, plaintext...), nil
+	case Gzip:
+		buf := bytes.NewBuffer(out)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(plaintext); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zw.Close()
+		return zw.EncodeAll(plaintext, out), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %v", algorithm)
+	}
+}
+
+// HasCompressionHeader reports whether data starts with the header added by Compress.
+func HasCompressionHeader(data []byte) bool {
+	return len(data) > len(compressionHeader) && bytes.HasPrefix(data, []byte(compressionHeader))
+}
+
+// Decompress reverses Compress and returns the plaintext and the algorithm it was compressed with.
+// Plaintexts larger than maxSize bytes are rejected with ErrDecompressedTooLarge.
+func Decompress(data []byte, maxSize int64) ([]byte, Compression, error) {
+	if !HasCompressionHeader(data) {
+		return nil, 0, errors.New("missing compression header")
+	}
+	algorithm := Compression(data[len(compressionHeader)])
+	data = data[len(compressionHeader)+1:]
+
+	var r io.Reader
+	switch algorithm {
+	case NoCompression:
+		r = bytes.NewReader(data)
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer zr.Close()
+		r = zr
+	case Zstd:
+		// The decoder's memory limit bounds the window it allocates, while the plaintext itself is
+		// bounded by the limited read below.
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)+1))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, 0, fmt.Errorf("unknown compression %v", algorithm)
+	}
+
+	plaintext, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, 0, ErrDecompressedTooLarge
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(plaintext)) > maxSize {
+		return nil, 0, ErrDecompressedTooLarge
+	}
+	return plaintext, algorithm, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package envelope stores the result of an encryption as a single self-describing blob.
+
+Decrypting data with D1 requires the object ID, the ciphertext and the associated data. An Envelope
+holds all three, along with flags describing how the plaintext was encoded, and can be marshalled
+to a compact binary form, a base64 text form or JSON. Unmarshal accepts all three forms.
+
+The binary form is:
+
+	magic            4 bytes  "D1EV"
+	version          1 byte   1
+	flags            1 byte   compression in the low two bits: 0 for none, 1 for gzip,
+	                          2 for zstd
+	object ID        uvarint length, followed by the object ID
+	associated data  uvarint length, followed by the associated data
+	ciphertext       uvarint length, followed by the ciphertext
+
+The text form is the binary form encoded with standard base64. The JSON form is an object with the
+fields "version", "object_id", "associated_data", "ciphertext" and "compression", where the binary
+fields are base64 encoded and the compression is "gzip", "zstd" or absent.
+
+A compressed plaintext is preceded by a header naming the algorithm before it is encrypted, so the
+algorithm is authenticated along with the plaintext, and Decrypt fails if the envelope's
+compression does not match it. Decompressed plaintexts are limited to DefaultMaxDecompressedSize
+bytes unless another limit is set with WithMaxDecompressedSize. Plaintexts are compressed with gzip
+by WithCompression, or with the algorithm given to WithCompressionAlgorithm.
+*/
+package envelope
@@ -0,0 +1,134 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+)
+
+// Option is used to configure Encrypt and Decrypt.
+type Option func(*config)
+
+type config struct {
+	compression         Compression
+	groupIDs            []string
+	maxDecompressedSize int64
+}
+
+func newConfig(opts []Option) config {
+	c := config{maxDecompressedSize: DefaultMaxDecompressedSize}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithCompression returns an Option which gzip compresses the plaintext before it is encrypted.
+func WithCompression() Option {
+	return WithCompressionAlgorithm(Gzip)
+}
+
+// WithCompressionAlgorithm returns an Option which compresses the plaintext with the algorithm
+// before it is encrypted.
+func WithCompressionAlgorithm(algorithm Compression) Option {
+	return func(c *config) {
+		c.compression = algorithm
+	}
+}
+
+// WithGroups returns an Option which gives the groups access to the encrypted object.
+func WithGroups(groupIDs ...string) Option {
+	return func(c *config) {
+		c.groupIDs = groupIDs
+	}
+}
+
+// WithMaxDecompressedSize returns an Option which limits the size of plaintexts decompressed by
+// Decrypt, to guard against decompression bombs. The default is DefaultMaxDecompressedSize.
+func WithMaxDecompressedSize(size int64) Option {
+	return func(c *config) {
+		c.maxDecompressedSize = size
+	}
+}
+
+// Encrypt encrypts the plaintext with the Generic service and returns the result as an envelope.
+func Encrypt(ctx context.Context, c pb.GenericClient, plaintext, associatedData []byte, opts ...Option) (*Envelope, error) {
+	config := newConfig(opts)
+
+	compression := config.compression
+	// An uncompressed plaintext only gets a header if it could be mistaken for a compressed one.
+	if compression != NoCompression || HasCompressionHeader(plaintext) {
+		var err error
+		if plaintext, err = Compress(plaintext, compression); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := c.Encrypt(ctx, &pb.EncryptRequest{
+		Plaintext:      plaintext,
+		AssociatedData: associatedData,
+		GroupIds:       config.groupIDs,
+	}, KeepCompressed{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		ObjectID:       res.ObjectId,
+		Ciphertext:     res.Ciphertext,
+		AssociatedData: res.AssociatedData,
+		Compression:    compression,
+	}, nil
+}
+
+// DecryptRequest returns the request needed to decrypt the envelope.
+func (e *Envelope) DecryptRequest() *pb.DecryptRequest {
+	return &pb.DecryptRequest{
+		ObjectId:       e.ObjectID,
+		Ciphertext:     e.Ciphertext,
+		AssociatedData: e.AssociatedData,
+	}
+}
+
+// Decrypt decrypts the envelope with the Generic service and returns the plaintext, decompressing
+// it if needed. Only the WithMaxDecompressedSize option applies.
+func (e *Envelope) Decrypt(ctx context.Context, c pb.GenericClient, opts ...Option) ([]byte, error) {
+	config := newConfig(opts)
+
+	res, err := c.Decrypt(ctx, e.DecryptRequest(), KeepCompressed{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated, so it decides whether the plaintext is compressed, and the
+	// envelope's compression must agree with it.
+	if !HasCompressionHeader(res.Plaintext) {
+		if e.Compression != NoCompression {
+			return nil, fmt.Errorf("%w: plaintext is not compressed", ErrInvalidEnvelope)
+		}
+		return res.Plaintext, nil
+	}
+	plaintext, compression, err := Decompress(res.Plaintext, config.maxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	if compression != e.Compression {
+		return nil, fmt.Errorf("%w: plaintext is compressed with %v, not %v", ErrInvalidEnvelope, compression, e.Compression)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	magic = "D1EV"
+	// textMagic is the part of the base64 encoding of the text form determined by magic alone.
+	textMagic = "RDFFV"
+
+	// Version is the envelope format version produced by this package.
+	Version = 1
+
+	// flagCompression holds the compression algorithm.
+	flagCompression = 0x03
+	knownFlags      = flagCompression
+
+	// maxObjectIDLength bounds the object ID accepted when parsing.
+	maxObjectIDLength = 1024
+)
+
+// ErrInvalidEnvelope is returned when parsing data that is not a valid envelope.
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// Envelope holds everything needed to decrypt a ciphertext produced by D1.
+type Envelope struct {
+	ObjectID       string
+	Ciphertext     []byte
+	AssociatedData []byte
+	// Compression is the algorithm the plaintext was compressed with before encryption.
+	Compression Compression
+}
+
+// Marshal returns the binary form of the envelope.
+func Marshal(e *Envelope) ([]byte, error) {
+	return e.MarshalBinary()
+}
+
+// Unmarshal parses an envelope in binary, text or JSON form.
+func Unmarshal(data []byte) (*Envelope, error) {
+	e := &Envelope{}
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte(magic)):
+		err = e.UnmarshalBinary(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		err = e.UnmarshalJSON(data)
+	default:
+		err = e.UnmarshalText(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// IsEnvelope reports whether data starts like an envelope in binary or text form. The rest of the
+// data is not checked, so parsing it may still fail.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic)) || bytes.HasPrefix(bytes.TrimSpace(data), []byte(textMagic))
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	if len(e.ObjectID) > maxObjectIDLength {
+		return nil, fmt.Errorf("object ID longer than %d bytes", maxObjectIDLength)
+	}
+
+	size := len(magic) + 2 + 3*binary.MaxVarintLen64 + len(e.ObjectID) + len(e.AssociatedData) + len(e.Ciphertext)
+	buf := make([]byte, 0, size)
+	buf = append(buf, magic...)
+	buf = append(buf, Version, e.flags())
+	buf = appendField(buf, []byte(e.ObjectID))
+	buf = appendField(buf, e.AssociatedData)
+	buf = appendField(buf, e.Ciphertext)
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return fmt.Errorf("%w: bad magic", ErrInvalidEnvelope)
+	}
+	data = data[len(magic):]
+	if len(data) < 2 {
+		return fmt.Errorf("%w: missing header", ErrInvalidEnvelope)
+	}
+	if data[0] != Version {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, data[0])
+	}
+	if err := e.setFlags(data[1]); err != nil {
+		return err
+	}
+	data = data[2:]
+
+	objectID, data, err := readField(data, maxObjectIDLength)
+	if err != nil {
+		return fmt.Errorf("%w: object ID: %v", ErrInvalidEnvelope, err)
+	}
+	associatedData, data, err := readField(data, len(data))
+	if err != nil {
+		return fmt.Errorf("%w: associated data: %v", ErrInvalidEnvelope, err)
+	}
+	ciphertext, data, err := readField(data, len(data))
+	if err != nil {
+		return fmt.Errorf("%w: ciphertext: %v", ErrInvalidEnvelope, err)
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("%w: trailing data", ErrInvalidEnvelope)
+	}
+
+	e.ObjectID = string(objectID)
+	e.AssociatedData = copyBytes(associatedData)
+	e.Ciphertext = copyBytes(ciphertext)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (e *Envelope) MarshalText() ([]byte, error) {
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (e *Envelope) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, bytes.TrimSpace(text))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return e.UnmarshalBinary(data[:n])
+}
+
+type jsonEnvelope struct {
+	Version        int    `json:"version"`
+	ObjectID       string `json:"object_id"`
+	AssociatedData []byte `json:"associated_data,omitempty"`
+	Ciphertext     []byte `json:"ciphertext"`
+	Compression    string `json:"compression,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *Envelope) MarshalJSON() ([]byte, error) {
+	j := jsonEnvelope{
+		Version:        Version,
+		ObjectID:       e.ObjectID,
+		AssociatedData: e.AssociatedData,
+		Ciphertext:     e.Ciphertext,
+	}
+	if e.Compression != NoCompression {
+		j.Compression = e.Compression.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	var j jsonEnvelope
+	if err := json.Unmarshal(data, &j); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if j.Version != Version {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, j.Version)
+	}
+	if len(j.ObjectID) > maxObjectIDLength {
+		return fmt.Errorf("%w: object ID longer than %d bytes", ErrInvalidEnvelope, maxObjectIDLength)
+	}
+	compression, err := parseCompression(j.Compression)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	*e = Envelope{
+		ObjectID:       j.ObjectID,
+		AssociatedData: copyBytes(j.AssociatedData),
+		Ciphertext:     copyBytes(j.Ciphertext),
+		Compression:    compression,
+	}
+	return nil
+}
+
+func (e *Envelope) flags() byte {
+	return byte(e.Compression) & flagCompression
+}
+
+func (e *Envelope) setFlags(flags byte) error {
+	if flags&^knownFlags != 0 {
+		return fmt.Errorf("%w: unknown flags %#x", ErrInvalidEnvelope, flags)
+	}
+	e.Compression = Compression(flags & flagCompression)
+	if e.Compression != NoCompression && e.Compression != Gzip && e.Compression != Zstd {
+		return fmt.Errorf("%w: unknown compression %v", ErrInvalidEnvelope, e.Compression)
+	}
+	return nil
+}
+
+func appendField(buf, field []byte) []byte {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(field)))
+	buf = append(buf, length[:n]...)
+	return append(buf, field...)
+}
+
+// readField reads a length-prefixed field of at most max bytes and returns it with the remaining
+// data.
+func readField(data []byte, max int) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, nil, errors.New("bad length")
+	}
+	data = data[n:]
+	if length > uint64(max) || length > uint64(len(data)) {
+		return nil, nil, errors.New("length out of range")
+	}
+	return data[:length], data[length:], nil
+}
+
+func copyBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
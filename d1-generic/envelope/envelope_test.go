@@ -0,0 +1,212 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+var testEnvelope = &envelope.Envelope{
+	ObjectID:       "object-0001",
+	Ciphertext:     []byte{0, 1, 2, 3, 255},
+	AssociatedData: []byte("metadata"),
+	Compression:    envelope.Gzip,
+}
+
+func TestForms(t *testing.T) {
+	binary, err := envelope.Marshal(testEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := testEnvelope.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(testEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"binary": binary, "text": text, "json": jsonData} {
+		e, err := envelope.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(e, testEnvelope) {
+			t.Fatalf("%s: got %+v, want %+v", name, e, testEnvelope)
+		}
+	}
+	if !envelope.IsEnvelope(binary) || !envelope.IsEnvelope(text) || envelope.IsEnvelope([]byte("plaintext")) {
+		t.Fatal("IsEnvelope does not recognize the binary and text forms")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, err := envelope.Marshal(testEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownFlags := append([]byte(nil), valid...)
+	unknownFlags[5] = 0x80
+	badVersion := append([]byte(nil), valid...)
+	badVersion[4] = 2
+
+	for name, data := range map[string][]byte{
+		"empty":         {},
+		"header only":   []byte("D1EV"),
+		"bad version":   badVersion,
+		"unknown flags": unknownFlags,
+		"truncated":     valid[:len(valid)-1],
+		"trailing":      append(append([]byte(nil), valid...), 0),
+		"json version":  []byte(`{"version": 7, "object_id": "x"}`),
+		"bad base64":    []byte("!!!"),
+	} {
+		if _, err := envelope.Unmarshal(data); !errors.Is(err, envelope.ErrInvalidEnvelope) {
+			t.Errorf("%s: expected ErrInvalidEnvelope, got %v", name, err)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	plaintext := bytes.Repeat([]byte("compressible "), 100)
+
+	for _, opts := range [][]envelope.Option{nil, {envelope.WithCompression()}, {envelope.WithCompressionAlgorithm(envelope.Zstd)}} {
+		e, err := envelope.Encrypt(ctx, c.Generic, plaintext, []byte("ad"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := envelope.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := envelope.Unmarshal(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := stored.Decrypt(ctx, c.Generic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatal("plaintext mismatch")
+		}
+	}
+}
+
+func TestDecryptCompression(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	bomb := make([]byte, 1<<20)
+	for _, algorithm := range []envelope.Compression{envelope.Gzip, envelope.Zstd} {
+		compressed, err := envelope.Encrypt(ctx, c.Generic, bomb, nil, envelope.WithCompressionAlgorithm(algorithm))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := compressed.Decrypt(ctx, c.Generic, envelope.WithMaxDecompressedSize(1<<10)); !errors.Is(err, envelope.ErrDecompressedTooLarge) {
+			t.Fatalf("%v: expected ErrDecompressedTooLarge, got %v", algorithm, err)
+		}
+	}
+	compressed, err := envelope.Encrypt(ctx, c.Generic, bomb, nil, envelope.WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The compression is bound to the plaintext, so changing it in the envelope is detected.
+	tampered := *compressed
+	tampered.Compression = envelope.NoCompression
+	if _, err := tampered.Decrypt(ctx, c.Generic); !errors.Is(err, envelope.ErrInvalidEnvelope) {
+		t.Fatalf("expected ErrInvalidEnvelope, got %v", err)
+	}
+	uncompressed, err := envelope.Encrypt(ctx, c.Generic, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed.Compression = envelope.Gzip
+	if _, err := uncompressed.Decrypt(ctx, c.Generic); !errors.Is(err, envelope.ErrInvalidEnvelope) {
+		t.Fatalf("expected ErrInvalidEnvelope, got %v", err)
+	}
+
+	// A plaintext that looks like a compressed one survives the round trip.
+	lookalike, err := envelope.Compress([]byte("inner"), envelope.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := envelope.Encrypt(ctx, c.Generic, lookalike, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := e.Decrypt(ctx, c.Generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, lookalike) {
+		t.Fatal("plaintext mismatch")
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	binary, _ := envelope.Marshal(testEnvelope)
+	text, _ := testEnvelope.MarshalText()
+	jsonData, _ := json.Marshal(testEnvelope)
+	f.Add(binary)
+	f.Add(text)
+	f.Add(jsonData)
+	f.Add([]byte("D1EV\x01\x00\x00\x00\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := envelope.Unmarshal(data)
+		if err != nil {
+			return
+		}
+		out, err := envelope.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := envelope.Unmarshal(out)
+		if err != nil {
+			t.Fatalf("re-parsing marshalled envelope: %v", err)
+		}
+		if !reflect.DeepEqual(e, again) {
+			t.Fatalf("round trip mismatch: %+v != %+v", e, again)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccessReason summarizes why the caller does or does not have access to an object.
+type AccessReason string
+
+// Reasons reported by ExplainAccess.
+const (
+	// AccessAllowed means the caller has access and the required scopes.
+	AccessAllowed AccessReason = "allowed"
+	// AccessUnauthenticated means the caller's token was rejected or is missing.
+	AccessUnauthenticated AccessReason = "unauthenticated"
+	// AccessTokenExpired means the caller's token has expired.
+	AccessTokenExpired AccessReason = "token expired"
+	// AccessMissingScope means the caller lacks a required scope, either while having access to the
+	// object or because D1 denied the permission check itself.
+	AccessMissingScope AccessReason = "missing scope"
+	// AccessMissingMembership means the caller is not a member of any group with access. It is only
+	// reported when both the groups with access and the caller's memberships are known.
+	AccessMissingMembership AccessReason = "missing group membership"
+	// AccessUnknown means the cause could not be determined from the available information.
+	AccessUnknown AccessReason = "unknown"
+)
+
+// MembershipSource provides the group memberships of users, for example a local registry.
+// UserGroups returns nil if the user is unknown to the source, and an empty slice if the user is
+// not a member of any group.
+type MembershipSource interface {
+	UserGroups(userID string) []string
+}
+
+// AccessExplanation is the result of ExplainAccess. Fields that could not be determined are left
+// empty and the reason is recorded in Notes.
+type AccessExplanation struct {
+	ObjectID string
+	Reason   AccessReason
+
+	// Claims of the caller's token, if it is a JWT.
+	Claims *TokenClaims
+	// RequiredScopes are the scopes the operation needs, and MissingScopes those not found in the
+	// token. MissingScopes is nil if the token's scopes are unknown.
+	RequiredScopes []string
+	MissingScopes  []string
+
+	// HasPermission is the result of CheckPermission.
+	HasPermission bool
+	// ObjectGroups are the groups with access to the object, as returned by GetPermissions.
+	ObjectGroups []string
+	// UserGroups are the caller's groups according to the membership source, including the
+	// caller's own group. It is nil if the source does not know the caller.
+	UserGroups []string
+	// SharedGroups are the groups in both ObjectGroups and UserGroups.
+	SharedGroups []string
+
+	// Notes contains human-readable observations made while explaining.
+	Notes []string
+}
+
+// String returns a human-readable explanation.
+func (e *AccessExplanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "access to %s: %s\n", e.ObjectID, e.Reason)
+	if e.Claims != nil {
+		fmt.Fprintf(&b, "  identity: %s (token expires %s)\n", e.Claims.Subject, e.Claims.ExpiresAt.Format(time.RFC3339))
+		fmt.Fprintf(&b, "  token scopes: %s\n", joinOrNone(e.Claims.Scopes))
+	}
+	missing := "unknown"
+	if e.MissingScopes != nil {
+		missing = joinOrNone(e.MissingScopes)
+	}
+	fmt.Fprintf(&b, "  required scopes: %s, missing: %s\n", joinOrNone(e.RequiredScopes), missing)
+	fmt.Fprintf(&b, "  permission check: %t\n", e.HasPermission)
+	if e.ObjectGroups != nil {
+		fmt.Fprintf(&b, "  groups with access: %s\n", joinOrNone(e.ObjectGroups))
+	}
+	if e.UserGroups != nil {
+		fmt.Fprintf(&b, "  caller's groups: %s\n", joinOrNone(e.UserGroups))
+		fmt.Fprintf(&b, "  shared groups: %s\n", joinOrNone(e.SharedGroups))
+	}
+	for _, note := range e.Notes {
+		fmt.Fprintf(&b, "  note: %s\n", note)
+	}
+	return b.String()
+}
+
+// ExplainOption is used to configure ExplainAccess.
+type ExplainOption func(*explainConfig)
+
+type explainConfig struct {
+	requiredScopes []scopes.Scope
+	memberships    MembershipSource
+}
+
+// WithRequiredScopes returns an ExplainOption which sets the scopes the operation in question
+// needs. The default is READ, as needed to decrypt.
+func WithRequiredScopes(required ...scopes.Scope) ExplainOption {
+	return func(c *explainConfig) {
+		c.requiredScopes = required
+	}
+}
+
+// WithMembershipSource returns an ExplainOption which looks up the caller's group memberships in
+// the given source, as D1 offers no way to list them.
+func WithMembershipSource(source MembershipSource) ExplainOption {
+	return func(c *explainConfig) {
+		c.memberships = source
+	}
+}
+
+// ExplainAccess explains whether and why the caller has access to an object, combining the claims
+// of the caller's token, CheckPermission, GetPermissions and, if configured, a local source of
+// group memberships. GetPermissions requires the GETACCESS scope and access to the object; if it
+// fails, the explanation is based on the remaining information. Only errors unrelated to access,
+// such as connection errors, are returned.
+func (b *BaseClient) ExplainAccess(ctx context.Context, objectID string, opts ...ExplainOption) (*AccessExplanation, error) {
+	config := explainConfig{requiredScopes: []scopes.Scope{scopes.Scope_READ}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	e := &AccessExplanation{ObjectID: objectID, Reason: AccessUnknown}
+	for _, scope := range config.requiredScopes {
+		e.RequiredScopes = append(e.RequiredScopes, scope.String())
+	}
+
+	token, err := b.accessToken(ctx)
+	if err != nil {
+		e.Notes = append(e.Notes, "could not obtain access token: "+err.Error())
+	} else if e.Claims, err = ParseTokenClaims(token); err != nil {
+		e.Notes = append(e.Notes, "token claims are unavailable: "+err.Error())
+	}
+
+	if e.Claims != nil {
+		if e.Claims.Scopes != nil {
+			e.MissingScopes = subtractStrings(e.RequiredScopes, e.Claims.Scopes)
+		} else {
+			e.Notes = append(e.Notes, "token does not list scopes")
+		}
+	}
+
+	checkDenied := false
+	check, err := b.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: objectID})
+	switch status.Code(err) {
+	case codes.OK:
+		e.HasPermission = check.HasPermission
+	case codes.Unauthenticated:
+		e.Notes = append(e.Notes, "CheckPermission: "+status.Convert(err).Message())
+		e.Reason = AccessUnauthenticated
+		if e.Claims != nil && !e.Claims.ExpiresAt.IsZero() && time.Now().After(e.Claims.ExpiresAt) {
+			e.Reason = AccessTokenExpired
+		}
+		return e, nil
+	case codes.PermissionDenied:
+		e.Notes = append(e.Notes, "CheckPermission: "+status.Convert(err).Message())
+		checkDenied = true
+	case codes.InvalidArgument, codes.NotFound:
+		e.Notes = append(e.Notes, "CheckPermission: "+status.Convert(err).Message())
+	default:
+		return nil, err
+	}
+
+	permissions, err := b.Authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: objectID})
+	switch status.Code(err) {
+	case codes.OK:
+		e.ObjectGroups = append([]string{}, permissions.GroupIds...)
+		sort.Strings(e.ObjectGroups)
+	case codes.PermissionDenied, codes.Unauthenticated, codes.InvalidArgument, codes.NotFound:
+		e.Notes = append(e.Notes, "GetPermissions: "+status.Convert(err).Message())
+	default:
+		return nil, err
+	}
+
+	switch {
+	case config.memberships == nil:
+		e.Notes = append(e.Notes, "the caller's group memberships are unknown; configure WithMembershipSource to check them")
+	case e.Claims == nil || e.Claims.Subject == "":
+		e.Notes = append(e.Notes, "the caller's group memberships are unknown, since the token does not identify the caller")
+	default:
+		memberships := config.memberships.UserGroups(e.Claims.Subject)
+		if memberships == nil {
+			e.Notes = append(e.Notes, "the membership source does not know the caller")
+			break
+		}
+		groups := map[string]bool{e.Claims.Subject: true}
+		for _, groupID := range memberships {
+			groups[groupID] = true
+		}
+		e.UserGroups = []string{}
+		for groupID := range groups {
+			e.UserGroups = append(e.UserGroups, groupID)
+		}
+		sort.Strings(e.UserGroups)
+		if e.ObjectGroups != nil {
+			e.SharedGroups = intersectStrings(e.UserGroups, e.ObjectGroups)
+		}
+	}
+
+	switch {
+	case e.HasPermission && e.MissingScopes == nil:
+		e.Notes = append(e.Notes, "the caller has access to the object, but its scopes are unknown")
+	case e.HasPermission && len(e.MissingScopes) == 0:
+		e.Reason = AccessAllowed
+	case e.HasPermission:
+		e.Reason = AccessMissingScope
+		e.Notes = append(e.Notes, "the caller has access to the object but lacks scopes "+joinOrNone(e.MissingScopes))
+	case checkDenied:
+		e.Reason = AccessMissingScope
+		e.Notes = append(e.Notes, "D1 denied the permission check, so the caller lacks a scope it needs")
+	case e.ObjectGroups == nil:
+		e.Notes = append(e.Notes, "the groups with access to the object are unknown, since GetPermissions failed")
+	case e.UserGroups == nil:
+		// The reason was noted when looking up the memberships.
+	case len(e.SharedGroups) == 0:
+		e.Reason = AccessMissingMembership
+		e.Notes = append(e.Notes, "add the caller to one of the groups with access, or grant one of the caller's groups access to the object")
+	default:
+		e.Notes = append(e.Notes, "the membership source lists a shared group, but D1 denies access; the source may be out of date")
+	}
+
+	return e, nil
+}
+
+// subtractStrings returns the elements of a that are not in b, preserving order.
+func subtractStrings(a, b []string) []string {
+	set := map[string]bool{}
+	for _, v := range b {
+		set[v] = true
+	}
+	out := []string{}
+	for _, v := range a {
+		if !set[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// intersectStrings returns the elements of a that are also in b, preserving order.
+func intersectStrings(a, b []string) []string {
+	return subtractStrings(a, subtractStrings(a, b))
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/registry"
+)
+
+func TestExplainAccess(t *testing.T) {
+	server, c, uid := newTestClient(t)
+	other, _ := server.NewUser()
+	reader, _ := server.NewUser(scopes.Scope_READ)
+
+	reg, err := registry.New(&registry.MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Record(registry.Event{Type: registry.EventCreateUser, UserID: uid}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	granted := server.NewObject(uid)
+	denied := server.NewObject(other)
+	readable := server.NewObject(reader)
+
+	e, err := c.ExplainAccess(ctx, granted, client.WithMembershipSource(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessAllowed || e.Claims.Subject != uid || !reflect.DeepEqual(e.SharedGroups, []string{uid}) {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	e, err = c.ExplainAccess(ctx, denied, client.WithMembershipSource(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetPermissions fails without access, so the groups with access are unknown.
+	if e.Reason != client.AccessUnknown || e.HasPermission || e.ObjectGroups != nil || !reflect.DeepEqual(e.UserGroups, []string{uid}) {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	// A caller unknown to the membership source has unknown memberships.
+	empty, err := registry.New(&registry.MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = c.ExplainAccess(ctx, denied, client.WithMembershipSource(empty))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessUnknown || e.UserGroups != nil || !strings.Contains(e.String(), "does not know the caller") {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	// Without a membership source, the cause of a denial is unknown.
+	e, err = c.ExplainAccess(ctx, denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessUnknown || e.HasPermission {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	// D1 denies the permission check itself when a scope is missing.
+	denyCheck := grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if method == "/d1.authz.Authz/CheckPermission" {
+			return status.Error(codes.PermissionDenied, "missing scope")
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+	checkOpts := []client.Option{client.WithGrpcOption(denyCheck)}
+	for _, opt := range server.DialOptions() {
+		checkOpts = append(checkOpts, client.WithGrpcOption(opt))
+	}
+	deniedCheck, err := client.NewBaseClient("bufnet", checkOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deniedCheck.Close()
+	e, err = deniedCheck.ExplainAccess(metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+server.Token(uid)), granted, client.WithMembershipSource(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessMissingScope {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	// A client without its own credentials uses the token attached to the context.
+	var opts []client.Option
+	for _, opt := range server.DialOptions() {
+		opts = append(opts, client.WithGrpcOption(opt))
+	}
+	anonymous, err := client.NewBaseClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anonymous.Close()
+
+	readerCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+server.Token(reader))
+	e, err = anonymous.ExplainAccess(readerCtx, readable, client.WithRequiredScopes(scopes.Scope_READ, scopes.Scope_DELETE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessMissingScope || !reflect.DeepEqual(e.MissingScopes, []string{"DELETE"}) {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+	if !strings.Contains(e.String(), "missing: DELETE") {
+		t.Fatalf("unexpected text:\n%s", e)
+	}
+
+	// Memberships are looked up in the registry.
+	if err := reg.Record(registry.Event{Type: registry.EventAddUserToGroups, UserID: reader, GroupIDs: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	server.AddUserToGroups(reader, other)
+	e, err = anonymous.ExplainAccess(readerCtx, denied, client.WithMembershipSource(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessAllowed || !reflect.DeepEqual(e.UserGroups, sorted(other, reader)) {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+
+	e, err = anonymous.ExplainAccess(metadata.AppendToOutgoingContext(ctx, "authorization", "bearer invalid"), granted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != client.AccessUnauthenticated {
+		t.Fatalf("unexpected explanation:\n%s", e)
+	}
+}
+
+func sorted(values ...string) []string {
+	if values[0] > values[1] {
+		values[0], values[1] = values[1], values[0]
+	}
+	return values
+}
+
+var _ client.MembershipSource = (*registry.Registry)(nil)
@@ -0,0 +1,34 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package guest provides temporary access to D1 objects for users outside the organisation.
+
+A Manager creates a standalone user and a group for every guest, grants the group access to the
+requested objects and returns the generated credentials. Once the grant expires, the permissions are
+removed and the user is deleted. Grants are persisted in a Store as they are built up, so a Manager
+started after a restart picks up the outstanding expiries, including those of grants that were only
+partially created.
+
+The Manager must use a client authenticated as a user that may create identities and modify access
+to the objects being shared:
+
+	c, err := client.NewGenericClient(endpoint, client.WithTokenRefresh(uid, password), ...)
+	...
+	m := guest.NewManager(c.Authn, c.Authz, guest.NewFileStore("guests.json"))
+	go m.Run(ctx)
+
+	creds, err := m.Grant(ctx, []string{objectID}, 24*time.Hour)
+*/
+package guest
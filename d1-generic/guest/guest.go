@@ -0,0 +1,244 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+// Grant is an outstanding guest grant. ObjectIDs only contains the objects the group has actually
+// been given access to.
+type Grant struct {
+	UserID    string    `json:"user_id"`
+	GroupID   string    `json:"group_id,omitempty"`
+	ObjectIDs []string  `json:"object_ids,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Credentials are the credentials of a guest user.
+type Credentials struct {
+	UserID    string
+	Password  string
+	GroupID   string
+	ExpiresAt time.Time
+}
+
+// Manager creates and expires guest grants.
+type Manager struct {
+	authn    pbauthn.AuthnClient
+	authz    pbauthz.AuthzClient
+	store    Store
+	scopes   []scopes.Scope
+	interval time.Duration
+	now      func() time.Time
+}
+
+// Option is used to configure optional settings on the manager.
+type Option func(*Manager)
+
+// WithScopes sets the scopes given to guest users and groups. The default is READ only.
+func WithScopes(guestScopes ...scopes.Scope) Option {
+	return func(m *Manager) {
+		m.scopes = guestScopes
+	}
+}
+
+// WithInterval sets how often Run checks for expired grants. The default is one minute.
+func WithInterval(interval time.Duration) Option {
+	return func(m *Manager) {
+		m.interval = interval
+	}
+}
+
+// NewManager creates a Manager using the given clients and schedule store.
+func NewManager(authn pbauthn.AuthnClient, authz pbauthz.AuthzClient, store Store, opts ...Option) *Manager {
+	m := &Manager{
+		authn:    authn,
+		authz:    authz,
+		store:    store,
+		scopes:   []scopes.Scope{scopes.Scope_READ},
+		interval: time.Minute,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Grant creates a guest user with access to the given objects for the duration of ttl. If the
+// grant cannot be completed, everything created so far is revoked again.
+func (m *Manager) Grant(ctx context.Context, objectIDs []string, ttl time.Duration) (*Credentials, error) {
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+
+	user, err := m.authn.CreateUser(ctx, &pbauthn.CreateUserRequest{Scopes: m.scopes})
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	grant := Grant{
+		UserID:    user.UserId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := m.store.Put(grant); err != nil {
+		return nil, m.abort(ctx, grant, err)
+	}
+
+	group, err := m.authn.CreateGroup(ctx, &pbauthn.CreateGroupRequest{Scopes: m.scopes})
+	if err != nil {
+		return nil, m.abort(ctx, grant, err)
+	}
+	grant.GroupID = group.GroupId
+	if err := m.store.Put(grant); err != nil {
+		return nil, m.abort(ctx, grant, err)
+	}
+
+	_, err = m.authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{
+		UserId:   grant.UserID,
+		GroupIds: []string{grant.GroupID},
+	})
+	if err != nil {
+		return nil, m.abort(ctx, grant, err)
+	}
+
+	for _, objectID := range objectIDs {
+		_, err := m.authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{
+			ObjectId: objectID,
+			GroupIds: []string{grant.GroupID},
+		})
+		if err != nil {
+			return nil, m.abort(ctx, grant, fmt.Errorf("granting access to %s: %w", objectID, err))
+		}
+		grant.ObjectIDs = append(grant.ObjectIDs, objectID)
+		if err := m.store.Put(grant); err != nil {
+			return nil, m.abort(ctx, grant, err)
+		}
+	}
+
+	return &Credentials{
+		UserID:    grant.UserID,
+		Password:  user.Password,
+		GroupID:   grant.GroupID,
+		ExpiresAt: grant.ExpiresAt,
+	}, nil
+}
+
+// Revoke revokes the grant of the given guest user immediately.
+func (m *Manager) Revoke(ctx context.Context, userID string) error {
+	grants, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if grant.UserID == userID {
+			return m.revoke(ctx, grant)
+		}
+	}
+	return fmt.Errorf("no grant for user %s", userID)
+}
+
+// Grants returns the outstanding grants ordered by expiry.
+func (m *Manager) Grants() ([]Grant, error) {
+	return m.store.List()
+}
+
+// ExpireDue revokes all grants that have expired and returns the number revoked. Grants that fail
+// to be revoked are kept in the store and retried on the next call.
+func (m *Manager) ExpireDue(ctx context.Context) (int, error) {
+	grants, err := m.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var firstErr error
+	revoked := 0
+	now := m.now()
+	for _, grant := range grants {
+		if grant.ExpiresAt.After(now) {
+			continue
+		}
+		if err := m.revoke(ctx, grant); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("revoking grant for %s: %w", grant.UserID, err)
+			}
+			continue
+		}
+		revoked++
+	}
+	return revoked, firstErr
+}
+
+// Run expires grants until the context is cancelled. Grants that expired while no manager was
+// running are revoked immediately. Errors are retried on the next tick.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		_, _ = m.ExpireDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// abort revokes a partially created grant and returns the error that caused it.
+func (m *Manager) abort(ctx context.Context, grant Grant, cause error) error {
+	if err := m.revoke(ctx, grant); err != nil {
+		return fmt.Errorf("%w (cleanup failed: %v)", cause, err)
+	}
+	return cause
+}
+
+// revoke removes the group's permissions and the user, then deletes the grant from the store.
+// Objects and users that no longer exist are ignored.
+func (m *Manager) revoke(ctx context.Context, grant Grant) error {
+	if grant.GroupID != "" {
+		for _, objectID := range grant.ObjectIDs {
+			_, err := m.authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{
+				ObjectId: objectID,
+				GroupIds: []string{grant.GroupID},
+			})
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+	}
+
+	_, err := m.authn.RemoveUser(ctx, &pbauthn.RemoveUserRequest{UserId: grant.UserID})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	return m.store.Delete(grant.UserID)
+}
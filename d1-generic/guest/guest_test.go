@@ -0,0 +1,111 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func newClient(t *testing.T, server *d1test.Server, uid, pwd string) client.BaseClient {
+	c, err := client.NewBaseClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestGrantExpiresAfterRestart(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c := newClient(t, server, uid, pwd)
+	objects := []string{server.NewObject(uid), server.NewObject(uid)}
+
+	store := NewFileStore(filepath.Join(t.TempDir(), "guests.json"))
+	now := time.Now()
+	m := NewManager(c.Authn, c.Authz, store)
+	m.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	creds, err := m.Grant(ctx, objects, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest := newClient(t, server, creds.UserID, creds.Password)
+	for _, oid := range objects {
+		res, err := guest.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: oid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.HasPermission {
+			t.Fatalf("guest has no access to %s", oid)
+		}
+	}
+
+	// Nothing has expired yet.
+	if n, err := m.ExpireDue(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no expired grants, got %d, %v", n, err)
+	}
+
+	// A new manager picks up the persisted schedule.
+	m = NewManager(c.Authn, c.Authz, store)
+	m.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if n, err := m.ExpireDue(ctx); err != nil || n != 1 {
+		t.Fatalf("expected one expired grant, got %d, %v", n, err)
+	}
+
+	if server.HasUser(creds.UserID) {
+		t.Fatal("guest user was not removed")
+	}
+	for _, oid := range objects {
+		for _, gid := range server.ObjectGroups(oid) {
+			if gid == creds.GroupID {
+				t.Fatalf("guest group still has access to %s", oid)
+			}
+		}
+	}
+	if grants, _ := m.Grants(); len(grants) != 0 {
+		t.Fatalf("unexpected outstanding grants: %+v", grants)
+	}
+}
+
+func TestGrantFailureIsRolledBack(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c := newClient(t, server, uid, pwd)
+
+	// The manager's user has no access to the second object.
+	objects := []string{server.NewObject(uid), server.NewObject()}
+
+	m := NewManager(c.Authn, c.Authz, &MemoryStore{})
+	if _, err := m.Grant(context.Background(), objects, time.Hour); err == nil {
+		t.Fatal("expected grant to fail")
+	}
+
+	if grants, _ := m.Grants(); len(grants) != 0 {
+		t.Fatalf("unexpected outstanding grants: %+v", grants)
+	}
+	if groups := server.ObjectGroups(objects[0]); len(groups) != 1 || groups[0] != uid {
+		t.Fatalf("unexpected groups for %s: %v", objects[0], groups)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store persists the schedule of outstanding grants.
+type Store interface {
+	// Put inserts or replaces the grant with the same UserID.
+	Put(Grant) error
+	// Delete removes the grant of the given user.
+	Delete(userID string) error
+	// List returns all outstanding grants.
+	List() ([]Grant, error)
+}
+
+// MemoryStore is a Store that keeps grants in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(grant Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.grants == nil {
+		m.grants = map[string]Grant{}
+	}
+	m.grants[grant.UserID] = grant
+	return nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.grants, userID)
+	return nil
+}
+
+// List implements Store.
+func (m *MemoryStore) List() ([]Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedGrants(m.grants), nil
+}
+
+// FileStore is a Store that keeps grants in a JSON file. The file is replaced atomically on every
+// change.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore creates a FileStore backed by the file at the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Put implements Store.
+func (f *FileStore) Put(grant Grant) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	grants, err := f.load()
+	if err != nil {
+		return err
+	}
+	grants[grant.UserID] = grant
+	return f.save(grants)
+}
+
+// Delete implements Store.
+func (f *FileStore) Delete(userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	grants, err := f.load()
+	if err != nil {
+		return err
+	}
+	delete(grants, userID)
+	return f.save(grants)
+}
+
+// List implements Store.
+func (f *FileStore) List() ([]Grant, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	grants, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return sortedGrants(grants), nil
+}
+
+func (f *FileStore) load() (map[string]Grant, error) {
+	grants := map[string]Grant{}
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return grants, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Grant
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, grant := range list {
+		grants[grant.UserID] = grant
+	}
+	return grants, nil
+}
+
+func (f *FileStore) save(grants map[string]Grant) error {
+	data, err := json.MarshalIndent(sortedGrants(grants), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func sortedGrants(grants map[string]Grant) []Grant {
+	list := make([]Grant, 0, len(grants))
+	for _, grant := range grants {
+		list = append(list, grant)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ExpiresAt.Equal(list[j].ExpiresAt) {
+			return list[i].ExpiresAt.Before(list[j].ExpiresAt)
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
+}
@@ -0,0 +1,28 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package hierarchy emulates nested groups, such as team → department → organisation, on top of the
+flat groups of D1.
+
+Every node of the hierarchy is backed by a D1 group. A user added to a node is added to the groups
+of the node and all of its ancestors, so access granted to a department reaches the members of its
+teams. Access is granted to the group of the node only, so it follows the node when the node is
+moved, and node group IDs can be used directly in the GroupIds of EncryptRequest and StoreRequest.
+
+The Manager tracks which users were added to which node. When a node is moved or removed, the D1
+memberships of the affected users are updated to match the new hierarchy. Memberships created
+outside the Manager are not tracked.
+*/
+package hierarchy
@@ -0,0 +1,497 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hierarchy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+// ErrUnknownNode is returned when a node name is not part of the hierarchy.
+var ErrUnknownNode = errors.New("unknown node")
+
+type node struct {
+	name     string
+	groupID  string
+	parent   string
+	children map[string]bool
+	members  map[string]bool
+}
+
+// Manager maintains a group hierarchy and the D1 memberships it implies.
+type Manager struct {
+	mu      sync.Mutex
+	authn   pbauthn.AuthnClient
+	authz   pbauthz.AuthzClient
+	store   Store
+	scopes  []scopes.Scope
+	nodes   map[string]*node
+	byGroup map[string]*node
+}
+
+// Option is used to configure optional settings on the manager.
+type Option func(*Manager)
+
+// WithGroupScopes sets the scopes of the D1 groups created for new nodes. The default is all
+// scopes.
+func WithGroupScopes(groupScopes ...scopes.Scope) Option {
+	return func(m *Manager) {
+		m.scopes = groupScopes
+	}
+}
+
+// NewManager creates a Manager using the given clients and loads the hierarchy from the store.
+func NewManager(authn pbauthn.AuthnClient, authz pbauthz.AuthzClient, store Store, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		authn: authn,
+		authz: authz,
+		store: store,
+		scopes: []scopes.Scope{
+			scopes.Scope_READ,
+			scopes.Scope_CREATE,
+			scopes.Scope_GETACCESS,
+			scopes.Scope_MODIFYACCESS,
+			scopes.Scope_UPDATE,
+			scopes.Scope_DELETE,
+		},
+		nodes:   make(map[string]*node),
+		byGroup: make(map[string]*node),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	nodes, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		m.insert(n.Name, n.GroupID, n.Parent)
+		for _, uid := range n.MemberIDs {
+			m.nodes[n.Name].members[uid] = true
+		}
+	}
+	for _, n := range m.nodes {
+		if n.parent == "" {
+			continue
+		}
+		parent, ok := m.nodes[n.parent]
+		if !ok {
+			return nil, fmt.Errorf("node %s: parent %s: %w", n.name, n.parent, ErrUnknownNode)
+		}
+		parent.children[n.name] = true
+	}
+	return m, nil
+}
+
+// AddNode creates a D1 group and adds it to the hierarchy under the given parent. An empty parent
+// creates a root node. The ID of the new group is returned.
+func (m *Manager) AddNode(ctx context.Context, name, parent string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if name == "" {
+		return "", errors.New("node name must not be empty")
+	}
+	if _, ok := m.nodes[name]; ok {
+		return "", fmt.Errorf("node %s already exists", name)
+	}
+	if _, ok := m.nodes[parent]; parent != "" && !ok {
+		return "", fmt.Errorf("parent %s: %w", parent, ErrUnknownNode)
+	}
+
+	res, err := m.authn.CreateGroup(ctx, &pbauthn.CreateGroupRequest{Scopes: m.scopes})
+	if err != nil {
+		return "", err
+	}
+
+	m.insert(name, res.GroupId, parent)
+	if parent != "" {
+		m.nodes[parent].children[name] = true
+	}
+	if err := m.save(); err != nil {
+		m.delete(name)
+		return "", err
+	}
+	return res.GroupId, nil
+}
+
+// MoveNode moves a node, including its descendants, under a new parent. An empty parent makes the
+// node a root. The memberships of every user in the moved subtree are updated to match.
+func (m *Manager) MoveNode(ctx context.Context, name, parent string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return fmt.Errorf("node %s: %w", name, ErrUnknownNode)
+	}
+	if parent != "" {
+		if _, ok := m.nodes[parent]; !ok {
+			return fmt.Errorf("parent %s: %w", parent, ErrUnknownNode)
+		}
+		for p := parent; p != ""; p = m.nodes[p].parent {
+			if p == name {
+				return fmt.Errorf("cannot move %s below itself", name)
+			}
+		}
+	}
+	if n.parent == parent {
+		return nil
+	}
+
+	users := m.subtreeMembers(name)
+	before := m.effectiveGroups(users)
+
+	oldParent := n.parent
+	m.setParent(n, parent)
+	after := m.effectiveGroups(users)
+
+	if err := m.sync(ctx, before, after); err != nil {
+		m.setParent(n, oldParent)
+		return withRollback(err, m.sync(ctx, after, before))
+	}
+	if err := m.save(); err != nil {
+		m.setParent(n, oldParent)
+		return withRollback(err, m.sync(ctx, after, before))
+	}
+	return nil
+}
+
+// RemoveNode removes a node without children from the hierarchy. Its members lose the memberships
+// implied by the node. The backing D1 group itself is left in place.
+func (m *Manager) RemoveNode(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return fmt.Errorf("node %s: %w", name, ErrUnknownNode)
+	}
+	if len(n.children) > 0 {
+		return fmt.Errorf("node %s has children", name)
+	}
+
+	users := sortedKeys(n.members)
+	before := m.effectiveGroups(users)
+	members := n.members
+	n.members = map[string]bool{}
+	after := m.effectiveGroups(users)
+
+	if err := m.sync(ctx, before, after); err != nil {
+		n.members = members
+		return withRollback(err, m.sync(ctx, after, before))
+	}
+
+	m.delete(name)
+	if err := m.save(); err != nil {
+		m.insert(n.name, n.groupID, n.parent)
+		if n.parent != "" {
+			m.nodes[n.parent].children[name] = true
+		}
+		m.nodes[name].members = members
+		return withRollback(err, m.sync(ctx, after, before))
+	}
+	return nil
+}
+
+// AddUserToGroups adds a user to the given groups. Groups that belong to the hierarchy make the
+// user a member of the node and, through it, of all of its ancestors.
+func (m *Manager) AddUserToGroups(ctx context.Context, userID string, groupIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expanded := []string{}
+	var added []*node
+	for _, gid := range groupIDs {
+		n, ok := m.byGroup[gid]
+		if !ok {
+			expanded = append(expanded, gid)
+			continue
+		}
+		expanded = append(expanded, m.ancestorGroups(n.name)...)
+		if !n.members[userID] {
+			added = append(added, n)
+		}
+	}
+
+	_, err := m.authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{
+		UserId:   userID,
+		GroupIds: dedup(expanded),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, n := range added {
+		n.members[userID] = true
+	}
+	if err := m.save(); err != nil {
+		for _, n := range added {
+			delete(n.members, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveUserFromGroups removes a user from the given groups. For groups that belong to the
+// hierarchy, the user only loses the ancestor groups that are not implied by another membership.
+func (m *Manager) RemoveUserFromGroups(ctx context.Context, userID string, groupIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []string{userID}
+	before := m.effectiveGroups(users)[userID]
+
+	var plain []string
+	var removed []*node
+	for _, gid := range groupIDs {
+		n, ok := m.byGroup[gid]
+		if !ok {
+			plain = append(plain, gid)
+			continue
+		}
+		if n.members[userID] {
+			delete(n.members, userID)
+			removed = append(removed, n)
+		}
+	}
+	after := m.effectiveGroups(users)[userID]
+
+	restore := func() {
+		for _, n := range removed {
+			n.members[userID] = true
+		}
+	}
+
+	remove := append(plain, subtract(before, after)...)
+	if len(remove) > 0 {
+		_, err := m.authn.RemoveUserFromGroups(ctx, &pbauthn.RemoveUserFromGroupsRequest{
+			UserId:   userID,
+			GroupIds: dedup(remove),
+		})
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+	if err := m.save(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// AddPermission gives the given groups access to an object. Only the groups themselves are added
+// to the ACL. Access granted to a node reaches the members of its descendants through their
+// ancestor memberships, so it follows the node when the hierarchy changes.
+func (m *Manager) AddPermission(ctx context.Context, objectID string, groupIDs []string) error {
+	_, err := m.authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{
+		ObjectId: objectID,
+		GroupIds: groupIDs,
+	})
+	return err
+}
+
+// RemovePermission removes the access of the given groups to an object.
+func (m *Manager) RemovePermission(ctx context.Context, objectID string, groupIDs []string) error {
+	_, err := m.authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{
+		ObjectId: objectID,
+		GroupIds: groupIDs,
+	})
+	return err
+}
+
+// GroupID returns the ID of the D1 group backing the given node.
+func (m *Manager) GroupID(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return "", fmt.Errorf("node %s: %w", name, ErrUnknownNode)
+	}
+	return n.groupID, nil
+}
+
+// Nodes returns the nodes of the hierarchy sorted by name.
+func (m *Manager) Nodes() []Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot()
+}
+
+func (m *Manager) insert(name, groupID, parent string) {
+	n := &node{
+		name:     name,
+		groupID:  groupID,
+		parent:   parent,
+		children: map[string]bool{},
+		members:  map[string]bool{},
+	}
+	m.nodes[name] = n
+	m.byGroup[groupID] = n
+}
+
+func (m *Manager) delete(name string) {
+	n := m.nodes[name]
+	if n.parent != "" {
+		delete(m.nodes[n.parent].children, name)
+	}
+	delete(m.byGroup, n.groupID)
+	delete(m.nodes, name)
+}
+
+func (m *Manager) setParent(n *node, parent string) {
+	if n.parent != "" {
+		delete(m.nodes[n.parent].children, n.name)
+	}
+	n.parent = parent
+	if parent != "" {
+		m.nodes[parent].children[n.name] = true
+	}
+}
+
+func (m *Manager) save() error {
+	return m.store.Save(m.snapshot())
+}
+
+func (m *Manager) snapshot() []Node {
+	nodes := make([]Node, 0, len(m.nodes))
+	for _, name := range sortedKeys(m.nodes) {
+		n := m.nodes[name]
+		nodes = append(nodes, Node{
+			Name:      n.name,
+			GroupID:   n.groupID,
+			Parent:    n.parent,
+			MemberIDs: sortedKeys(n.members),
+		})
+	}
+	return nodes
+}
+
+// ancestorGroups returns the groups of the node and all of its ancestors.
+func (m *Manager) ancestorGroups(name string) []string {
+	var groups []string
+	for p := name; p != ""; p = m.nodes[p].parent {
+		groups = append(groups, m.nodes[p].groupID)
+	}
+	return groups
+}
+
+// subtreeMembers returns the users that are direct members of the node or any of its descendants.
+func (m *Manager) subtreeMembers(name string) []string {
+	users := map[string]bool{}
+	var walk func(string)
+	walk = func(name string) {
+		n := m.nodes[name]
+		for uid := range n.members {
+			users[uid] = true
+		}
+		for child := range n.children {
+			walk(child)
+		}
+	}
+	walk(name)
+	return sortedKeys(users)
+}
+
+// effectiveGroups returns the hierarchy groups implied by the direct memberships of each user.
+func (m *Manager) effectiveGroups(users []string) map[string][]string {
+	groups := make(map[string][]string, len(users))
+	for _, uid := range users {
+		var gids []string
+		for _, name := range sortedKeys(m.nodes) {
+			if m.nodes[name].members[uid] {
+				gids = append(gids, m.ancestorGroups(name)...)
+			}
+		}
+		groups[uid] = dedup(gids)
+	}
+	return groups
+}
+
+// sync updates D1 so that each user goes from the groups in before to the groups in after. New
+// memberships are added before old ones are removed.
+func (m *Manager) sync(ctx context.Context, before, after map[string][]string) error {
+	for _, uid := range sortedKeys(after) {
+		if add := subtract(after[uid], before[uid]); len(add) > 0 {
+			_, err := m.authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{UserId: uid, GroupIds: add})
+			if err != nil {
+				return fmt.Errorf("adding %s to groups: %w", uid, err)
+			}
+		}
+	}
+	for _, uid := range sortedKeys(before) {
+		if remove := subtract(before[uid], after[uid]); len(remove) > 0 {
+			_, err := m.authn.RemoveUserFromGroups(ctx, &pbauthn.RemoveUserFromGroupsRequest{UserId: uid, GroupIds: remove})
+			if err != nil {
+				return fmt.Errorf("removing %s from groups: %w", uid, err)
+			}
+		}
+	}
+	return nil
+}
+
+func subtract(a, b []string) []string {
+	drop := make(map[string]bool, len(b))
+	for _, s := range b {
+		drop[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !drop[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func dedup(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := []string{}
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withRollback adds the error of a failed rollback to err.
+func withRollback(err, rollbackErr error) error {
+	if rollbackErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+}
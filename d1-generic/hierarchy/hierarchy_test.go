@@ -0,0 +1,204 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hierarchy
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+type fixture struct {
+	server *d1test.Server
+	client client.BaseClient
+	store  Store
+	groups map[string]string
+}
+
+// newFixture creates the hierarchy org → {sales, engineering} and engineering → backend.
+func newFixture(t *testing.T) (*fixture, *Manager) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewBaseClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	f := &fixture{
+		server: server,
+		client: c,
+		store:  NewFileStore(filepath.Join(t.TempDir(), "hierarchy.json")),
+		groups: map[string]string{},
+	}
+	m := f.manager(t)
+
+	for _, n := range []struct{ name, parent string }{
+		{"org", ""},
+		{"sales", "org"},
+		{"engineering", "org"},
+		{"backend", "engineering"},
+	} {
+		gid, err := m.AddNode(context.Background(), n.name, n.parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.groups[n.name] = gid
+	}
+	return f, m
+}
+
+func (f *fixture) manager(t *testing.T) *Manager {
+	m, err := NewManager(f.client.Authn, f.client.Authz, f.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// hierarchyGroups returns the names of the hierarchy nodes whose groups the user is a member of.
+func (f *fixture) hierarchyGroups(uid string) []string {
+	names := map[string]string{}
+	for name, gid := range f.groups {
+		names[gid] = name
+	}
+	out := []string{}
+	for _, gid := range f.server.UserGroups(uid) {
+		if name, ok := names[gid]; ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (f *fixture) ids(names ...string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, f.groups[name])
+	}
+	return out
+}
+
+func TestMembershipExpandsToAncestors(t *testing.T) {
+	f, m := newFixture(t)
+	ctx := context.Background()
+	user, _ := f.server.NewUser()
+
+	if err := m.AddUserToGroups(ctx, user, f.ids("backend", "sales")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.hierarchyGroups(user), []string{"backend", "engineering", "org", "sales"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+
+	// org is still implied by sales.
+	if err := m.RemoveUserFromGroups(ctx, user, f.ids("backend")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.hierarchyGroups(user), []string{"org", "sales"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+}
+
+func TestPermissionReachesDescendants(t *testing.T) {
+	f, m := newFixture(t)
+	ctx := context.Background()
+	oid := f.server.NewObject(f.groups["org"])
+
+	uid, pwd := f.server.NewUser(d1test.AllScopes()...)
+	if err := m.AddUserToGroups(ctx, uid, f.ids("backend")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.NewBaseClient("bufnet", f.server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	check := func(expected bool) {
+		t.Helper()
+		res, err := c.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: oid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.HasPermission != expected {
+			t.Fatalf("expected permission %v, got %v", expected, res.HasPermission)
+		}
+	}
+
+	// Only the node's own group is added to the ACL.
+	if err := m.AddPermission(ctx, oid, f.ids("engineering")); err != nil {
+		t.Fatal(err)
+	}
+	want := f.ids("org", "engineering")
+	sort.Strings(want)
+	if got := f.server.ObjectGroups(oid); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got object groups %v, want %v", got, want)
+	}
+
+	// The member of backend has access through engineering alone.
+	if err := m.RemovePermission(ctx, oid, f.ids("org")); err != nil {
+		t.Fatal(err)
+	}
+	check(true)
+
+	// Moving backend out of engineering revokes the access.
+	if err := m.MoveNode(ctx, "backend", "sales"); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+
+}
+
+func TestMoveNodeUpdatesMemberships(t *testing.T) {
+	f, m := newFixture(t)
+	ctx := context.Background()
+	user, _ := f.server.NewUser()
+
+	if err := m.AddUserToGroups(ctx, user, f.ids("backend")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MoveNode(ctx, "backend", "sales"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.hierarchyGroups(user), []string{"backend", "org", "sales"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+
+	if err := m.MoveNode(ctx, "org", "backend"); err == nil {
+		t.Fatal("expected cycle to be rejected")
+	}
+
+	// The hierarchy and its members survive a restart.
+	m = f.manager(t)
+	if err := m.RemoveNode(ctx, "sales"); err == nil {
+		t.Fatal("expected node with children to be rejected")
+	}
+	if err := m.RemoveNode(ctx, "backend"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.hierarchyGroups(user), []string{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+	if _, err := m.GroupID("backend"); err == nil {
+		t.Fatal("expected removed node to be unknown")
+	}
+}
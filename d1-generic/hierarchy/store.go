@@ -0,0 +1,136 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hierarchy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Node is a node of the hierarchy as persisted in a Store.
+type Node struct {
+	Name    string `json:"name"`
+	GroupID string `json:"group_id"`
+	// Parent is the name of the parent node, or empty for a root.
+	Parent string `json:"parent,omitempty"`
+	// MemberIDs are the users added directly to the node.
+	MemberIDs []string `json:"member_ids,omitempty"`
+}
+
+// Store persists the hierarchy.
+type Store interface {
+	// Load returns the persisted nodes, or nil if nothing has been saved yet.
+	Load() ([]Node, error)
+	// Save replaces the persisted nodes.
+	Save([]Node) error
+}
+
+// MemoryStore is a Store that keeps the hierarchy in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// Load implements Store.
+func (m *MemoryStore) Load() ([]Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return decode(m.data)
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(nodes []Node) error {
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = data
+	return nil
+}
+
+// FileStore is a Store that keeps the hierarchy in a JSON file. The file is replaced atomically
+// on every save.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore creates a FileStore backed by the file at the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load implements Store. A missing file is treated as an empty hierarchy.
+func (f *FileStore) Load() ([]Node, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Save implements Store.
+func (f *FileStore) Save(nodes []Node) error {
+	data, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func decode(data []byte) ([]Node, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var nodes []Node
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+)
+
+// JSONOption is used to configure EncryptJSON.
+type JSONOption func(*jsonConfig)
+
+type jsonConfig struct {
+	groupIDs []string
+}
+
+// WithJSONGroups returns a JSONOption which gives the groups access to every encrypted value.
+func WithJSONGroups(groupIDs ...string) JSONOption {
+	return func(c *jsonConfig) {
+		c.groupIDs = groupIDs
+	}
+}
+
+// EncryptJSON encrypts the values of a JSON document selected by paths and replaces each of them
+// with a string holding an envelope in text form. The rest of the document is left byte-for-byte
+// unchanged.
+//
+// Paths are written as $.field.nested, $.items[0], $["field with spaces"] and may contain the
+// wildcards [*], matching every element of an array, and .*, matching every member of an object.
+// The leading $ may be omitted. A value may be of any JSON type and is encrypted as written in the
+// document. Values nested inside a selected value are not selected separately.
+//
+// Each value is bound to its location in the document with array indexes left out, so an envelope
+// cannot be moved to another field, but arrays may be reordered.
+func (c *GenericClient) EncryptJSON(ctx context.Context, doc []byte, paths []string, opts ...JSONOption) ([]byte, error) {
+	var config jsonConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return transformJSON(doc, paths, func(path string, value []byte) ([]byte, error) {
+		env, err := envelope.Encrypt(ctx, c.Generic, value, jsonAssociatedData(path), envelope.WithGroups(config.groupIDs...))
+		if err != nil {
+			return nil, err
+		}
+		text, err := env.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(string(text))
+	})
+}
+
+// DecryptJSON reverses EncryptJSON, given the same paths. Selected values that are null are left
+// unchanged; any other value must be a string holding an envelope.
+func (c *GenericClient) DecryptJSON(ctx context.Context, doc []byte, paths []string) ([]byte, error) {
+	return transformJSON(doc, paths, func(path string, value []byte) ([]byte, error) {
+		if bytes.Equal(value, []byte("null")) {
+			return value, nil
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			return nil, errors.New("value is not an encrypted string")
+		}
+		env := &envelope.Envelope{}
+		if err := env.UnmarshalText([]byte(text)); err != nil {
+			return nil, err
+		}
+		env.AssociatedData = jsonAssociatedData(path)
+		return env.Decrypt(ctx, c.Generic)
+	})
+}
+
+func jsonAssociatedData(path string) []byte {
+	data, _ := json.Marshal(struct {
+		Path string `json:"path"`
+	}{path})
+	return data
+}
+
+// transformJSON replaces every value of doc selected by paths with the output of fn. fn receives
+// the location of the value with array indexes replaced by [*].
+func transformJSON(doc []byte, paths []string, fn func(path string, value []byte) ([]byte, error)) ([]byte, error) {
+	if !json.Valid(doc) {
+		return nil, errors.New("invalid JSON document")
+	}
+	selectors := make([][]pathSegment, len(paths))
+	for i, path := range paths {
+		var err error
+		if selectors[i], err = parseJSONPath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &jsonScanner{data: doc, selectors: selectors}
+	s.value(nil, false)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, span := range s.spans {
+		replacement, err := fn(span.path, doc[span.start:span.end])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", span.path, err)
+		}
+		out.Write(doc[last:span.start])
+		out.Write(replacement)
+		last = span.end
+	}
+	out.Write(doc[last:])
+	return out.Bytes(), nil
+}
+
+// pathSegment is a single step of a JSON path. A wildcard segment matches any key or index.
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimPrefix(path, "$")
+	var segments []pathSegment
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			segments = append(segments, pathSegment{wildcard: true})
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty field name", path)
+			}
+			segments = append(segments, pathSegment{key: rest[1 : 1+end]})
+			rest = rest[1+end:]
+		case strings.HasPrefix(rest, "[*]"):
+			segments = append(segments, pathSegment{wildcard: true, isIndex: true})
+			rest = rest[3:]
+		case strings.HasPrefix(rest, `["`), strings.HasPrefix(rest, "['"):
+			quote := rest[1]
+			end := strings.IndexByte(rest[2:], quote)
+			if end < 0 || !strings.HasPrefix(rest[2+end+1:], "]") {
+				return nil, fmt.Errorf("invalid path %q: unterminated field name", path)
+			}
+			segments = append(segments, pathSegment{key: rest[2 : 2+end]})
+			rest = rest[2+end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated index", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", path, rest[1:end])
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			if len(segments) == 0 && path[0] != '$' {
+				// A path without the leading $ starts directly with a field name.
+				rest = "." + rest
+				continue
+			}
+			return nil, fmt.Errorf("invalid path %q at %q", path, rest)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid path %q: the whole document cannot be selected", path)
+	}
+	return segments, nil
+}
+
+type jsonSpan struct {
+	path       string
+	start, end int
+}
+
+// jsonScanner walks a valid JSON document and records the spans of the values selected by any of
+// the selectors. The document must have been validated beforehand.
+type jsonScanner struct {
+	data      []byte
+	pos       int
+	selectors [][]pathSegment
+	spans     []jsonSpan
+	err       error
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n", s.data[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// value scans the value at the current position. path is the location of the value. If skip is
+// set, the value is inside a selected value and is only skipped.
+func (s *jsonScanner) value(path []pathSegment, skip bool) {
+	s.skipSpace()
+	start := s.pos
+	selected := !skip && s.selected(path)
+	skip = skip || selected
+
+	switch s.data[s.pos] {
+	case '{':
+		s.pos++
+		for {
+			s.skipSpace()
+			if s.data[s.pos] == '}' {
+				break
+			}
+			keyStart := s.pos
+			s.skipString()
+			var key string
+			if err := json.Unmarshal(s.data[keyStart:s.pos], &key); err != nil && s.err == nil {
+				s.err = err
+			}
+			s.skipSpace()
+			s.pos++ // ':'
+			s.value(appendSegment(path, pathSegment{key: key}), skip)
+			s.skipSpace()
+			if s.data[s.pos] == ',' {
+				s.pos++
+			}
+		}
+		s.pos++
+	case '[':
+		s.pos++
+		for index := 0; ; index++ {
+			s.skipSpace()
+			if s.data[s.pos] == ']' {
+				break
+			}
+			s.value(appendSegment(path, pathSegment{index: index, isIndex: true}), skip)
+			s.skipSpace()
+			if s.data[s.pos] == ',' {
+				s.pos++
+			}
+		}
+		s.pos++
+	case '"':
+		s.skipString()
+	default:
+		for s.pos < len(s.data) && strings.IndexByte(",]} \t\r\n", s.data[s.pos]) < 0 {
+			s.pos++
+		}
+	}
+
+	if selected {
+		s.spans = append(s.spans, jsonSpan{path: formatJSONLocation(path), start: start, end: s.pos})
+	}
+}
+
+// selected reports whether any selector matches the location exactly.
+func (s *jsonScanner) selected(path []pathSegment) bool {
+	for _, selector := range s.selectors {
+		if len(selector) != len(path) {
+			continue
+		}
+		match := true
+		for i, segment := range selector {
+			p := path[i]
+			switch {
+			case segment.wildcard:
+				// .* only matches object members and [*] only array elements.
+				match = segment.isIndex == p.isIndex
+			case segment.isIndex:
+				match = p.isIndex && p.index == segment.index
+			default:
+				match = !p.isIndex && p.key == segment.key
+			}
+			if !match {
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *jsonScanner) skipString() {
+	s.pos++ // opening quote
+	for s.data[s.pos] != '"' {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+		s.pos++
+	}
+	s.pos++
+}
+
+func appendSegment(path []pathSegment, segment pathSegment) []pathSegment {
+	return append(path[:len(path):len(path)], segment)
+}
+
+// formatJSONLocation formats a location with array indexes replaced by [*].
+func formatJSONLocation(path []pathSegment) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, segment := range path {
+		switch {
+		case segment.isIndex:
+			b.WriteString("[*]")
+		case isJSONIdentifier(segment.key):
+			b.WriteString(".")
+			b.WriteString(segment.key)
+		default:
+			quoted, _ := json.Marshal(segment.key)
+			b.WriteString("[")
+			b.Write(quoted)
+			b.WriteString("]")
+		}
+	}
+	return b.String()
+}
+
+func isJSONIdentifier(key string) bool {
+	if key == "" || key == "*" {
+		return false
+	}
+	return !strings.ContainsAny(key, ".[]'\" \t\r\n")
+}
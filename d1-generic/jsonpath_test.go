@@ -0,0 +1,120 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testDocument = `{
+  "id": 17,
+  "name":   "Alice",
+  "ssn": "123-45-6789",
+  "cards": [
+    {"number": "4111 1111 1111 1111", "expiry": "12/30"},
+    {"number": "5500 0000 0000 0004", "expiry": "01/29"}
+  ],
+  "address": {"street": "1 Main St", "zip": 1000},
+  "tags": {"a": [1, 2], "b": null},
+  "weird key": "x\"y"
+}`
+
+func TestEncryptJSON(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+	paths := []string{"$.ssn", "$.cards[*].number", "address", `$["weird key"]`, "$.tags.*"}
+
+	encrypted, err := c.EncryptJSON(ctx, []byte(testDocument), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"123-45-6789", "4111", "5500", "Main St", `x\"y`, "[1, 2]"} {
+		if bytes.Contains(encrypted, []byte(secret)) {
+			t.Fatalf("%q was not encrypted:\n%s", secret, encrypted)
+		}
+	}
+	for _, kept := range []string{`"name":   "Alice"`, `"expiry": "12/30"`, `"id": 17`} {
+		if !bytes.Contains(encrypted, []byte(kept)) {
+			t.Fatalf("%q was changed:\n%s", kept, encrypted)
+		}
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(encrypted, &doc); err != nil {
+		t.Fatalf("encrypted document is not valid JSON: %v", err)
+	}
+
+	decrypted, err := c.DecryptJSON(ctx, encrypted, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != testDocument {
+		t.Fatalf("got\n%s\nwant\n%s", decrypted, testDocument)
+	}
+}
+
+func TestEncryptJSONBinding(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	encrypted, err := c.EncryptJSON(ctx, []byte(`{"a": "secret", "b": "other", "list": [1, 2]}`), []string{"$.a", "$.b", "$.list[*]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(encrypted, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reordering an array keeps the values decryptable.
+	list := doc["list"].([]interface{})
+	list[0], list[1] = list[1], list[0]
+	reordered, _ := json.Marshal(doc)
+	decrypted, err := c.DecryptJSON(ctx, reordered, []string{"$.a", "$.b", "$.list[*]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decrypted), `"list":[2,1]`) {
+		t.Fatalf("unexpected document %s", decrypted)
+	}
+
+	// Moving a value to another field does not.
+	doc["a"], doc["b"] = doc["b"], doc["a"]
+	swapped, _ := json.Marshal(doc)
+	if _, err := c.DecryptJSON(ctx, swapped, []string{"$.a"}); err == nil {
+		t.Fatal("expected decryption of moved value to fail")
+	}
+}
+
+func TestEncryptJSONErrors(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := c.EncryptJSON(ctx, []byte(`{"a": `), []string{"$.a"}); err == nil {
+		t.Error("expected error for invalid document")
+	}
+	for _, path := range []string{"$", "$.a[", "$.a[x]", `$["a`, "$..a"} {
+		if _, err := c.EncryptJSON(ctx, []byte(`{"a": 1}`), []string{path}); err == nil {
+			t.Errorf("expected error for path %q", path)
+		}
+	}
+	if _, err := c.DecryptJSON(ctx, []byte(`{"a": 1}`), []string{"$.a"}); err == nil {
+		t.Error("expected error for value that is not an envelope")
+	}
+}
@@ -0,0 +1,517 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+)
+
+const (
+	// chunkedMarker is prepended to the associated data of data split into chunks by
+	// WithAutoChunking.
+	chunkedMarker = "\x00D1C"
+	// chunkedIDPrefix starts the object ID returned for data split into chunks, which is followed
+	// by the comma separated IDs of the manifest, if any, and of the chunks.
+	chunkedIDPrefix = "chunked:"
+
+	// defaultMaxRecvMessageSize is the default receive limit of gRPC.
+	defaultMaxRecvMessageSize = 4 << 20
+	// chunkOverhead is reserved in every chunk request for the other fields and the associated
+	// data added to the chunk, and in every response for the ciphertext expansion.
+	chunkOverhead = 1 << 10
+	// minChunkSize is the smallest chunk size automatic chunking is attempted with.
+	minChunkSize = 1 << 10
+
+	methodEncrypt  = "/d1.generic.Generic/Encrypt"
+	methodDecrypt  = "/d1.generic.Generic/Decrypt"
+	methodStore    = "/d1.storage.Storage/Store"
+	methodRetrieve = "/d1.storage.Storage/Retrieve"
+	methodDelete   = "/d1.storage.Storage/Delete"
+
+	methodAddPermission    = "/d1.authz.Authz/AddPermission"
+	methodRemovePermission = "/d1.authz.Authz/RemovePermission"
+	methodCheckPermission  = "/d1.authz.Authz/CheckPermission"
+	methodGetPermissions   = "/d1.authz.Authz/GetPermissions"
+)
+
+var (
+	// ErrPayloadTooLarge is returned when a request exceeds the maximum message size.
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrResponseTooLarge is returned when a response exceeds the maximum receive message size.
+	ErrResponseTooLarge = errors.New("response too large")
+)
+
+// MessageSizeOption is used to configure WithMaxMessageSize.
+type MessageSizeOption func(*messageSizeConfig)
+
+type messageSizeConfig struct {
+	send, recv   int
+	discovery    bool
+	autoChunking bool
+
+	mu      sync.Mutex
+	learned int
+}
+
+// WithServerLimitDiscovery returns a MessageSizeOption which learns the receive limit of the
+// server when it rejects a request as too large, and applies it to later requests.
+func WithServerLimitDiscovery() MessageSizeOption {
+	return func(c *messageSizeConfig) {
+		c.discovery = true
+	}
+}
+
+// WithAutoChunking returns a MessageSizeOption which splits Generic.Encrypt and Storage.Store
+// requests that are too large into chunks, instead of failing with ErrPayloadTooLarge. Combined
+// with WithServerLimitDiscovery, a request rejected by the server is retried in chunks.
+//
+// Each chunk is encrypted as a separate D1 object with the groups of the request. For Encrypt, the
+// chunks are returned as a single ciphertext in the stream format of EncryptStream. For Store, a
+// manifest listing the chunks is stored as well. The returned object ID lists every object of the
+// chunked data, and AddPermission, RemovePermission, CheckPermission, GetPermissions and
+// Storage.Delete given such an ID are applied to all of them: CheckPermission reports access only
+// if every object is accessible, and GetPermissions returns the groups with access to every
+// object. A call that fails part way leaves the objects before it changed. Storage.Update is not
+// supported for chunked objects.
+//
+// The associated data of chunked data is marked, and Generic.Decrypt and Storage.Retrieve
+// reassemble it transparently. Clients without automatic chunking cannot decrypt it. When used
+// with WithCompression, the compressed data is chunked.
+func WithAutoChunking() MessageSizeOption {
+	return func(c *messageSizeConfig) {
+		c.autoChunking = true
+	}
+}
+
+// WithMaxMessageSize returns an Option which sets the maximum size of the messages sent and
+// received by the client, and checks the size of requests before they are sent. Requests that are
+// too large fail with an error wrapping ErrPayloadTooLarge, as do requests rejected by the server
+// for their size. Responses larger than the receive limit fail with an error wrapping
+// ErrResponseTooLarge. A limit of 0 keeps the gRPC default, which is unlimited for sending and 4 MiB for
+// receiving. When used with WithCompression, sizes are checked after compression, whichever option
+// comes first.
+func WithMaxMessageSize(send, recv int, opts ...MessageSizeOption) Option {
+	config := &messageSizeConfig{send: send, recv: recv}
+	if config.send <= 0 {
+		config.send = math.MaxInt32
+	}
+	if config.recv <= 0 {
+		config.recv = defaultMaxRecvMessageSize
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return func(bc *BaseClient) grpc.DialOption {
+		bc.state.messageSize = config
+		return bc.state.payloadInterceptor()
+	}
+}
+
+// intercept checks the size of a call, and splits or reassembles it when automatic chunking is
+// enabled.
+func (c *messageSizeConfig) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	opts = append(opts, grpc.MaxCallSendMsgSize(c.send), grpc.MaxCallRecvMsgSize(c.recv))
+	request, ok := req.(proto.Message)
+	response, ok2 := reply.(proto.Message)
+	if !ok || !ok2 {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	call := chunkedCall{config: c, method: method, cc: cc, invoker: invoker, opts: opts}
+	return call.invoke(ctx, request, response)
+}
+
+// MaxSendMessageSize returns the largest request the client will send, or 0 if the client was not
+// configured with WithMaxMessageSize. With WithServerLimitDiscovery, the limit is lowered once the
+// server has rejected a request.
+func (b *BaseClient) MaxSendMessageSize() int {
+	if b.state == nil || b.state.messageSize == nil {
+		return 0
+	}
+	return b.state.messageSize.sendLimit()
+}
+
+func (c *messageSizeConfig) sendLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learned > 0 && c.learned < c.send {
+		return c.learned
+	}
+	return c.send
+}
+
+func (c *messageSizeConfig) learn(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learned == 0 || limit < c.learned {
+		c.learned = limit
+	}
+}
+
+// chunkSize returns the plaintext size of each chunk of the request.
+func (c *messageSizeConfig) chunkSize(req proto.Message, plaintextSize int) (int, error) {
+	limit := c.sendLimit()
+	if c.recv < limit {
+		limit = c.recv
+	}
+	size := limit - (proto.Size(req) - plaintextSize) - chunkOverhead
+	if size > MaxStreamChunkSize {
+		size = MaxStreamChunkSize
+	}
+	if size < minChunkSize {
+		return 0, fmt.Errorf("%w: limit of %d bytes is too small for chunking", ErrPayloadTooLarge, limit)
+	}
+	return size, nil
+}
+
+// chunkedCall is a call made through the WithMaxMessageSize interceptor.
+type chunkedCall struct {
+	config  *messageSizeConfig
+	method  string
+	cc      *grpc.ClientConn
+	invoker grpc.UnaryInvoker
+	opts    []grpc.CallOption
+}
+
+func (c *chunkedCall) invoke(ctx context.Context, req, reply proto.Message) error {
+	if objectIDs, ok := splitChunkedID(stringField(req, "object_id")); ok && c.config.autoChunking {
+		switch c.method {
+		case methodDecrypt:
+			// The chunks are listed in the ciphertext.
+		case methodRetrieve:
+			req = withFields(req, map[protoreflect.Name]protoreflect.Value{
+				"object_id": protoreflect.ValueOfString(objectIDs[0]),
+			})
+		case methodAddPermission, methodRemovePermission, methodCheckPermission, methodGetPermissions, methodDelete:
+			return c.fanOut(ctx, req, reply, objectIDs)
+		default:
+			return fmt.Errorf("%s is not supported for chunked objects", c.method)
+		}
+	}
+
+	// A chunked ciphertext is decrypted one chunk at a time, so its total size does not matter.
+	if c.config.autoChunking && c.method == methodDecrypt && isChunked(bytesField(req, "associated_data")) {
+		return c.decrypt(ctx, req, reply)
+	}
+	chunkable := c.config.autoChunking && (c.method == methodEncrypt || c.method == methodStore)
+	if size, limit := proto.Size(req), c.config.sendLimit(); size > limit {
+		if chunkable {
+			return c.encrypt(ctx, req, reply)
+		}
+		return fmt.Errorf("%w: request of %d bytes exceeds the limit of %d bytes", ErrPayloadTooLarge, size, limit)
+	}
+
+	err := c.invoker(ctx, c.method, req, reply, c.cc, c.opts...)
+	if size, limit, ok := parseSizeError(err); ok {
+		// The reported size is that of the request if the server rejected it, and that of the
+		// response otherwise.
+		if size != proto.Size(req) {
+			return fmt.Errorf("%w: %v", ErrResponseTooLarge, err)
+		}
+		if c.config.discovery {
+			c.config.learn(limit)
+			if chunkable {
+				return c.encrypt(ctx, req, reply)
+			}
+		}
+		return fmt.Errorf("%w: %v", ErrPayloadTooLarge, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.config.autoChunking && c.method == methodRetrieve && isChunked(bytesField(reply, "associated_data")) {
+		return c.retrieve(ctx, req, reply)
+	}
+	return nil
+}
+
+// encrypt splits an Encrypt or Store request into chunks. The associated data of each chunk is
+// that of a stream chunk followed by the associated data of the request.
+func (c *chunkedCall) encrypt(ctx context.Context, req, reply proto.Message) error {
+	plaintext := bytesField(req, "plaintext")
+	associatedData := bytesField(req, "associated_data")
+	chunkSize, err := c.config.chunkSize(req, len(plaintext))
+	if err != nil {
+		return err
+	}
+	header, err := newStreamHeader(chunkSize)
+	if err != nil {
+		return err
+	}
+	var container bytes.Buffer
+	if err := header.write(&container); err != nil {
+		return err
+	}
+
+	var objectIDs []string
+	for index := uint64(0); ; index++ {
+		n := len(plaintext)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		final := n == len(plaintext)
+
+		chunkReq := withFields(req, map[protoreflect.Name]protoreflect.Value{
+			"plaintext":       protoreflect.ValueOfBytes(plaintext[:n]),
+			"associated_data": protoreflect.ValueOfBytes(append(chunkAssociatedData(header.streamID, index, final), associatedData...)),
+		})
+		chunkRes := reply.ProtoReflect().New().Interface()
+		if err := c.invoker(ctx, c.method, chunkReq, chunkRes, c.cc, c.opts...); err != nil {
+			return fmt.Errorf("encrypting chunk %d: %w", index, err)
+		}
+		plaintext = plaintext[n:]
+
+		chunk := streamChunk{final: final, objectID: stringField(chunkRes, "object_id")}
+		if c.method == methodEncrypt {
+			chunk.ciphertext = bytesField(chunkRes, "ciphertext")
+		}
+		if err := chunk.write(&container); err != nil {
+			return err
+		}
+		objectIDs = append(objectIDs, chunk.objectID)
+		if final {
+			break
+		}
+	}
+
+	marked := append([]byte(chunkedMarker), associatedData...)
+	if c.method == methodStore {
+		manifest := withFields(req, map[protoreflect.Name]protoreflect.Value{
+			"plaintext":       protoreflect.ValueOfBytes(container.Bytes()),
+			"associated_data": protoreflect.ValueOfBytes(marked),
+		})
+		if err := c.invoker(ctx, c.method, manifest, reply, c.cc, c.opts...); err != nil {
+			return err
+		}
+		setFields(reply, map[protoreflect.Name]protoreflect.Value{
+			"object_id": protoreflect.ValueOfString(chunkedObjectID(append([]string{stringField(reply, "object_id")}, objectIDs...))),
+		})
+		return nil
+	}
+
+	proto.Reset(reply)
+	setFields(reply, map[protoreflect.Name]protoreflect.Value{
+		"object_id":       protoreflect.ValueOfString(chunkedObjectID(objectIDs)),
+		"ciphertext":      protoreflect.ValueOfBytes(container.Bytes()),
+		"associated_data": protoreflect.ValueOfBytes(marked),
+	})
+	return nil
+}
+
+// decrypt decrypts the chunks of a ciphertext returned by a chunked Encrypt.
+func (c *chunkedCall) decrypt(ctx context.Context, req, reply proto.Message) error {
+	associatedData := bytesField(req, "associated_data")[len(chunkedMarker):]
+	r := bytes.NewReader(bytesField(req, "ciphertext"))
+	header, err := readStreamHeader(r)
+	if err != nil {
+		return err
+	}
+
+	var plaintext []byte
+	for index := uint64(0); ; index++ {
+		chunk, err := readStreamChunk(r, header.chunkSize)
+		if err != nil {
+			return err
+		}
+		chunkReq := withFields(req, map[protoreflect.Name]protoreflect.Value{
+			"object_id":       protoreflect.ValueOfString(chunk.objectID),
+			"ciphertext":      protoreflect.ValueOfBytes(chunk.ciphertext),
+			"associated_data": protoreflect.ValueOfBytes(append(chunkAssociatedData(header.streamID, index, chunk.final), associatedData...)),
+		})
+		chunkRes := reply.ProtoReflect().New().Interface()
+		if err := c.invoker(ctx, c.method, chunkReq, chunkRes, c.cc, c.opts...); err != nil {
+			return fmt.Errorf("decrypting chunk %d: %w", index, err)
+		}
+		plaintext = append(plaintext, bytesField(chunkRes, "plaintext")...)
+
+		if chunk.final {
+			if err := checkStreamEnd(r); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	proto.Reset(reply)
+	setFields(reply, map[protoreflect.Name]protoreflect.Value{
+		"plaintext":       protoreflect.ValueOfBytes(plaintext),
+		"associated_data": protoreflect.ValueOfBytes(associatedData),
+	})
+	return nil
+}
+
+// retrieve retrieves the chunks listed in the manifest held by reply, and replaces the manifest
+// with the reassembled plaintext. The associated data of each chunk is checked, so chunks cannot be
+// swapped between manifests.
+func (c *chunkedCall) retrieve(ctx context.Context, req, reply proto.Message) error {
+	associatedData := bytesField(reply, "associated_data")[len(chunkedMarker):]
+	r := bytes.NewReader(bytesField(reply, "plaintext"))
+	header, err := readStreamHeader(r)
+	if err != nil {
+		return err
+	}
+
+	var plaintext []byte
+	for index := uint64(0); ; index++ {
+		chunk, err := readStreamChunk(r, header.chunkSize)
+		if err != nil {
+			return err
+		}
+		chunkReq := withFields(req, map[protoreflect.Name]protoreflect.Value{
+			"object_id": protoreflect.ValueOfString(chunk.objectID),
+		})
+		chunkRes := reply.ProtoReflect().New().Interface()
+		if err := c.invoker(ctx, c.method, chunkReq, chunkRes, c.cc, c.opts...); err != nil {
+			return fmt.Errorf("retrieving chunk %d: %w", index, err)
+		}
+		expected := append(chunkAssociatedData(header.streamID, index, chunk.final), associatedData...)
+		if !bytes.Equal(bytesField(chunkRes, "associated_data"), expected) {
+			return fmt.Errorf("%w: chunk %d does not belong to the stream", ErrInvalidStream, index)
+		}
+		plaintext = append(plaintext, bytesField(chunkRes, "plaintext")...)
+
+		if chunk.final {
+			if err := checkStreamEnd(r); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	setFields(reply, map[protoreflect.Name]protoreflect.Value{
+		"plaintext":       protoreflect.ValueOfBytes(plaintext),
+		"associated_data": protoreflect.ValueOfBytes(associatedData),
+	})
+	return nil
+}
+
+// fanOut makes an Authz call or a Storage.Delete call for every object of a chunked object, and
+// combines the responses.
+func (c *chunkedCall) fanOut(ctx context.Context, req, reply proto.Message, objectIDs []string) error {
+	hasPermission := true
+	var groupIDs []string
+	for i, objectID := range objectIDs {
+		objectReq := withFields(req, map[protoreflect.Name]protoreflect.Value{
+			"object_id": protoreflect.ValueOfString(objectID),
+		})
+		objectRes := reply.ProtoReflect().New().Interface()
+		if err := c.invoker(ctx, c.method, objectReq, objectRes, c.cc, c.opts...); err != nil {
+			return fmt.Errorf("object %d of chunked object: %w", i, err)
+		}
+		switch res := objectRes.(type) {
+		case *pbauthz.CheckPermissionResponse:
+			hasPermission = hasPermission && res.HasPermission
+		case *pbauthz.GetPermissionsResponse:
+			if i == 0 {
+				groupIDs = res.GroupIds
+			} else {
+				groupIDs = intersectStrings(groupIDs, res.GroupIds)
+			}
+		}
+	}
+
+	switch res := reply.(type) {
+	case *pbauthz.CheckPermissionResponse:
+		res.HasPermission = hasPermission
+	case *pbauthz.GetPermissionsResponse:
+		res.GroupIds = groupIDs
+	}
+	return nil
+}
+
+func isChunked(associatedData []byte) bool {
+	return bytes.HasPrefix(associatedData, []byte(chunkedMarker))
+}
+
+// chunkedObjectID returns the object ID of chunked data made up of the given objects.
+func chunkedObjectID(objectIDs []string) string {
+	return chunkedIDPrefix + strings.Join(objectIDs, ",")
+}
+
+// splitChunkedID returns the objects listed in a chunked object ID.
+func splitChunkedID(objectID string) ([]string, bool) {
+	if !strings.HasPrefix(objectID, chunkedIDPrefix) {
+		return nil, false
+	}
+	return strings.Split(strings.TrimPrefix(objectID, chunkedIDPrefix), ","), true
+}
+
+// parseSizeError extracts the message size and the limit from the error returned by gRPC when a
+// message is larger than allowed, e.g. "grpc: received message larger than max (10 vs. 4)".
+func parseSizeError(err error) (size, limit int, ok bool) {
+	s, isStatus := status.FromError(err)
+	if err == nil || !isStatus || s.Code() != codes.ResourceExhausted {
+		return 0, 0, false
+	}
+	msg := s.Message()
+	i := strings.Index(msg, "larger than max (")
+	if i < 0 {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(msg[i+len("larger than max ("):], "%d vs. %d)", &size, &limit); err != nil {
+		return 0, 0, false
+	}
+	return size, limit, true
+}
+
+// withFields returns a shallow copy of m with the given fields replaced.
+func withFields(m proto.Message, fields map[protoreflect.Name]protoreflect.Value) proto.Message {
+	src := m.ProtoReflect()
+	dst := src.New()
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		dst.Set(fd, v)
+		return true
+	})
+	setFields(dst.Interface(), fields)
+	return dst.Interface()
+}
+
+func setFields(m proto.Message, fields map[protoreflect.Name]protoreflect.Value) {
+	r := m.ProtoReflect()
+	for name, v := range fields {
+		r.Set(r.Descriptor().Fields().ByName(name), v)
+	}
+}
+
+func bytesField(m proto.Message, name protoreflect.Name) []byte {
+	r := m.ProtoReflect()
+	if fd := r.Descriptor().Fields().ByName(name); fd != nil && fd.Kind() == protoreflect.BytesKind {
+		return r.Get(fd).Bytes()
+	}
+	return nil
+}
+
+func stringField(m proto.Message, name protoreflect.Name) string {
+	r := m.ProtoReflect()
+	if fd := r.Descriptor().Fields().ByName(name); fd != nil && fd.Kind() == protoreflect.StringKind {
+		return r.Get(fd).String()
+	}
+	return ""
+}
@@ -0,0 +1,267 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	sclient "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+const serverLimit = 64 << 10
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMaxSendMessageSize(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(1000, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.MaxSendMessageSize() != 1000 {
+		t.Fatalf("unexpected limit %d", c.MaxSendMessageSize())
+	}
+
+	unlimited, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlimited.Close()
+	if unlimited.MaxSendMessageSize() != 0 {
+		t.Fatalf("unexpected limit %d", unlimited.MaxSendMessageSize())
+	}
+	var zero client.BaseClient
+	if zero.MaxSendMessageSize() != 0 {
+		t.Fatal("expected no limit for a zero client")
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	server := d1test.NewServer(t, grpc.MaxRecvMsgSize(serverLimit))
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	ctx := context.Background()
+
+	limited, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(1000, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+	if limited.MaxSendMessageSize() != 1000 {
+		t.Fatalf("unexpected limit %d", limited.MaxSendMessageSize())
+	}
+	_, err = limited.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: make([]byte, 2000)})
+	if encrypts, _ := server.GenericCalls(); !errors.Is(err, client.ErrPayloadTooLarge) || encrypts != 0 {
+		t.Fatalf("expected ErrPayloadTooLarge before sending, got %v after %d calls", err, encrypts)
+	}
+
+	// The server's own limit is reported as ErrPayloadTooLarge as well.
+	unlimited, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(0, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlimited.Close()
+	_, err = unlimited.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: make([]byte, 2*serverLimit)})
+	if !errors.Is(err, client.ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+
+	// Responses over the receive limit have their own error.
+	encrypted, err := unlimited.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: make([]byte, 2000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRecv, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(0, 1000))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smallRecv.Close()
+	_, err = smallRecv.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext})
+	if !errors.Is(err, client.ErrResponseTooLarge) || errors.Is(err, client.ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestMaxMessageSizeWithCompression(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	ctx := context.Background()
+	document := bytes.Repeat([]byte("highly compressible document "), 1000)
+
+	// Sizes are checked after compression, whichever option comes first.
+	orders := [][]client.Option{
+		{client.WithCompression(), client.WithMaxMessageSize(1000, 0)},
+		{client.WithMaxMessageSize(1000, 0), client.WithCompression()},
+	}
+	for i, order := range orders {
+		c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), order...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: document})
+		if err != nil {
+			t.Fatalf("order %d: %v", i, err)
+		}
+		decrypted, err := c.Generic.Decrypt(ctx, &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext, AssociatedData: encrypted.AssociatedData})
+		if err != nil || !bytes.Equal(decrypted.Plaintext, document) {
+			t.Fatalf("order %d: unexpected decryption: %v", i, err)
+		}
+		if _, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: randomBytes(t, 2000)}); !errors.Is(err, client.ErrPayloadTooLarge) {
+			t.Fatalf("order %d: expected ErrPayloadTooLarge, got %v", i, err)
+		}
+	}
+}
+
+func TestAutoChunking(t *testing.T) {
+	server := d1test.NewServer(t, grpc.MaxRecvMsgSize(serverLimit))
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	opts := append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(0, 0, client.WithServerLimitDiscovery(), client.WithAutoChunking()))
+	c, err := client.NewGenericClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	// The first request is rejected by the server, which teaches the client the limit, and is
+	// retried in chunks.
+	plaintext := randomBytes(t, 3*serverLimit)
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: plaintext, AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxSendMessageSize() != serverLimit {
+		t.Fatalf("server limit was not learned: %d", c.MaxSendMessageSize())
+	}
+	if encrypts, _ := server.GenericCalls(); encrypts < 4 {
+		t.Fatalf("expected the plaintext to be chunked, got %d Encrypt calls", encrypts)
+	}
+
+	request := &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext, AssociatedData: encrypted.AssociatedData}
+	decrypted, err := c.Generic.Decrypt(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Plaintext, plaintext) || string(decrypted.AssociatedData) != "context" {
+		t.Fatalf("unexpected decryption: %d bytes, associated data %q", len(decrypted.Plaintext), decrypted.AssociatedData)
+	}
+
+	// The associated data is bound to every chunk.
+	request.AssociatedData = append(append([]byte{}, encrypted.AssociatedData...), '!')
+	if _, err := c.Generic.Decrypt(ctx, request); err == nil {
+		t.Fatal("expected decryption with modified associated data to fail")
+	}
+
+	// Permission changes apply to every chunk.
+	other, otherPwd := server.NewUser(d1test.AllScopes()...)
+	otherClient, err := client.NewGenericClient("bufnet", append(server.ClientOptions(other, otherPwd), client.WithMaxMessageSize(0, 0, client.WithAutoChunking()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherClient.Close()
+	request.AssociatedData = encrypted.AssociatedData
+	if _, err := otherClient.Generic.Decrypt(ctx, request); err == nil {
+		t.Fatal("expected decryption without access to fail")
+	}
+	if _, err := c.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: encrypted.ObjectId, GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherClient.Generic.Decrypt(ctx, request); err != nil {
+		t.Fatalf("decryption after granting access failed: %v", err)
+	}
+	check, err := otherClient.Authz.CheckPermission(ctx, &pbauthz.CheckPermissionRequest{ObjectId: encrypted.ObjectId})
+	if err != nil || !check.HasPermission {
+		t.Fatalf("unexpected permission check: %v, %v", err, check)
+	}
+	permissions, err := c.Authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: encrypted.ObjectId})
+	if err != nil || len(permissions.GroupIds) != 2 {
+		t.Fatalf("unexpected permissions: %v, %v", err, permissions)
+	}
+	if _, err := c.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: encrypted.ObjectId, GroupIds: []string{other}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherClient.Generic.Decrypt(ctx, request); err == nil {
+		t.Fatal("expected decryption after revoking access to fail")
+	}
+
+	// Requests within the limit are not chunked.
+	small, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("small"), AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(small.AssociatedData) != "context" {
+		t.Fatalf("small plaintext was chunked: %q", small.AssociatedData)
+	}
+}
+
+func TestStorageAutoChunking(t *testing.T) {
+	server := d1test.NewServer(t, grpc.MaxRecvMsgSize(serverLimit))
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	opts := append(server.ClientOptions(uid, pwd), client.WithMaxMessageSize(serverLimit, 0, client.WithAutoChunking()))
+	c, err := sclient.NewStorageClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	plaintext := randomBytes(t, 3*serverLimit)
+	stored, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: plaintext, AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.StoredObjects(); n != 5 {
+		t.Fatalf("expected 4 chunks and a manifest, got %d objects", n)
+	}
+
+	retrieved, err := c.Storage.Retrieve(ctx, &pbstorage.RetrieveRequest{ObjectId: stored.ObjectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(retrieved.Plaintext, plaintext) || string(retrieved.AssociatedData) != "context" {
+		t.Fatalf("unexpected retrieval: %d bytes, associated data %q", len(retrieved.Plaintext), retrieved.AssociatedData)
+	}
+
+	// The manifest and the chunks are deleted together, and cannot be updated.
+	if _, err := c.Storage.Update(ctx, &pbstorage.UpdateRequest{ObjectId: stored.ObjectId, Plaintext: []byte("new")}); err == nil {
+		t.Fatal("expected updating a chunked object to fail")
+	}
+	if _, err := c.Storage.Delete(ctx, &pbstorage.DeleteRequest{ObjectId: stored.ObjectId}); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.StoredObjects(); n != 0 {
+		t.Fatalf("%d objects are left after deleting", n)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/policy"
+)
+
+// WithPolicy returns an Option which evaluates a client-side policy for Decrypt, Retrieve and
+// AddPermission calls. Decrypt and AddPermission are evaluated before the request is sent. Retrieve
+// is evaluated when the response arrives, since the associated data is only known then, and the
+// response is cleared if the call is denied. Denied calls fail with a *policy.DenyError.
+//
+// The policy input is built from the request, the claims of the access token used for the call and
+// the tags in the associated data, as returned by policy.TagsFromAssociatedData. If the policy uses
+// the caller's identity and the claims cannot be read, calls fail with an error wrapping
+// policy.ErrNoIdentity.
+func WithPolicy(p policy.Policy) Option {
+	return func(bc *BaseClient) grpc.DialOption {
+		return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			switch method {
+			case "/d1.generic.Generic/Decrypt", "/d1.authz.Authz/AddPermission":
+				if err := bc.enforcePolicy(ctx, p, method, req, req); err != nil {
+					return err
+				}
+			case "/d1.storage.Storage/Retrieve":
+				if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+					return err
+				}
+				if err := bc.enforcePolicy(ctx, p, method, req, reply); err != nil {
+					if m, ok := reply.(proto.Message); ok {
+						proto.Reset(m)
+					}
+					return err
+				}
+				return nil
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+// callerClaims returns the claims of the access token used for calls made with the given context.
+func (b *BaseClient) callerClaims(ctx context.Context) (*TokenClaims, error) {
+	token, err := b.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTokenClaims(token)
+}
+
+// enforcePolicy evaluates the policy for a call. The object ID and groups are read from req and the
+// associated data from data, which is either the request or the response.
+func (b *BaseClient) enforcePolicy(ctx context.Context, p policy.Policy, method string, req, data interface{}) error {
+	in := policy.Input{
+		Method: method[strings.LastIndex(method, "/")+1:],
+		Time:   time.Now().UTC(),
+	}
+	if r, ok := req.(interface{ GetObjectId() string }); ok {
+		in.ObjectID = r.GetObjectId()
+	}
+	if r, ok := req.(interface{ GetGroupIds() []string }); ok {
+		in.GroupIDs = r.GetGroupIds()
+	}
+	if d, ok := data.(interface{ GetAssociatedData() []byte }); ok {
+		in.Tags = policy.TagsFromAssociatedData(d.GetAssociatedData())
+	}
+	if policy.UsesIdentity(p) {
+		claims, err := b.callerClaims(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w: %v", in.Method, policy.ErrNoIdentity, err)
+		}
+		in.Subject = claims.Subject
+		in.Scopes = claims.Scopes
+		in.Claims = claims.Raw
+	}
+
+	decision, err := p.Evaluate(ctx, in)
+	if err != nil {
+		return err
+	}
+	if !decision.Allow {
+		return &policy.DenyError{Method: in.Method, ObjectID: in.ObjectID, Rule: decision.Rule}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package policy implements client-side policies that are evaluated in addition to the access checks
+done by D1. A Policy decides whether a call may proceed based on the request, the claims of the
+caller's access token, tags found in the associated data and the current time.
+
+Policies are installed on a client with client.WithPolicy. Any type implementing Policy can be used;
+the package also provides a small rule language:
+
+	# Analysts may only see data not tagged as PII.
+	deny "analyst-pii" when claims.role == "analyst" and tags.pii != "false"
+	# Decryption is only allowed during business hours, in UTC.
+	deny "after-hours" when method == "Decrypt" and (time.hour < 9 or time.hour >= 17)
+	allow when "READ" in scopes
+	default deny
+
+Rules are evaluated in order and the first rule whose condition holds decides. If no rule matches,
+the default applies, which is allow unless a "default deny" line is present. A rule may be given a
+name as a quoted string; it is reported in the DenyError. Lines starting with # are comments.
+
+Conditions combine comparisons (==, !=, <, <=, >, >=, in) with and, or, not and parentheses.
+Operands are string literals, numbers, true, false, lists such as ["a", "b"], and the attributes:
+
+	method          the name of the called method, e.g. Decrypt, Retrieve or AddPermission
+	object_id       the ID of the object
+	group_ids       the groups in an AddPermission request
+	user            the subject of the access token
+	scopes          the scopes of the access token
+	claims.<name>   any claim of the access token
+	tags.<name>     a tag of the associated data
+	time.hour       the current hour in UTC, 0-23
+	time.minute     the current minute in UTC, 0-59
+	time.weekday    the current day of the week in UTC, e.g. "Monday"
+
+Attributes that are not present evaluate to null, which is only equal to itself. When a string is
+compared to a number, the string is converted to a number if possible. Token claims are not
+verified, so policies can restrict what a client does but cannot grant access beyond what D1
+allows. If a rule refers to user, scopes or a claim and the access token cannot be read, calls fail
+with ErrNoIdentity instead of being evaluated without the caller's identity.
+*/
+package policy
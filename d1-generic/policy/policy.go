@@ -0,0 +1,131 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aad"
+)
+
+// ErrNoIdentity is returned for calls that a policy using the caller's identity cannot be evaluated
+// for, because no claims could be read from the access token.
+var ErrNoIdentity = errors.New("caller identity is not available to the policy")
+
+// Input contains the attributes a policy decision is based on.
+type Input struct {
+	// Method is the short name of the called method, e.g. "Decrypt".
+	Method   string
+	ObjectID string
+	GroupIDs []string
+
+	// Subject, Scopes and Claims are taken from the caller's access token, if available.
+	Subject string
+	Scopes  []string
+	Claims  map[string]interface{}
+
+	// Tags are the tags found in the associated data of the object.
+	Tags map[string]string
+
+	// Time is the time of the call in UTC.
+	Time time.Time
+}
+
+// Decision is the outcome of evaluating a policy.
+type Decision struct {
+	Allow bool
+	// Rule identifies the rule that made the decision, if any.
+	Rule string
+}
+
+// Policy decides whether a call may proceed.
+type Policy interface {
+	Evaluate(ctx context.Context, in Input) (Decision, error)
+}
+
+// IdentityPolicy is implemented by policies that can tell whether they use the caller's identity.
+// Policies that do not implement it are assumed to use it, so calls fail with ErrNoIdentity when
+// the identity is not available.
+type IdentityPolicy interface {
+	Policy
+	// UsesIdentity reports whether the policy uses the Subject, Scopes or Claims of the input.
+	UsesIdentity() bool
+}
+
+// UsesIdentity reports whether p uses the caller's identity.
+func UsesIdentity(p Policy) bool {
+	if ip, ok := p.(IdentityPolicy); ok {
+		return ip.UsesIdentity()
+	}
+	return true
+}
+
+// Func adapts a function to the Policy interface.
+type Func func(ctx context.Context, in Input) (Decision, error)
+
+// Evaluate implements Policy.
+func (f Func) Evaluate(ctx context.Context, in Input) (Decision, error) {
+	return f(ctx, in)
+}
+
+// DenyError is returned for calls denied by a policy.
+type DenyError struct {
+	Method   string
+	ObjectID string
+	Rule     string
+}
+
+func (e *DenyError) Error() string {
+	msg := fmt.Sprintf("%s denied by policy", e.Method)
+	if e.ObjectID != "" {
+		msg = fmt.Sprintf("%s of %s denied by policy", e.Method, e.ObjectID)
+	}
+	if e.Rule != "" {
+		msg += " rule " + e.Rule
+	}
+	return msg
+}
+
+// TagsFromAssociatedData extracts tags from associated data built with package aad, or holding a
+// flat JSON object. For JSON, numbers and booleans are converted to strings and nested values are
+// ignored. Associated data in any other format has no tags.
+func TagsFromAssociatedData(data []byte) map[string]string {
+	if c, err := aad.Parse(data); err == nil {
+		return c
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	tags := make(map[string]string, len(fields))
+	for k, v := range fields {
+		switch v := v.(type) {
+		case string:
+			tags[k] = v
+		case float64:
+			tags[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			tags[k] = strconv.FormatBool(v)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,558 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rules is a Policy written in the rule language described in the package documentation.
+type Rules struct {
+	rules        []rule
+	defaultAllow bool
+	identity     bool
+}
+
+type rule struct {
+	name  string
+	allow bool
+	cond  expr
+}
+
+// ParseRules parses a rule set.
+func ParseRules(src string) (*Rules, error) {
+	rs := &Rules{defaultAllow: true}
+	hasDefault := false
+
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tokens, err := tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		p := &parser{tokens: tokens}
+
+		switch effect := p.next(); {
+		case effect.is(tokIdent, "default"):
+			if hasDefault {
+				return nil, fmt.Errorf("line %d: duplicate default", lineNo)
+			}
+			hasDefault = true
+			switch t := p.next(); {
+			case t.is(tokIdent, "allow"):
+				rs.defaultAllow = true
+			case t.is(tokIdent, "deny"):
+				rs.defaultAllow = false
+			default:
+				return nil, fmt.Errorf("line %d: expected allow or deny after default", lineNo)
+			}
+		case effect.is(tokIdent, "allow"), effect.is(tokIdent, "deny"):
+			r := rule{
+				name:  fmt.Sprintf("line %d", lineNo),
+				allow: effect.text == "allow",
+			}
+			if p.peek().kind == tokString {
+				r.name = p.next().text
+			}
+			if !p.next().is(tokIdent, "when") {
+				return nil, fmt.Errorf("line %d: expected when", lineNo)
+			}
+			r.cond, err = p.parseOr()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			rs.rules = append(rs.rules, r)
+			rs.identity = rs.identity || p.identity
+		default:
+			return nil, fmt.Errorf("line %d: expected allow, deny or default", lineNo)
+		}
+
+		if t := p.next(); t.kind != tokEOF {
+			return nil, fmt.Errorf("line %d: unexpected %q", lineNo, t.text)
+		}
+	}
+	return rs, nil
+}
+
+// UsesIdentity implements IdentityPolicy. It reports whether any rule refers to user, scopes or a
+// claim.
+func (rs *Rules) UsesIdentity() bool {
+	return rs.identity
+}
+
+// Evaluate implements Policy.
+func (rs *Rules) Evaluate(_ context.Context, in Input) (Decision, error) {
+	for _, r := range rs.rules {
+		if truthy(r.cond.eval(in)) {
+			return Decision{Allow: r.allow, Rule: r.name}, nil
+		}
+	}
+	return Decision{Allow: rs.defaultAllow}, nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func tokenize(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := rune(line[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			s, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", line[i:end+1])
+			}
+			tokens = append(tokens, token{tokString, s})
+			i = end + 1
+		case unicode.IsDigit(c) || c == '-' && i+1 < len(line) && unicode.IsDigit(rune(line[i+1])):
+			end := i + 1
+			for end < len(line) && (unicode.IsDigit(rune(line[end])) || line[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{tokNumber, line[i:end]})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(line) && (unicode.IsLetter(rune(line[end])) || unicode.IsDigit(rune(line[end])) || line[end] == '_' || line[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{tokIdent, line[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "<", ">", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(line[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	// identity is set when an attribute of the caller's identity is parsed.
+	identity bool
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokIdent, "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokIdent, "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.peek().is(tokIdent, "not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if !isComparison(t) {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareExpr{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return literal{t.text}, nil
+	case t.kind == tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.text)
+		}
+		return literal{f}, nil
+	case t.is(tokIdent, "true"), t.is(tokIdent, "false"):
+		return literal{t.text == "true"}, nil
+	case t.is(tokIdent, "null"):
+		return literal{nil}, nil
+	case t.kind == tokIdent && !isKeyword(t.text):
+		if !isAttribute(t.text) {
+			return nil, fmt.Errorf("unknown attribute %s", t.text)
+		}
+		p.identity = p.identity || isIdentityAttribute(t.text)
+		return attribute(t.text), nil
+	case t.is(tokOp, "("):
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.next().is(tokOp, ")") {
+			return nil, fmt.Errorf("expected )")
+		}
+		return e, nil
+	case t.is(tokOp, "["):
+		var items listExpr
+		for !p.peek().is(tokOp, "]") {
+			if len(items) > 0 && !p.next().is(tokOp, ",") {
+				return nil, fmt.Errorf("expected , or ]")
+			}
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		p.next()
+		return items, nil
+	case t.kind == tokEOF:
+		return nil, fmt.Errorf("unexpected end of rule")
+	default:
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+}
+
+func isComparison(t token) bool {
+	if t.kind == tokOp {
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return true
+		}
+	}
+	return t.is(tokIdent, "in")
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "and", "or", "not", "in", "when", "allow", "deny", "default":
+		return true
+	}
+	return false
+}
+
+func isAttribute(name string) bool {
+	switch name {
+	case "method", "object_id", "group_ids", "user", "scopes", "time.hour", "time.minute", "time.weekday":
+		return true
+	}
+	return strings.HasPrefix(name, "claims.") && len(name) > len("claims.") ||
+		strings.HasPrefix(name, "tags.") && len(name) > len("tags.")
+}
+
+func isIdentityAttribute(name string) bool {
+	return name == "user" || name == "scopes" || strings.HasPrefix(name, "claims.")
+}
+
+// Evaluation. Values are nil, bool, float64, string or []interface{}.
+
+type expr interface {
+	eval(in Input) interface{}
+}
+
+type literal struct{ value interface{} }
+
+func (l literal) eval(Input) interface{} { return l.value }
+
+type listExpr []expr
+
+func (l listExpr) eval(in Input) interface{} {
+	values := make([]interface{}, len(l))
+	for i, item := range l {
+		values[i] = item.eval(in)
+	}
+	return values
+}
+
+type attribute string
+
+func (a attribute) eval(in Input) interface{} {
+	name := string(a)
+	switch name {
+	case "method":
+		return in.Method
+	case "object_id":
+		return in.ObjectID
+	case "group_ids":
+		return stringList(in.GroupIDs)
+	case "user":
+		return in.Subject
+	case "scopes":
+		return stringList(in.Scopes)
+	case "time.hour":
+		return float64(in.Time.Hour())
+	case "time.minute":
+		return float64(in.Time.Minute())
+	case "time.weekday":
+		return in.Time.Weekday().String()
+	}
+	if key := strings.TrimPrefix(name, "tags."); key != name {
+		if v, ok := in.Tags[key]; ok {
+			return v
+		}
+		return nil
+	}
+	return normalize(in.Claims[strings.TrimPrefix(name, "claims.")])
+}
+
+type andExpr struct{ left, right expr }
+
+func (e andExpr) eval(in Input) interface{} {
+	return truthy(e.left.eval(in)) && truthy(e.right.eval(in))
+}
+
+type orExpr struct{ left, right expr }
+
+func (e orExpr) eval(in Input) interface{} {
+	return truthy(e.left.eval(in)) || truthy(e.right.eval(in))
+}
+
+type notExpr struct{ operand expr }
+
+func (e notExpr) eval(in Input) interface{} {
+	return !truthy(e.operand.eval(in))
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e compareExpr) eval(in Input) interface{} {
+	left, right := e.left.eval(in), e.right.eval(in)
+	switch e.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		list, _ := right.([]interface{})
+		for _, item := range list {
+			if equal(left, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	c, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	if x, y, ok := numbers(a, b); ok {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compare(a, b interface{}) (int, bool) {
+	if x, y, ok := numbers(a, b); ok {
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, okA := a.(string)
+	y, okB := b.(string)
+	if !okA || !okB {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// numbers converts a and b to numbers if at least one of them is a number and the other is a
+// number or a numeric string.
+func numbers(a, b interface{}) (float64, float64, bool) {
+	_, aNum := a.(float64)
+	_, bNum := b.(float64)
+	if !aNum && !bNum {
+		return 0, 0, false
+	}
+	x, okA := toNumber(a)
+	y, okB := toNumber(b)
+	return x, y, okA && okB
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func stringList(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// normalize converts decoded JSON claim values to rule values.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	case bool, float64, string:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aad"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules(`
+# Analysts may only see data not tagged as PII.
+deny "analyst-pii" when claims.role == "analyst" and tags.pii != "false"
+deny "after-hours" when method == "Decrypt" and (time.hour < 9 or time.hour >= 17)
+deny when tags.level > 3 and not "ADMIN" in scopes
+allow when "READ" in scopes or user in ["alice", "bob"]
+default deny
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	morning := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	evening := time.Date(2022, 6, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		in    Input
+		allow bool
+		rule  string
+	}{
+		{
+			name:  "analyst without pii tag",
+			in:    Input{Method: "Decrypt", Claims: map[string]interface{}{"role": "analyst"}, Scopes: []string{"READ"}, Time: morning},
+			allow: false,
+			rule:  "analyst-pii",
+		},
+		{
+			name:  "analyst with pii false",
+			in:    Input{Method: "Decrypt", Claims: map[string]interface{}{"role": "analyst"}, Tags: map[string]string{"pii": "false"}, Scopes: []string{"READ"}, Time: morning},
+			allow: true,
+			rule:  "line 6",
+		},
+		{
+			name:  "after hours",
+			in:    Input{Method: "Decrypt", Scopes: []string{"READ"}, Time: evening},
+			allow: false,
+			rule:  "after-hours",
+		},
+		{
+			name:  "other method after hours",
+			in:    Input{Method: "AddPermission", Subject: "bob", Time: evening},
+			allow: true,
+			rule:  "line 6",
+		},
+		{
+			name:  "numeric tag",
+			in:    Input{Method: "Retrieve", Tags: map[string]string{"level": "4"}, Scopes: []string{"READ"}, Time: morning},
+			allow: false,
+			rule:  "line 5",
+		},
+		{
+			name:  "default",
+			in:    Input{Method: "Retrieve", Subject: "mallory", Time: morning},
+			allow: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := rules.Evaluate(context.Background(), tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Allow != tc.allow || d.Rule != tc.rule {
+				t.Fatalf("got %+v, want allow=%v rule=%q", d, tc.allow, tc.rule)
+			}
+		})
+	}
+}
+
+func TestUsesIdentity(t *testing.T) {
+	for src, want := range map[string]bool{
+		`deny when method == "Decrypt"`:                                 false,
+		`deny when tags.pii == "true" and time.hour < 9`:                false,
+		"deny when method == \"Decrypt\"\nallow when user == \"alice\"": true,
+		`deny when not "READ" in scopes`:                                true,
+		`allow when claims.role == "admin"`:                             true,
+		`default deny`:                                                  false,
+	} {
+		rules, err := ParseRules(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rules.UsesIdentity() != want || UsesIdentity(rules) != want {
+			t.Errorf("%q: expected UsesIdentity %v", src, want)
+		}
+	}
+
+	f := Func(func(context.Context, Input) (Decision, error) { return Decision{Allow: true}, nil })
+	if !UsesIdentity(f) {
+		t.Error("expected a Func to be assumed to use the identity")
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, src := range []string{
+		`permit when true`,
+		`allow true`,
+		`allow when method ==`,
+		`allow when (method == "Decrypt"`,
+		`allow when unknown == 1`,
+		`allow when method == "Decrypt`,
+		`allow when true extra`,
+		"default allow\ndefault deny",
+	} {
+		if _, err := ParseRules(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestTagsFromAssociatedData(t *testing.T) {
+	tags := TagsFromAssociatedData([]byte(`{"pii": false, "level": 3, "owner": "sales", "nested": {"a": 1}}`))
+	want := map[string]string{"pii": "false", "level": "3", "owner": "sales"}
+	if len(tags) != len(want) {
+		t.Fatalf("got %v, want %v", tags, want)
+	}
+	for k, v := range want {
+		if tags[k] != v {
+			t.Fatalf("got %v, want %v", tags, want)
+		}
+	}
+	data, err := aad.NewBuilder().Tenant("acme").Purpose("billing").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags = TagsFromAssociatedData(data)
+	if len(tags) != 2 || tags["tenant"] != "acme" || tags["purpose"] != "billing" {
+		t.Fatalf("unexpected tags from canonical associated data: %v", tags)
+	}
+	if tags := TagsFromAssociatedData([]byte("not json")); tags != nil {
+		t.Fatalf("expected no tags, got %v", tags)
+	}
+}
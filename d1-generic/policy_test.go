@@ -0,0 +1,140 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/policy"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	sclient "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestPolicy(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	other, _ := server.NewUser()
+
+	rules, err := policy.ParseRules(`
+deny "no-sharing" when method == "AddPermission" and "` + other + `" in group_ids
+deny "pii" when method == "Retrieve" and tags.pii == "true" and user == "` + uid + `"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := append(server.ClientOptions(uid, pwd), client.WithPolicy(rules))
+	c, err := sclient.NewStorageClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	var deny *policy.DenyError
+
+	oid := server.NewObject(uid)
+	_, err = c.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: oid, GroupIds: []string{other}})
+	if !errors.As(err, &deny) || deny.Rule != "no-sharing" || deny.ObjectID != oid {
+		t.Fatalf("expected AddPermission to be denied, got %v", err)
+	}
+	if groups := server.ObjectGroups(oid); len(groups) != 1 {
+		t.Fatalf("permission was added despite the policy: %v", groups)
+	}
+
+	stored, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: []byte("secret"), AssociatedData: []byte(`{"pii": true}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Storage.Retrieve(ctx, &pbstorage.RetrieveRequest{ObjectId: stored.ObjectId})
+	if !errors.As(err, &deny) || deny.Rule != "pii" || deny.Method != "Retrieve" {
+		t.Fatalf("expected Retrieve to be denied, got %v", err)
+	}
+	if res != nil {
+		t.Fatalf("expected no response, got %v", res)
+	}
+
+	stored, err = c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: []byte("public"), AssociatedData: []byte(`{"pii": false}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = c.Storage.Retrieve(ctx, &pbstorage.RetrieveRequest{ObjectId: stored.ObjectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Plaintext) != "public" {
+		t.Fatalf("unexpected plaintext %q", res.Plaintext)
+	}
+}
+
+func TestPolicyIdentity(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	ctx := context.Background()
+
+	denyMe, err := policy.ParseRules(`deny "me" when user == "` + uid + `"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyDecrypt, err := policy.ParseRules(`deny "decrypt" when method == "Decrypt"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewGenericClient("bufnet", append(server.ClientOptions(uid, pwd), client.WithPolicy(denyMe))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext}
+
+	var deny *policy.DenyError
+	if _, err := c.Generic.Decrypt(ctx, request); !errors.As(err, &deny) || deny.Rule != "me" {
+		t.Fatalf("expected Decrypt to be denied, got %v", err)
+	}
+
+	// Without an access token, a policy using the identity fails closed, and one that does not is
+	// still evaluated.
+	for _, tc := range []struct {
+		policy policy.Policy
+		check  func(error) bool
+	}{
+		{denyMe, func(err error) bool { return errors.Is(err, policy.ErrNoIdentity) }},
+		{denyDecrypt, func(err error) bool { return errors.As(err, &deny) && deny.Rule == "decrypt" }},
+	} {
+		opts := []client.Option{client.WithPolicy(tc.policy)}
+		for _, opt := range server.DialOptions() {
+			opts = append(opts, client.WithGrpcOption(opt))
+		}
+		anonymous, err := client.NewGenericClient("bufnet", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer anonymous.Close()
+		if _, err := anonymous.Generic.Decrypt(ctx, request); !tc.check(err) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+)
+
+// ProtoOption is used to select the fields handled by EncryptProto and DecryptProto.
+type ProtoOption func(*protoConfig)
+
+type protoConfig struct {
+	paths    []string
+	option   protoreflect.ExtensionType
+	groupIDs []string
+}
+
+// WithProtoFieldPaths returns a ProtoOption which selects fields by path. A path is a dot separated
+// list of field names, starting from the top-level message, such as "patient.ssn". Repeated and
+// map fields holding messages are traversed element by element, so "cards.number" selects the
+// number of every card.
+func WithProtoFieldPaths(paths ...string) ProtoOption {
+	return func(c *protoConfig) {
+		c.paths = append(c.paths, paths...)
+	}
+}
+
+// WithProtoFieldOption returns a ProtoOption which selects every field, at any depth, that has the
+// given boolean field option set to true, such as a custom option declared as
+//
+//	extend google.protobuf.FieldOptions { bool encrypt = 50000; }
+//
+// and used as `string ssn = 1 [(d1.encrypt) = true];`.
+func WithProtoFieldOption(option protoreflect.ExtensionType) ProtoOption {
+	return func(c *protoConfig) {
+		c.option = option
+	}
+}
+
+// WithProtoGroups returns a ProtoOption which gives the groups access to every encrypted field.
+func WithProtoGroups(groupIDs ...string) ProtoOption {
+	return func(c *protoConfig) {
+		c.groupIDs = groupIDs
+	}
+}
+
+// EncryptProto encrypts the selected fields of msg in place. Selected fields must be string or bytes
+// fields, singular or repeated, and are replaced by an envelope in text form for strings and binary
+// form for bytes. Empty fields are left empty. Each value is bound to the full name of its field, so
+// an envelope cannot be moved to another field.
+func (c *GenericClient) EncryptProto(ctx context.Context, msg proto.Message, opts ...ProtoOption) error {
+	config := protoConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return walkProto(msg.ProtoReflect(), &config, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error) {
+		var plaintext []byte
+		if fd.Kind() == protoreflect.StringKind {
+			plaintext = []byte(v.String())
+		} else {
+			plaintext = v.Bytes()
+		}
+
+		env, err := envelope.Encrypt(ctx, c.Generic, plaintext, protoAssociatedData(fd), envelope.WithGroups(config.groupIDs...))
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		if fd.Kind() == protoreflect.StringKind {
+			text, err := env.MarshalText()
+			return protoreflect.ValueOfString(string(text)), err
+		}
+		data, err := envelope.Marshal(env)
+		return protoreflect.ValueOfBytes(data), err
+	})
+}
+
+// DecryptProto decrypts the selected fields of msg in place. The fields must be selected in the
+// same way as when they were encrypted.
+func (c *GenericClient) DecryptProto(ctx context.Context, msg proto.Message, opts ...ProtoOption) error {
+	config := protoConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return walkProto(msg.ProtoReflect(), &config, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error) {
+		var env *envelope.Envelope
+		var err error
+		if fd.Kind() == protoreflect.StringKind {
+			env = &envelope.Envelope{}
+			err = env.UnmarshalText([]byte(v.String()))
+		} else {
+			env, err = envelope.Unmarshal(v.Bytes())
+		}
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+
+		env.AssociatedData = protoAssociatedData(fd)
+		plaintext, err := env.Decrypt(ctx, c.Generic)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		if fd.Kind() == protoreflect.StringKind {
+			return protoreflect.ValueOfString(string(plaintext)), nil
+		}
+		return protoreflect.ValueOfBytes(plaintext), nil
+	})
+}
+
+func protoAssociatedData(fd protoreflect.FieldDescriptor) []byte {
+	data, _ := json.Marshal(struct {
+		Field string `json:"field"`
+	}{string(fd.FullName())})
+	return data
+}
+
+type protoFieldFunc func(fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error)
+
+// walkProto applies fn to every non-empty value of the selected fields of m.
+func walkProto(m protoreflect.Message, config *protoConfig, fn protoFieldFunc) error {
+	if len(config.paths) == 0 && config.option == nil {
+		return errors.New("no fields selected")
+	}
+
+	var paths [][]string
+	for _, path := range config.paths {
+		segments := strings.Split(path, ".")
+		if err := checkProtoPath(m.Descriptor(), segments); err != nil {
+			return fmt.Errorf("path %q: %w", path, err)
+		}
+		paths = append(paths, segments)
+	}
+	return walkProtoMessage(m, paths, config.option, fn)
+}
+
+// checkProtoPath verifies that a path leads through message fields to a string or bytes field.
+func checkProtoPath(md protoreflect.MessageDescriptor, path []string) error {
+	fd := md.Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		return fmt.Errorf("%s has no field %s", md.FullName(), path[0])
+	}
+	if len(path) == 1 {
+		return checkProtoField(fd)
+	}
+
+	if fd.IsMap() {
+		fd = fd.MapValue()
+	}
+	if fd.Message() == nil {
+		return fmt.Errorf("field %s is not a message", fd.FullName())
+	}
+	return checkProtoPath(fd.Message(), path[1:])
+}
+
+func checkProtoField(fd protoreflect.FieldDescriptor) error {
+	if fd.IsMap() || (fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind) {
+		return fmt.Errorf("field %s must be a string or bytes field", fd.FullName())
+	}
+	return nil
+}
+
+func walkProtoMessage(m protoreflect.Message, paths [][]string, option protoreflect.ExtensionType, fn protoFieldFunc) error {
+	// Fields are collected first, since m must not be modified while ranging over it.
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+
+	for _, fd := range fields {
+		var subpaths [][]string
+		selected := hasBoolOption(fd, option)
+		for _, path := range paths {
+			if path[0] != string(fd.Name()) {
+				continue
+			}
+			if len(path) == 1 {
+				selected = true
+			} else {
+				subpaths = append(subpaths, path[1:])
+			}
+		}
+
+		if selected {
+			if err := checkProtoField(fd); err != nil {
+				return err
+			}
+			if err := transformProtoField(m, fd, fn); err != nil {
+				return fmt.Errorf("%s: %w", fd.FullName(), err)
+			}
+			continue
+		}
+		if len(subpaths) == 0 && option == nil {
+			continue
+		}
+
+		switch {
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			var err error
+			m.Get(fd).Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				err = walkProtoMessage(v.Message(), subpaths, option, fn)
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+		case fd.IsList() && fd.Message() != nil:
+			list := m.Get(fd).List()
+			for i := 0; i < list.Len(); i++ {
+				if err := walkProtoMessage(list.Get(i).Message(), subpaths, option, fn); err != nil {
+					return err
+				}
+			}
+		case fd.Message() != nil && !fd.IsMap():
+			if err := walkProtoMessage(m.Mutable(fd).Message(), subpaths, option, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func transformProtoField(m protoreflect.Message, fd protoreflect.FieldDescriptor, fn protoFieldFunc) error {
+	if !fd.IsList() {
+		v, err := fn(fd, m.Get(fd))
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+		return nil
+	}
+
+	list := m.Mutable(fd).List()
+	for i := 0; i < list.Len(); i++ {
+		v := list.Get(i)
+		if (fd.Kind() == protoreflect.StringKind && v.String() == "") || (fd.Kind() == protoreflect.BytesKind && len(v.Bytes()) == 0) {
+			continue
+		}
+		v, err := fn(fd, v)
+		if err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+		list.Set(i, v)
+	}
+	return nil
+}
+
+// hasBoolOption reports whether the boolean field option is set to true on fd.
+func hasBoolOption(fd protoreflect.FieldDescriptor, option protoreflect.ExtensionType) bool {
+	if option == nil {
+		return false
+	}
+	opts, ok := fd.Options().(proto.Message)
+	if !ok || opts == nil {
+		return false
+	}
+	xd := option.TypeDescriptor()
+	m := opts.ProtoReflect()
+	if !m.IsValid() || !m.Has(xd) {
+		return false
+	}
+	return m.Get(xd).Bool()
+}
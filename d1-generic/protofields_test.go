@@ -0,0 +1,227 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+)
+
+// testProtoTypes builds the following definitions at runtime:
+//
+//	extend google.protobuf.FieldOptions { bool encrypt = 50000; }
+//
+//	message Card { string number = 1 [(encrypt) = true]; string expiry = 2; }
+//	message Patient {
+//	  string name = 1;
+//	  string ssn = 2 [(encrypt) = true];
+//	  bytes notes = 3;
+//	  repeated Card cards = 4;
+//	  repeated string aliases = 5 [(encrypt) = true];
+//	  map<string, Card> by_label = 6;
+//	}
+func testProtoTypes(t *testing.T) (protoreflect.MessageDescriptor, protoreflect.ExtensionType) {
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(descriptorpb.File_google_protobuf_descriptor_proto); err != nil {
+		t.Fatal(err)
+	}
+
+	optFile, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("options.proto"),
+		Package:    proto.String("d1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("encrypt"),
+			Number:   proto.Int32(50000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+			Extendee: proto.String(".google.protobuf.FieldOptions"),
+			JsonName: proto.String("encrypt"),
+		}},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.RegisterFile(optFile); err != nil {
+		t.Fatal(err)
+	}
+	encrypt := dynamicpb.NewExtensionType(optFile.Extensions().Get(0))
+
+	encrypted := func() *descriptorpb.FieldOptions {
+		opts := &descriptorpb.FieldOptions{}
+		opts.ProtoReflect().Set(encrypt.TypeDescriptor(), protoreflect.ValueOfBool(true))
+		return opts
+	}
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	stringType := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	messageType := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	number := field("number", 1, stringType, optional)
+	number.Options = encrypted()
+	ssn := field("ssn", 2, stringType, optional)
+	ssn.Options = encrypted()
+	cards := field("cards", 4, messageType, repeated)
+	cards.TypeName = proto.String(".test.Card")
+	aliases := field("aliases", 5, stringType, repeated)
+	aliases.Options = encrypted()
+	byLabel := field("by_label", 6, messageType, repeated)
+	byLabel.TypeName = proto.String(".test.Patient.ByLabelEntry")
+	entryValue := field("value", 2, messageType, optional)
+	entryValue.TypeName = proto.String(".test.Card")
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"options.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Card"),
+				Field: []*descriptorpb.FieldDescriptorProto{number, field("expiry", 2, stringType, optional)},
+			},
+			{
+				Name: proto.String("Patient"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, stringType, optional),
+					ssn,
+					field("notes", 3, descriptorpb.FieldDescriptorProto_TYPE_BYTES, optional),
+					cards,
+					aliases,
+					byLabel,
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name:    proto.String("ByLabelEntry"),
+					Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, stringType, optional), entryValue},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("Patient"), encrypt
+}
+
+func newTestPatient(md protoreflect.MessageDescriptor) *dynamicpb.Message {
+	cardType := md.Fields().ByName("cards").Message()
+	newCard := func(number, expiry string) protoreflect.Value {
+		card := dynamicpb.NewMessage(cardType)
+		card.Set(cardType.Fields().ByName("number"), protoreflect.ValueOfString(number))
+		card.Set(cardType.Fields().ByName("expiry"), protoreflect.ValueOfString(expiry))
+		return protoreflect.ValueOfMessage(card)
+	}
+
+	p := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	p.Set(fields.ByName("name"), protoreflect.ValueOfString("Alice"))
+	p.Set(fields.ByName("ssn"), protoreflect.ValueOfString("123-45-6789"))
+	p.Set(fields.ByName("notes"), protoreflect.ValueOfBytes([]byte("allergic")))
+	cards := p.Mutable(fields.ByName("cards")).List()
+	cards.Append(newCard("4111", "12/30"))
+	cards.Append(newCard("5500", "01/29"))
+	aliases := p.Mutable(fields.ByName("aliases")).List()
+	aliases.Append(protoreflect.ValueOfString("Ali"))
+	aliases.Append(protoreflect.ValueOfString(""))
+	p.Mutable(fields.ByName("by_label")).Map().Set(protoreflect.ValueOfString("work").MapKey(), newCard("3700", "06/28"))
+	return p
+}
+
+func TestEncryptProto(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+	md, encrypt := testProtoTypes(t)
+
+	tests := []struct {
+		name string
+		opts []client.ProtoOption
+	}{
+		{"field option", []client.ProtoOption{client.WithProtoFieldOption(encrypt)}},
+		{"field paths", []client.ProtoOption{client.WithProtoFieldPaths("ssn", "cards.number", "aliases", "by_label.number", "notes")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPatient(md)
+			if err := c.EncryptProto(ctx, p, tc.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			text := p.String()
+			for _, secret := range []string{"123-45-6789", "4111", "5500", "3700", "Ali\""} {
+				if strings.Contains(text, secret) {
+					t.Fatalf("%q was not encrypted: %s", secret, text)
+				}
+			}
+			for _, kept := range []string{"Alice", "12/30"} {
+				if !strings.Contains(text, kept) {
+					t.Fatalf("%q was changed: %s", kept, text)
+				}
+			}
+
+			if err := c.DecryptProto(ctx, p, tc.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := newTestPatient(md); !proto.Equal(p, want) {
+				t.Fatalf("got %v, want %v", p, want)
+			}
+		})
+	}
+}
+
+func TestEncryptProtoErrors(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+	md, _ := testProtoTypes(t)
+
+	for _, path := range []string{"missing", "cards", "name.first", "cards.missing", "by_label"} {
+		if err := c.EncryptProto(ctx, newTestPatient(md), client.WithProtoFieldPaths(path)); err == nil {
+			t.Errorf("expected error for path %q", path)
+		}
+	}
+	if err := c.EncryptProto(ctx, newTestPatient(md)); err == nil {
+		t.Error("expected error when no fields are selected")
+	}
+
+	// An envelope moved to another field does not decrypt.
+	p := newTestPatient(md)
+	if err := c.EncryptProto(ctx, p, client.WithProtoFieldPaths("ssn")); err != nil {
+		t.Fatal(err)
+	}
+	p.Set(md.Fields().ByName("name"), p.Get(md.Fields().ByName("ssn")))
+	if err := c.DecryptProto(ctx, p, client.WithProtoFieldPaths("name")); err == nil {
+		t.Fatal("expected decryption of moved envelope to fail")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package reencrypt re-encrypts stored envelopes, for example after a key rotation or after the groups
+of an organization have been restructured.
+
+Run reads envelopes from a Source, decrypts each of them with the Generic service, encrypts the
+plaintext again as a new object, and passes the new envelope to a Sink, which typically replaces the
+stored envelope. The ACL of the old object, as returned by GetPermissions, is carried over to the
+new object, and can be extended or remapped with options. The associated data can be rewritten as
+well.
+
+Items are identified by a key chosen by the caller, such as a primary key. With WithCheckpoint, the
+keys of completed items are recorded in a file, so an interrupted run can be started again and
+continues where it stopped.
+*/
+package reencrypt
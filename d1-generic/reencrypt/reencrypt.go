@@ -0,0 +1,285 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reencrypt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/internal/checkpoint"
+	"github.com/cybercryptio/d1-client-go/v2/internal/parallel"
+)
+
+// Item is a stored envelope identified by a key chosen by the caller. Keys must be unique and must
+// not contain newlines.
+type Item struct {
+	Key      string
+	Envelope *envelope.Envelope
+}
+
+// Source returns the next item to re-encrypt, or io.EOF when there are no more items. It is never
+// called concurrently.
+type Source func(ctx context.Context) (Item, error)
+
+// Sink stores a re-encrypted item. It may be called concurrently.
+type Sink func(ctx context.Context, item Item) error
+
+// SliceSource returns a Source returning the given items.
+func SliceSource(items ...Item) Source {
+	return func(context.Context) (Item, error) {
+		if len(items) == 0 {
+			return Item{}, io.EOF
+		}
+		item := items[0]
+		items = items[1:]
+		return item, nil
+	}
+}
+
+// Failure describes an item which could not be re-encrypted.
+type Failure struct {
+	Key string
+	Err error
+}
+
+// Report summarizes a run.
+type Report struct {
+	// Done is the number of items re-encrypted and stored.
+	Done int
+	// Skipped is the number of items that were already completed according to the checkpoint.
+	Skipped  int
+	Failures []Failure
+}
+
+// Option is used to configure optional settings on a run.
+type Option func(*config)
+
+type config struct {
+	concurrency    int
+	checkpointPath string
+	groupIDs       []string
+	groupMapping   map[string]string
+	associatedData func(key string, associatedData []byte) []byte
+}
+
+// WithConcurrency returns an Option which sets how many items are processed at the same time. The
+// default is 8.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithCheckpoint returns an Option which records the keys of completed items in the file at path.
+// When a run is started again with the same checkpoint, completed items are skipped. A checkpoint
+// cannot be reused with different group options.
+func WithCheckpoint(path string) Option {
+	return func(c *config) {
+		c.checkpointPath = path
+	}
+}
+
+// WithGroups returns an Option which gives the groups access to every new object, in addition to
+// the groups carried over from the old object.
+func WithGroups(groupIDs ...string) Option {
+	return func(c *config) {
+		c.groupIDs = groupIDs
+	}
+}
+
+// WithGroupMapping returns an Option which replaces groups carried over from the old object. A group
+// mapped to the empty string is dropped.
+func WithGroupMapping(mapping map[string]string) Option {
+	return func(c *config) {
+		c.groupMapping = mapping
+	}
+}
+
+// WithAssociatedData returns an Option which sets the associated data of every new object to the
+// result of fn, which is given the item's key and its current associated data.
+func WithAssociatedData(fn func(key string, associatedData []byte) []byte) Option {
+	return func(c *config) {
+		c.associatedData = fn
+	}
+}
+
+// Run re-encrypts every item returned by the source and passes the results to the sink. Items that
+// fail are listed in the report, and do not stop the run. An error is only returned if the source
+// fails, the checkpoint cannot be used, or the context is cancelled.
+func Run(ctx context.Context, generic pb.GenericClient, authz pbauthz.AuthzClient, source Source, sink Sink, opts ...Option) (*Report, error) {
+	cfg := config{concurrency: 8}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var cp *checkpoint.File
+	if cfg.checkpointPath != "" {
+		var err error
+		cp, err = checkpoint.Open(cfg.checkpointPath, cfg.signature())
+		if err != nil {
+			return nil, err
+		}
+		defer cp.Close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	items := make(chan Item)
+	var sourceErr error
+	sourceDone := make(chan struct{})
+	go func() {
+		defer close(sourceDone)
+		defer close(items)
+		for {
+			item, err := source(ctx)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					sourceErr = err
+					cancel()
+				}
+				return
+			}
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	report := &Report{}
+	var mu sync.Mutex
+	parallel.ForEach(ctx, items, cfg.concurrency, func(item Item) {
+		if cp != nil && cp.Done(item.Key) {
+			mu.Lock()
+			report.Skipped++
+			mu.Unlock()
+			return
+		}
+
+		err := cfg.reencrypt(ctx, generic, authz, sink, item)
+		if err == nil && cp != nil {
+			err = cp.Mark(item.Key)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{Key: item.Key, Err: err})
+		} else {
+			report.Done++
+		}
+	})
+	<-sourceDone
+
+	if sourceErr != nil {
+		return report, sourceErr
+	}
+	return report, ctx.Err()
+}
+
+func (c *config) reencrypt(ctx context.Context, generic pb.GenericClient, authz pbauthz.AuthzClient, sink Sink, item Item) error {
+	if strings.Contains(item.Key, "\n") {
+		return errors.New("key contains a newline")
+	}
+	old := item.Envelope
+	if old == nil {
+		return errors.New("missing envelope")
+	}
+
+	permissions, err := authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: old.ObjectID})
+	if err != nil {
+		return err
+	}
+	decrypted, err := generic.Decrypt(ctx, old.DecryptRequest(), envelope.KeepCompressed{})
+	if err != nil {
+		return err
+	}
+	defer zero(decrypted.Plaintext)
+
+	associatedData := old.AssociatedData
+	if c.associatedData != nil {
+		associatedData = c.associatedData(item.Key, associatedData)
+	}
+	encrypted, err := generic.Encrypt(ctx, &pb.EncryptRequest{
+		Plaintext:      decrypted.Plaintext,
+		AssociatedData: associatedData,
+		GroupIds:       c.groups(permissions.GroupIds),
+	}, envelope.KeepCompressed{})
+	if err != nil {
+		return err
+	}
+
+	// The plaintext is stored as it was, including its compression header, so the compression
+	// carries over.
+	return sink(ctx, Item{
+		Key: item.Key,
+		Envelope: &envelope.Envelope{
+			ObjectID:       encrypted.ObjectId,
+			Ciphertext:     encrypted.Ciphertext,
+			AssociatedData: encrypted.AssociatedData,
+			Compression:    old.Compression,
+		},
+	})
+}
+
+// groups returns the groups of the new object, given the groups of the old object.
+func (c *config) groups(groupIDs []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	add := func(gid string) {
+		if gid != "" && !seen[gid] {
+			seen[gid] = true
+			result = append(result, gid)
+		}
+	}
+
+	for _, gid := range groupIDs {
+		if mapped, ok := c.groupMapping[gid]; ok {
+			gid = mapped
+		}
+		add(gid)
+	}
+	for _, gid := range c.groupIDs {
+		add(gid)
+	}
+	return result
+}
+
+// signature describes the group options, so a checkpoint is not resumed with different ones.
+func (c *config) signature() string {
+	groupIDs := append([]string(nil), c.groupIDs...)
+	sort.Strings(groupIDs)
+	var mapping []string
+	for from, to := range c.groupMapping {
+		mapping = append(mapping, from+">"+to)
+	}
+	sort.Strings(mapping)
+	return "reencrypt:" + strings.Join(groupIDs, ",") + ":" + strings.Join(mapping, ",")
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reencrypt_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/reencrypt"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestRun(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	oldGroup, _ := server.NewUser()
+	newGroup, _ := server.NewUser()
+	extraGroup, _ := server.NewUser()
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	plaintexts := map[string]string{"row-1": "alice", "row-2": "bob", "row-3": "carol"}
+	var items []reencrypt.Item
+	for key, plaintext := range plaintexts {
+		env, err := envelope.Encrypt(ctx, c.Generic, []byte(plaintext), []byte(key), envelope.WithGroups(oldGroup), envelope.WithCompression())
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, reencrypt.Item{Key: key, Envelope: env})
+	}
+	items = append(items, reencrypt.Item{Key: "broken", Envelope: &envelope.Envelope{ObjectID: items[0].Envelope.ObjectID}})
+
+	var mu sync.Mutex
+	stored := map[string]*envelope.Envelope{}
+	sink := func(ctx context.Context, item reencrypt.Item) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stored[item.Key] = item.Envelope
+		return nil
+	}
+
+	opts := []reencrypt.Option{
+		reencrypt.WithCheckpoint(filepath.Join(t.TempDir(), "checkpoint")),
+		reencrypt.WithGroupMapping(map[string]string{oldGroup: newGroup}),
+		reencrypt.WithGroups(extraGroup),
+		reencrypt.WithAssociatedData(func(key string, associatedData []byte) []byte {
+			return append([]byte("v2:"), associatedData...)
+		}),
+	}
+	report, err := reencrypt.Run(ctx, c.Generic, c.Authz, reencrypt.SliceSource(items...), sink, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Done != 3 || report.Skipped != 0 || len(report.Failures) != 1 || report.Failures[0].Key != "broken" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	for key, plaintext := range plaintexts {
+		env := stored[key]
+		if string(env.AssociatedData) != "v2:"+key {
+			t.Fatalf("unexpected associated data %q", env.AssociatedData)
+		}
+		decrypted, err := env.Decrypt(ctx, c.Generic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != plaintext {
+			t.Fatalf("got %q, want %q", decrypted, plaintext)
+		}
+		for _, gid := range []string{uid, newGroup, extraGroup} {
+			if !contains(server.ObjectGroups(env.ObjectID), gid) {
+				t.Fatalf("group %s missing from %v", gid, server.ObjectGroups(env.ObjectID))
+			}
+		}
+		if contains(server.ObjectGroups(env.ObjectID), oldGroup) {
+			t.Fatalf("old group was carried over: %v", server.ObjectGroups(env.ObjectID))
+		}
+	}
+
+	// Resuming skips the completed items and retries the failed one.
+	report, err = reencrypt.Run(ctx, c.Generic, c.Authz, reencrypt.SliceSource(items...), sink, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Done != 0 || report.Skipped != 3 || len(report.Failures) != 1 {
+		t.Fatalf("unexpected report when resuming: %+v", report)
+	}
+
+	// A checkpoint cannot be resumed with different groups.
+	_, err = reencrypt.Run(ctx, c.Generic, c.Authz, reencrypt.SliceSource(items...), sink, opts[0])
+	if err == nil {
+		t.Fatal("expected the checkpoint to be rejected")
+	}
+}
+
+func TestRunSourceError(t *testing.T) {
+	server := d1test.NewServer(t)
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(server.NewUser(d1test.AllScopes()...))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sourceErr := errors.New("source failed")
+	source := func(context.Context) (reencrypt.Item, error) {
+		return reencrypt.Item{}, sourceErr
+	}
+	sink := func(context.Context, reencrypt.Item) error {
+		t.Fatal("unexpected item")
+		return nil
+	}
+	if _, err := reencrypt.Run(context.Background(), c.Generic, c.Authz, source, sink); !errors.Is(err, sourceErr) {
+		t.Fatalf("expected the source error, got %v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package registry keeps a local directory of the users, groups, group memberships and objects managed
+through a D1 client.
+
+The D1 Authn API can create and remove identities but cannot list them. A Registry fills that gap by
+recording every successful CreateUser, CreateGroup, AddUserToGroups, RemoveUserFromGroups and
+RemoveUser call made through a client as an Event in a pluggable Store. The current directory is
+rebuilt by replaying the events, so the store doubles as an audit log.
+
+Objects created by Generic.Encrypt and Storage.Store, and deleted by Storage.Delete, are recorded
+the same way, so a Registry can list the objects to audit with aclaudit.RegistrySource.
+
+To record the calls made by a client, install the registry's interceptor when creating it:
+
+	reg, err := registry.New(registry.NewFileStore("identities.jsonl"))
+	...
+	c, err := client.NewGenericClient(endpoint,
+		client.WithGrpcOption(grpc.WithChainUnaryInterceptor(reg.UnaryClientInterceptor())),
+		...
+	)
+
+Passwords returned by CreateUser are never recorded.
+*/
+package registry
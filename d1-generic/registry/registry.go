@@ -0,0 +1,352 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+// EventType identifies the operation recorded by an Event.
+type EventType string
+
+// Event types recorded by a Registry.
+const (
+	EventCreateUser           EventType = "CreateUser"
+	EventRemoveUser           EventType = "RemoveUser"
+	EventCreateGroup          EventType = "CreateGroup"
+	EventAddUserToGroups      EventType = "AddUserToGroups"
+	EventRemoveUserFromGroups EventType = "RemoveUserFromGroups"
+	EventCreateObject         EventType = "CreateObject"
+	EventDeleteObject         EventType = "DeleteObject"
+)
+
+// Event is a single successful Authn operation, or the creation or deletion of an object.
+type Event struct {
+	Type     EventType      `json:"type"`
+	Time     time.Time      `json:"time"`
+	UserID   string         `json:"user_id,omitempty"`
+	GroupIDs []string       `json:"group_ids,omitempty"`
+	Scopes   []scopes.Scope `json:"scopes,omitempty"`
+	ObjectID string         `json:"object_id,omitempty"`
+}
+
+// User is a user known to the registry.
+type User struct {
+	ID        string
+	Scopes    []scopes.Scope
+	GroupIDs  []string
+	CreatedAt time.Time
+}
+
+// Group is a group known to the registry.
+type Group struct {
+	ID        string
+	Scopes    []scopes.Scope
+	MemberIDs []string
+	CreatedAt time.Time
+}
+
+type userEntry struct {
+	scopes    []scopes.Scope
+	groups    map[string]bool
+	createdAt time.Time
+}
+
+type groupEntry struct {
+	scopes    []scopes.Scope
+	createdAt time.Time
+}
+
+// Registry is a local directory of users, groups, memberships and objects. It is safe for
+// concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	store   Store
+	now     func() time.Time
+	users   map[string]*userEntry
+	groups  map[string]*groupEntry
+	objects map[string]bool
+}
+
+// New creates a Registry backed by the given store, replaying any events already persisted in it.
+func New(store Store) (*Registry, error) {
+	r := &Registry{
+		store:   store,
+		now:     time.Now,
+		users:   map[string]*userEntry{},
+		groups:  map[string]*groupEntry{},
+		objects: map[string]bool{},
+	}
+
+	events, err := store.Events()
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		r.apply(event)
+	}
+
+	return r, nil
+}
+
+// Record persists an event and applies it to the directory. It can be used to import identities
+// that were created before the registry was put in place. If Time is unset, the current time is
+// used.
+func (r *Registry) Record(event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = r.now()
+	}
+	if err := r.store.Append(event); err != nil {
+		return err
+	}
+
+	r.apply(event)
+	return nil
+}
+
+// Events returns the full history of recorded events.
+func (r *Registry) Events() ([]Event, error) {
+	return r.store.Events()
+}
+
+// Users returns all known users ordered by ID.
+func (r *Registry) Users() []User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]User, 0, len(r.users))
+	for id := range r.users {
+		users = append(users, r.user(id))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// User returns the user with the given ID.
+func (r *Registry) User(id string) (User, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.users[id]; !ok {
+		return User{}, false
+	}
+	return r.user(id), true
+}
+
+// Groups returns all known groups ordered by ID.
+func (r *Registry) Groups() []Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	groups := make([]Group, 0, len(r.groups))
+	for id := range r.groups {
+		groups = append(groups, r.group(id))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// Group returns the group with the given ID.
+func (r *Registry) Group(id string) (Group, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.groups[id]; !ok {
+		return Group{}, false
+	}
+	return r.group(id), true
+}
+
+// UserGroups returns the IDs of the groups the user has been added to, ordered by ID, or nil if the
+// user is unknown.
+func (r *Registry) UserGroups(userID string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return nil
+	}
+	return sortedKeys(u.groups)
+}
+
+// ObjectIDs returns the IDs of the objects created and not deleted through the registry's
+// interceptor, ordered by ID. It lets a Registry serve as the source of an ACL audit.
+func (r *Registry) ObjectIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return sortedKeys(r.objects)
+}
+
+// UnaryClientInterceptor returns an interceptor that records successful Authn calls, and the
+// objects created and deleted by Generic.Encrypt, Storage.Store and Storage.Delete, in the
+// registry. If a call succeeds but cannot be recorded, the interceptor returns the store error.
+func (r *Registry) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+			return err
+		}
+
+		event, ok := eventFromCall(method, req, reply)
+		if !ok {
+			return nil
+		}
+		if err := r.Record(event); err != nil {
+			return fmt.Errorf("%s succeeded but could not be recorded: %w", method, err)
+		}
+		return nil
+	}
+}
+
+func eventFromCall(method string, req, reply interface{}) (Event, bool) {
+	switch method {
+	case "/d1.authn.Authn/CreateUser":
+		return Event{
+			Type:   EventCreateUser,
+			UserID: reply.(*pbauthn.CreateUserResponse).UserId,
+			Scopes: req.(*pbauthn.CreateUserRequest).Scopes,
+		}, true
+	case "/d1.authn.Authn/RemoveUser":
+		return Event{
+			Type:   EventRemoveUser,
+			UserID: req.(*pbauthn.RemoveUserRequest).UserId,
+		}, true
+	case "/d1.authn.Authn/CreateGroup":
+		return Event{
+			Type:     EventCreateGroup,
+			GroupIDs: []string{reply.(*pbauthn.CreateGroupResponse).GroupId},
+			Scopes:   req.(*pbauthn.CreateGroupRequest).Scopes,
+		}, true
+	case "/d1.authn.Authn/AddUserToGroups":
+		r := req.(*pbauthn.AddUserToGroupsRequest)
+		return Event{
+			Type:     EventAddUserToGroups,
+			UserID:   r.UserId,
+			GroupIDs: r.GroupIds,
+		}, true
+	case "/d1.authn.Authn/RemoveUserFromGroups":
+		r := req.(*pbauthn.RemoveUserFromGroupsRequest)
+		return Event{
+			Type:     EventRemoveUserFromGroups,
+			UserID:   r.UserId,
+			GroupIDs: r.GroupIds,
+		}, true
+	case "/d1.generic.Generic/Encrypt", "/d1.storage.Storage/Store":
+		if r, ok := reply.(interface{ GetObjectId() string }); ok {
+			return Event{Type: EventCreateObject, ObjectID: r.GetObjectId()}, true
+		}
+	case "/d1.storage.Storage/Delete":
+		if r, ok := req.(interface{ GetObjectId() string }); ok {
+			return Event{Type: EventDeleteObject, ObjectID: r.GetObjectId()}, true
+		}
+	}
+	return Event{}, false
+}
+
+// apply updates the directory with an event. The caller must hold r.mu for writing.
+func (r *Registry) apply(event Event) {
+	switch event.Type {
+	case EventCreateUser:
+		r.users[event.UserID] = &userEntry{
+			scopes:    event.Scopes,
+			groups:    map[string]bool{},
+			createdAt: event.Time,
+		}
+	case EventRemoveUser:
+		delete(r.users, event.UserID)
+	case EventCreateGroup:
+		for _, id := range event.GroupIDs {
+			r.groups[id] = &groupEntry{scopes: event.Scopes, createdAt: event.Time}
+		}
+	case EventAddUserToGroups:
+		u := r.userEntry(event.UserID, event.Time)
+		for _, id := range event.GroupIDs {
+			u.groups[id] = true
+		}
+	case EventRemoveUserFromGroups:
+		if u, ok := r.users[event.UserID]; ok {
+			for _, id := range event.GroupIDs {
+				delete(u.groups, id)
+			}
+		}
+	case EventCreateObject:
+		r.objects[event.ObjectID] = true
+	case EventDeleteObject:
+		delete(r.objects, event.ObjectID)
+	}
+}
+
+// userEntry returns the entry for a user, creating it if the user was not created through the
+// registry. The caller must hold r.mu for writing.
+func (r *Registry) userEntry(id string, seen time.Time) *userEntry {
+	u, ok := r.users[id]
+	if !ok {
+		u = &userEntry{groups: map[string]bool{}, createdAt: seen}
+		r.users[id] = u
+	}
+	return u
+}
+
+// user builds the exported view of a user. The caller must hold r.mu.
+func (r *Registry) user(id string) User {
+	u := r.users[id]
+	return User{
+		ID:        id,
+		Scopes:    append([]scopes.Scope(nil), u.scopes...),
+		GroupIDs:  sortedKeys(u.groups),
+		CreatedAt: u.createdAt,
+	}
+}
+
+// group builds the exported view of a group. The caller must hold r.mu.
+func (r *Registry) group(id string) Group {
+	g := r.groups[id]
+	members := []string{}
+	for uid, u := range r.users {
+		if u.groups[id] {
+			members = append(members, uid)
+		}
+	}
+	sort.Strings(members)
+
+	return Group{
+		ID:        id,
+		Scopes:    append([]scopes.Scope(nil), g.scopes...),
+		MemberIDs: members,
+		CreatedAt: g.createdAt,
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for key := range set {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/registry"
+	sclient "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestRegistryRecordsAuthnCalls(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	path := filepath.Join(t.TempDir(), "registry.jsonl")
+	reg, err := registry.New(registry.NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []client.Option{
+		client.WithTokenRefresh(uid, pwd),
+		client.WithGrpcOption(grpc.WithChainUnaryInterceptor(reg.UnaryClientInterceptor())),
+	}
+	for _, opt := range server.DialOptions() {
+		opts = append(opts, client.WithGrpcOption(opt))
+	}
+	c, err := client.NewBaseClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	user1, err := c.Authn.CreateUser(ctx, &pbauthn.CreateUserRequest{Scopes: []scopes.Scope{scopes.Scope_READ}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2, err := c.Authn.CreateUser(ctx, &pbauthn.CreateUserRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := c.Authn.CreateGroup(ctx, &pbauthn.CreateGroupRequest{Scopes: []scopes.Scope{scopes.Scope_READ}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{user1.UserId, user2.UserId} {
+		if _, err := c.Authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{UserId: id, GroupIds: []string{group.GroupId}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Authn.RemoveUserFromGroups(ctx, &pbauthn.RemoveUserFromGroupsRequest{UserId: user2.UserId, GroupIds: []string{group.GroupId}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Authn.RemoveUser(ctx, &pbauthn.RemoveUserRequest{UserId: user1.UserId}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed calls are not recorded.
+	if _, err := c.Authn.RemoveUser(ctx, &pbauthn.RemoveUserRequest{UserId: "unknown"}); err == nil {
+		t.Fatal("expected RemoveUser of an unknown user to fail")
+	}
+
+	// Reopen the registry to check that the directory is rebuilt from the file.
+	reg, err = registry.New(registry.NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := reg.Users()
+	if len(users) != 1 || users[0].ID != user2.UserId {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+	if groups := reg.UserGroups(user2.UserId); len(groups) != 0 {
+		t.Fatalf("unexpected groups for %s: %v", user2.UserId, groups)
+	}
+
+	g, ok := reg.Group(group.GroupId)
+	if !ok {
+		t.Fatalf("group %s not found", group.GroupId)
+	}
+	if !reflect.DeepEqual(g.Scopes, []scopes.Scope{scopes.Scope_READ}) || len(g.MemberIDs) != 0 {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+
+	events, err := reg.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []registry.EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	expected := []registry.EventType{
+		registry.EventCreateUser,
+		registry.EventCreateUser,
+		registry.EventCreateGroup,
+		registry.EventAddUserToGroups,
+		registry.EventAddUserToGroups,
+		registry.EventRemoveUserFromGroups,
+		registry.EventRemoveUser,
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("unexpected events: %v", types)
+	}
+}
+
+func TestRegistryRecordsObjects(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+
+	reg, err := registry.New(&registry.MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []client.Option{
+		client.WithTokenRefresh(uid, pwd),
+		client.WithGrpcOption(grpc.WithChainUnaryInterceptor(reg.UnaryClientInterceptor())),
+	}
+	for _, opt := range server.DialOptions() {
+		opts = append(opts, client.WithGrpcOption(opt))
+	}
+	c, err := sclient.NewStorageClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	generic := pb.NewGenericClient(c.Connection)
+
+	ctx := context.Background()
+	encrypted, err := generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Storage.Delete(ctx, &pbstorage.DeleteRequest{ObjectId: deleted.ObjectId}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{encrypted.ObjectId, kept.ObjectId}
+	sort.Strings(expected)
+	if objectIDs := reg.ObjectIDs(); !reflect.DeepEqual(objectIDs, expected) {
+		t.Fatalf("got objects %v, want %v", objectIDs, expected)
+	}
+}
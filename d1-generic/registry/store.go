@@ -0,0 +1,120 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Store persists the events recorded by a Registry.
+type Store interface {
+	// Append persists a single event.
+	Append(Event) error
+	// Events returns all persisted events in the order they were appended.
+	Events() ([]Event, error)
+}
+
+// MemoryStore is a Store that keeps events in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Append implements Store.
+func (m *MemoryStore) Append(event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Events implements Store.
+func (m *MemoryStore) Events() ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...), nil
+}
+
+// FileStore is a Store that appends events to a file as JSON lines. The file is created on the
+// first append if it does not exist.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore creates a FileStore backed by the file at the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Append implements Store. The file is synced before Append returns.
+func (f *FileStore) Append(event Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Events implements Store. A missing file is treated as an empty store.
+func (f *FileStore) Events() ([]Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", f.path, line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
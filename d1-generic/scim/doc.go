@@ -0,0 +1,42 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package scim provides an HTTP handler implementing the SCIM 2.0 (RFC 7643, RFC 7644) Users and
+Groups endpoints on top of the D1 Authn API.
+
+SCIM requests are translated into calls to CreateUser, RemoveUser, CreateGroup, AddUserToGroups and
+RemoveUserFromGroups. D1 identities carry no attributes, so the handler assigns its own SCIM IDs and
+keeps the SCIM attributes together with the mapping to the D1 IDs in a Store.
+
+The following operations are supported:
+
+	POST   /Users           create a user
+	GET    /Users           list users, optionally filtered with `userName eq "..."`
+	GET    /Users/{id}      get a user
+	DELETE /Users/{id}      remove a user
+	POST   /Groups          create a group, optionally with members
+	GET    /Groups          list groups, optionally filtered with `displayName eq "..."`
+	GET    /Groups/{id}     get a group
+	PATCH  /Groups/{id}     add, remove or replace members
+	DELETE /Groups/{id}     remove all members and forget the group
+
+D1 has no operation for removing a group, so deleting a group removes all of its members in D1 and
+drops the mapping. The password of a newly created D1 user is handed to the callback configured with
+WithUserCreatedHook, as SCIM has no way of returning it.
+
+The handler must be mounted at the SCIM base path, e.g. with http.StripPrefix, and must be given an
+Authn client authenticated as a user allowed to manage identities.
+*/
+package scim
@@ -0,0 +1,709 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+const contentType = "application/scim+json"
+
+// UserCreatedHook is called with the credentials of every D1 user created through the handler. If
+// it returns an error, the D1 user is removed again and the SCIM request fails.
+type UserCreatedHook func(ctx context.Context, user User, password string) error
+
+// Handler is an http.Handler serving the SCIM Users and Groups endpoints.
+type Handler struct {
+	mu      sync.Mutex
+	authn   pbauthn.AuthnClient
+	store   Store
+	mapping *Mapping
+
+	baseURL       string
+	userScopes    []scopes.Scope
+	groupScopes   []scopes.Scope
+	onUserCreated UserCreatedHook
+	now           func() time.Time
+}
+
+// Option is used to configure optional settings on the handler.
+type Option func(*Handler)
+
+// WithBaseURL sets the URL the handler is served at, used to build resource locations.
+func WithBaseURL(baseURL string) Option {
+	return func(h *Handler) {
+		h.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithUserScopes sets the scopes given to D1 users created through the handler.
+func WithUserScopes(userScopes ...scopes.Scope) Option {
+	return func(h *Handler) {
+		h.userScopes = userScopes
+	}
+}
+
+// WithGroupScopes sets the scopes given to D1 groups created through the handler.
+func WithGroupScopes(groupScopes ...scopes.Scope) Option {
+	return func(h *Handler) {
+		h.groupScopes = groupScopes
+	}
+}
+
+// WithUserCreatedHook sets the hook receiving the credentials of newly created D1 users.
+func WithUserCreatedHook(hook UserCreatedHook) Option {
+	return func(h *Handler) {
+		h.onUserCreated = hook
+	}
+}
+
+// NewHandler creates a SCIM handler that provisions identities through the given Authn client and
+// keeps its mapping in the given store.
+func NewHandler(authn pbauthn.AuthnClient, store Store, opts ...Option) (*Handler, error) {
+	mapping, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		authn:   authn,
+		store:   store,
+		mapping: mapping,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resource, id := splitPath(r.URL.Path)
+	switch {
+	case resource == "Users" && id == "" && r.Method == http.MethodPost:
+		h.createUser(w, r)
+	case resource == "Users" && id == "" && r.Method == http.MethodGet:
+		h.listUsers(w, r)
+	case resource == "Users" && id != "" && r.Method == http.MethodGet:
+		h.getUser(w, id)
+	case resource == "Users" && id != "" && r.Method == http.MethodDelete:
+		h.deleteUser(w, r, id)
+	case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+		h.createGroup(w, r)
+	case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+		h.listGroups(w, r)
+	case resource == "Groups" && id != "" && r.Method == http.MethodGet:
+		h.getGroup(w, id)
+	case resource == "Groups" && id != "" && r.Method == http.MethodPatch:
+		h.patchGroup(w, r, id)
+	case resource == "Groups" && id != "" && r.Method == http.MethodDelete:
+		h.deleteGroup(w, r, id)
+	case resource == "Users" || resource == "Groups":
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "", "unknown endpoint")
+	}
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if user.UserName == "" {
+		writeError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	for _, record := range h.mapping.Users {
+		if record.UserName == user.UserName {
+			writeError(w, http.StatusConflict, "uniqueness", "userName is already in use")
+			return
+		}
+	}
+
+	res, err := h.authn.CreateUser(r.Context(), &pbauthn.CreateUserRequest{Scopes: h.userScopes})
+	if err != nil {
+		writeD1Error(w, err)
+		return
+	}
+
+	record := UserRecord{
+		ID:          newID(),
+		D1ID:        res.UserId,
+		ExternalID:  user.ExternalID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Created:     h.now().UTC(),
+	}
+	created := h.user(record)
+
+	if h.onUserCreated != nil {
+		if err := h.onUserCreated(r.Context(), created, res.Password); err != nil {
+			_, _ = h.authn.RemoveUser(r.Context(), &pbauthn.RemoveUserRequest{UserId: res.UserId})
+			writeError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+
+	h.mapping.Users[record.ID] = record
+	if !h.save(w) {
+		delete(h.mapping.Users, record.ID)
+		_, _ = h.authn.RemoveUser(r.Context(), &pbauthn.RemoveUserRequest{UserId: res.UserId})
+		return
+	}
+
+	w.Header().Set("Location", created.Meta.Location)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+
+	users := []User{}
+	for _, record := range h.mapping.Users {
+		switch strings.ToLower(attribute) {
+		case "":
+		case "username":
+			if record.UserName != value {
+				continue
+			}
+		case "externalid":
+			if record.ExternalID != value {
+				continue
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "invalidFilter", "unsupported filter attribute "+attribute)
+			return
+		}
+		users = append(users, h.user(record))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserName < users[j].UserName })
+
+	writeList(w, r, len(users), func(start, end int) interface{} { return users[start:end] })
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, id string) {
+	record, ok := h.mapping.Users[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.user(record))
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, id string) {
+	record, ok := h.mapping.Users[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+
+	_, err := h.authn.RemoveUser(r.Context(), &pbauthn.RemoveUserRequest{UserId: record.D1ID})
+	if err != nil && status.Code(err) != codes.NotFound {
+		writeD1Error(w, err)
+		return
+	}
+
+	delete(h.mapping.Users, id)
+	for gid, group := range h.mapping.Groups {
+		group.MemberIDs = without(group.MemberIDs, id)
+		h.mapping.Groups[gid] = group
+	}
+	if !h.save(w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var group Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if group.DisplayName == "" {
+		writeError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+	memberIDs, err := h.memberIDs(group.Members)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	res, err := h.authn.CreateGroup(r.Context(), &pbauthn.CreateGroupRequest{Scopes: h.groupScopes})
+	if err != nil {
+		writeD1Error(w, err)
+		return
+	}
+
+	record := GroupRecord{
+		ID:          newID(),
+		D1ID:        res.GroupId,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Created:     h.now().UTC(),
+	}
+	// Record the group before adding members so a partial failure does not leak a D1 group.
+	h.mapping.Groups[record.ID] = record
+	if !h.save(w) {
+		return
+	}
+
+	if err := h.setMembers(r.Context(), &record, memberIDs); err != nil {
+		h.mapping.Groups[record.ID] = record
+		_ = h.store.Save(h.mapping)
+		writeD1Error(w, err)
+		return
+	}
+	h.mapping.Groups[record.ID] = record
+	if !h.save(w) {
+		return
+	}
+
+	created := h.group(record)
+	w.Header().Set("Location", created.Meta.Location)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+
+	groups := []Group{}
+	for _, record := range h.mapping.Groups {
+		switch strings.ToLower(attribute) {
+		case "":
+		case "displayname":
+			if record.DisplayName != value {
+				continue
+			}
+		case "externalid":
+			if record.ExternalID != value {
+				continue
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "invalidFilter", "unsupported filter attribute "+attribute)
+			return
+		}
+		groups = append(groups, h.group(record))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].DisplayName < groups[j].DisplayName })
+
+	writeList(w, r, len(groups), func(start, end int) interface{} { return groups[start:end] })
+}
+
+func (h *Handler) getGroup(w http.ResponseWriter, id string) {
+	record, ok := h.mapping.Groups[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.group(record))
+}
+
+func (h *Handler) patchGroup(w http.ResponseWriter, r *http.Request, id string) {
+	record, ok := h.mapping.Groups[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+
+	var patch PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	members := append([]string(nil), record.MemberIDs...)
+	displayName := record.DisplayName
+	for _, op := range patch.Operations {
+		var err error
+		members, displayName, err = h.applyPatch(strings.ToLower(op.Op), op.Path, op.Value, members, displayName)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	if err := h.setMembers(r.Context(), &record, members); err != nil {
+		h.mapping.Groups[id] = record
+		_ = h.store.Save(h.mapping)
+		writeD1Error(w, err)
+		return
+	}
+	record.DisplayName = displayName
+	h.mapping.Groups[id] = record
+	if !h.save(w) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.group(record))
+}
+
+// applyPatch applies a single PATCH operation to the member list and display name of a group.
+func (h *Handler) applyPatch(op, path string, value json.RawMessage, members []string, displayName string) ([]string, string, error) {
+	// An operation without a path carries the attributes to modify in its value.
+	if path == "" {
+		var attributes struct {
+			DisplayName *string  `json:"displayName"`
+			Members     []Member `json:"members"`
+		}
+		if err := json.Unmarshal(value, &attributes); err != nil {
+			return nil, "", fmt.Errorf("invalid value: %w", err)
+		}
+		if attributes.DisplayName != nil {
+			displayName = *attributes.DisplayName
+		}
+		if attributes.Members != nil {
+			raw, err := json.Marshal(attributes.Members)
+			if err != nil {
+				return nil, "", err
+			}
+			return h.applyPatch(op, "members", raw, members, displayName)
+		}
+		return members, displayName, nil
+	}
+
+	if strings.EqualFold(path, "displayName") {
+		if op == "remove" {
+			return nil, "", errors.New("displayName is required")
+		}
+		if err := json.Unmarshal(value, &displayName); err != nil {
+			return nil, "", fmt.Errorf("invalid displayName: %w", err)
+		}
+		return members, displayName, nil
+	}
+
+	// A path of the form members[value eq "id"] selects a single member.
+	if filter := strings.TrimPrefix(path, "members["); filter != path && strings.HasSuffix(filter, "]") {
+		attribute, id, err := parseFilter(strings.TrimSuffix(filter, "]"))
+		if err != nil || attribute != "value" || op != "remove" {
+			return nil, "", fmt.Errorf("unsupported path %q for op %q", path, op)
+		}
+		return without(members, id), displayName, nil
+	}
+
+	if path != "members" {
+		return nil, "", fmt.Errorf("unsupported path %q", path)
+	}
+
+	var values []Member
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &values); err != nil {
+			return nil, "", fmt.Errorf("invalid members: %w", err)
+		}
+	}
+	ids, err := h.memberIDs(values)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch op {
+	case "add":
+		for _, id := range ids {
+			if !contains(members, id) {
+				members = append(members, id)
+			}
+		}
+	case "remove":
+		if len(value) == 0 {
+			return nil, displayName, nil
+		}
+		for _, id := range ids {
+			members = without(members, id)
+		}
+	case "replace":
+		members = ids
+	default:
+		return nil, "", fmt.Errorf("unsupported op %q", op)
+	}
+	return members, displayName, nil
+}
+
+func (h *Handler) deleteGroup(w http.ResponseWriter, r *http.Request, id string) {
+	record, ok := h.mapping.Groups[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+
+	if err := h.setMembers(r.Context(), &record, nil); err != nil {
+		h.mapping.Groups[id] = record
+		_ = h.store.Save(h.mapping)
+		writeD1Error(w, err)
+		return
+	}
+
+	delete(h.mapping.Groups, id)
+	if !h.save(w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setMembers updates the D1 memberships of a group to match the given SCIM user IDs. The record is
+// updated as calls succeed, so it reflects the D1 state even if an error is returned.
+func (h *Handler) setMembers(ctx context.Context, record *GroupRecord, memberIDs []string) error {
+	for _, id := range memberIDs {
+		if contains(record.MemberIDs, id) {
+			continue
+		}
+		_, err := h.authn.AddUserToGroups(ctx, &pbauthn.AddUserToGroupsRequest{
+			UserId:   h.mapping.Users[id].D1ID,
+			GroupIds: []string{record.D1ID},
+		})
+		if err != nil {
+			return err
+		}
+		record.MemberIDs = append(record.MemberIDs, id)
+	}
+
+	for _, id := range append([]string(nil), record.MemberIDs...) {
+		if contains(memberIDs, id) {
+			continue
+		}
+		_, err := h.authn.RemoveUserFromGroups(ctx, &pbauthn.RemoveUserFromGroupsRequest{
+			UserId:   h.mapping.Users[id].D1ID,
+			GroupIds: []string{record.D1ID},
+		})
+		if err != nil {
+			return err
+		}
+		record.MemberIDs = without(record.MemberIDs, id)
+	}
+
+	return nil
+}
+
+// memberIDs validates that all members reference known users and returns their IDs.
+func (h *Handler) memberIDs(members []Member) ([]string, error) {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if _, ok := h.mapping.Users[member.Value]; !ok {
+			return nil, fmt.Errorf("unknown member %q", member.Value)
+		}
+		if !contains(ids, member.Value) {
+			ids = append(ids, member.Value)
+		}
+	}
+	return ids, nil
+}
+
+func (h *Handler) user(record UserRecord) User {
+	user := User{
+		Schemas:     []string{SchemaUser},
+		ID:          record.ID,
+		ExternalID:  record.ExternalID,
+		UserName:    record.UserName,
+		DisplayName: record.DisplayName,
+		Active:      true,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      record.Created,
+			Location:     h.baseURL + "/Users/" + record.ID,
+		},
+	}
+	for _, group := range h.mapping.Groups {
+		if contains(group.MemberIDs, record.ID) {
+			user.Groups = append(user.Groups, Member{
+				Value:   group.ID,
+				Display: group.DisplayName,
+				Ref:     h.baseURL + "/Groups/" + group.ID,
+			})
+		}
+	}
+	sort.Slice(user.Groups, func(i, j int) bool { return user.Groups[i].Value < user.Groups[j].Value })
+	return user
+}
+
+func (h *Handler) group(record GroupRecord) Group {
+	group := Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          record.ID,
+		ExternalID:  record.ExternalID,
+		DisplayName: record.DisplayName,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      record.Created,
+			Location:     h.baseURL + "/Groups/" + record.ID,
+		},
+	}
+	for _, id := range record.MemberIDs {
+		group.Members = append(group.Members, Member{
+			Value:   id,
+			Display: h.mapping.Users[id].UserName,
+			Ref:     h.baseURL + "/Users/" + id,
+		})
+	}
+	return group
+}
+
+// save persists the mapping, writing an error response if that fails.
+func (h *Handler) save(w http.ResponseWriter) bool {
+	if err := h.store.Save(h.mapping); err != nil {
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return false
+	}
+	return true
+}
+
+// splitPath splits a request path into the resource type and ID.
+func splitPath(path string) (string, string) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// parseFilter parses a filter of the form `attribute eq "value"`, the only form supported.
+func parseFilter(filter string) (string, string, error) {
+	if filter == "" {
+		return "", "", nil
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(filter), " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", fmt.Errorf("unsupported filter %q", filter)
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return "", "", fmt.Errorf("unsupported filter %q", filter)
+	}
+	return parts[0], value, nil
+}
+
+func writeList(w http.ResponseWriter, r *http.Request, total int, page func(start, end int) interface{}) {
+	start, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = total
+	}
+
+	from := start - 1
+	if from > total {
+		from = total
+	}
+	to := from + count
+	if to > total {
+		to = total
+	}
+
+	writeJSON(w, http.StatusOK, ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: to - from,
+		Resources:    page(from, to),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, scimType, detail string) {
+	writeJSON(w, code, Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(code),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// writeD1Error translates an error from D1 into a SCIM error response.
+func writeD1Error(w http.ResponseWriter, err error) {
+	code := http.StatusBadGateway
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.Unavailable, codes.DeadlineExceeded:
+		code = http.StatusServiceUnavailable
+	}
+	writeError(w, code, "", err.Error())
+}
+
+// newID generates a random version 4 UUID.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, value string) []string {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != value {
+			out = append(out, v)
+		}
+	}
+	return out
+}
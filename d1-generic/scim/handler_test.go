@@ -0,0 +1,288 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scim_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/scim"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+type fixture struct {
+	t         *testing.T
+	d1        *d1test.Server
+	client    client.BaseClient
+	http      *httptest.Server
+	store     scim.Store
+	passwords map[string]string
+}
+
+func newFixture(t *testing.T) *fixture {
+	d1 := d1test.NewServer(t)
+	uid, pwd := d1.NewUser(d1test.AllScopes()...)
+
+	c, err := client.NewBaseClient("bufnet", d1.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	f := &fixture{
+		t:         t,
+		d1:        d1,
+		client:    c,
+		store:     scim.NewFileStore(filepath.Join(t.TempDir(), "scim.json")),
+		passwords: map[string]string{},
+	}
+	f.start()
+	return f
+}
+
+// start (re)creates the handler from the store and serves it.
+func (f *fixture) start() {
+	if f.http != nil {
+		f.http.Close()
+	}
+
+	h, err := scim.NewHandler(f.client.Authn, f.store,
+		scim.WithBaseURL("/scim/v2"),
+		scim.WithUserCreatedHook(func(_ context.Context, user scim.User, password string) error {
+			f.passwords[user.ID] = password
+			return nil
+		}),
+	)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/scim/v2/", http.StripPrefix("/scim/v2", h))
+	f.http = httptest.NewServer(mux)
+	f.t.Cleanup(f.http.Close)
+}
+
+func (f *fixture) do(method, path string, body interface{}, expected int, out interface{}) {
+	f.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, f.http.URL+"/scim/v2"+path, &reader)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/scim+json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expected {
+		var scimErr scim.Error
+		_ = json.NewDecoder(res.Body).Decode(&scimErr)
+		f.t.Fatalf("%s %s: expected status %d, got %d: %+v", method, path, expected, res.StatusCode, scimErr)
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// d1ID looks up the D1 ID of a SCIM resource in the persisted mapping.
+func (f *fixture) d1ID(id string) string {
+	mapping, err := f.store.Load()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if user, ok := mapping.Users[id]; ok {
+		return user.D1ID
+	}
+	return mapping.Groups[id].D1ID
+}
+
+func (f *fixture) assertMembers(groupID string, userIDs ...string) {
+	f.t.Helper()
+
+	var group scim.Group
+	f.do(http.MethodGet, "/Groups/"+groupID, nil, http.StatusOK, &group)
+	if len(group.Members) != len(userIDs) {
+		f.t.Fatalf("expected members %v, got %+v", userIDs, group.Members)
+	}
+	for i, id := range userIDs {
+		if group.Members[i].Value != id {
+			f.t.Fatalf("expected members %v, got %+v", userIDs, group.Members)
+		}
+	}
+
+	gid := f.d1ID(groupID)
+	for _, id := range userIDs {
+		found := false
+		for _, g := range f.d1.UserGroups(f.d1ID(id)) {
+			found = found || g == gid
+		}
+		if !found {
+			f.t.Fatalf("user %s is not a member of %s in D1", id, gid)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	f := newFixture(t)
+
+	var alice, bob scim.User
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "alice", ExternalID: "hr-1"}, http.StatusCreated, &alice)
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "bob"}, http.StatusCreated, &bob)
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "alice"}, http.StatusConflict, nil)
+
+	if alice.Meta.Location != "/scim/v2/Users/"+alice.ID {
+		t.Fatalf("unexpected location %q", alice.Meta.Location)
+	}
+
+	// The credentials handed to the hook work against D1.
+	_, err := f.client.Authn.LoginUser(context.Background(), &pbauthn.LoginUserRequest{
+		UserId:   f.d1ID(alice.ID),
+		Password: f.passwords[alice.ID],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list struct {
+		TotalResults int         `json:"totalResults"`
+		Resources    []scim.User `json:"Resources"`
+	}
+	f.do(http.MethodGet, `/Users?filter=userName%20eq%20%22bob%22`, nil, http.StatusOK, &list)
+	if list.TotalResults != 1 || list.Resources[0].ID != bob.ID {
+		t.Fatalf("unexpected list response: %+v", list)
+	}
+
+	d1ID := f.d1ID(alice.ID)
+	f.do(http.MethodDelete, "/Users/"+alice.ID, nil, http.StatusNoContent, nil)
+	f.do(http.MethodGet, "/Users/"+alice.ID, nil, http.StatusNotFound, nil)
+	if f.d1.HasUser(d1ID) {
+		t.Fatalf("user %s was not removed from D1", d1ID)
+	}
+
+	// The mapping survives a restart.
+	f.start()
+	f.do(http.MethodGet, "/Users/"+bob.ID, nil, http.StatusOK, nil)
+}
+
+func TestGroups(t *testing.T) {
+	f := newFixture(t)
+
+	var alice, bob, carol scim.User
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "alice"}, http.StatusCreated, &alice)
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "bob"}, http.StatusCreated, &bob)
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "carol"}, http.StatusCreated, &carol)
+
+	var group scim.Group
+	f.do(http.MethodPost, "/Groups", scim.Group{
+		DisplayName: "engineering",
+		Members:     []scim.Member{{Value: alice.ID}},
+	}, http.StatusCreated, &group)
+	f.assertMembers(group.ID, alice.ID)
+
+	f.do(http.MethodPost, "/Groups", scim.Group{
+		DisplayName: "unknown members",
+		Members:     []scim.Member{{Value: "unknown"}},
+	}, http.StatusBadRequest, nil)
+
+	members, _ := json.Marshal([]scim.Member{{Value: bob.ID}, {Value: carol.ID}})
+	f.do(http.MethodPatch, "/Groups/"+group.ID, scim.PatchRequest{
+		Schemas:    []string{scim.SchemaPatchOp},
+		Operations: []scim.PatchOperation{{Op: "add", Path: "members", Value: members}},
+	}, http.StatusOK, nil)
+	f.assertMembers(group.ID, alice.ID, bob.ID, carol.ID)
+
+	f.do(http.MethodPatch, "/Groups/"+group.ID, scim.PatchRequest{
+		Schemas:    []string{scim.SchemaPatchOp},
+		Operations: []scim.PatchOperation{{Op: "remove", Path: `members[value eq "` + alice.ID + `"]`}},
+	}, http.StatusOK, nil)
+	f.assertMembers(group.ID, bob.ID, carol.ID)
+
+	// Removing a user also removes it from its groups.
+	f.do(http.MethodDelete, "/Users/"+carol.ID, nil, http.StatusNoContent, nil)
+	f.assertMembers(group.ID, bob.ID)
+
+	var user scim.User
+	f.do(http.MethodGet, "/Users/"+bob.ID, nil, http.StatusOK, &user)
+	if len(user.Groups) != 1 || user.Groups[0].Value != group.ID {
+		t.Fatalf("unexpected groups for bob: %+v", user.Groups)
+	}
+
+	bobD1, groupD1 := f.d1ID(bob.ID), f.d1ID(group.ID)
+	f.do(http.MethodDelete, "/Groups/"+group.ID, nil, http.StatusNoContent, nil)
+	f.do(http.MethodGet, "/Groups/"+group.ID, nil, http.StatusNotFound, nil)
+	for _, g := range f.d1.UserGroups(bobD1) {
+		if g == groupD1 {
+			t.Fatalf("user %s is still a member of %s in D1", bobD1, groupD1)
+		}
+	}
+}
+
+// failingStore fails every Save and remembers the D1 users it was asked to save.
+type failingStore struct {
+	scim.Store
+	d1IDs []string
+}
+
+func (s *failingStore) Save(mapping *scim.Mapping) error {
+	for _, record := range mapping.Users {
+		s.d1IDs = append(s.d1IDs, record.D1ID)
+	}
+	return errors.New("disk full")
+}
+
+func TestCreateUserSaveFailure(t *testing.T) {
+	f := newFixture(t)
+	store := &failingStore{Store: f.store}
+	f.store = store
+	f.start()
+
+	f.do(http.MethodPost, "/Users", scim.User{UserName: "alice"}, http.StatusInternalServerError, nil)
+
+	if len(store.d1IDs) != 1 {
+		t.Fatalf("expected one user to be saved, got %v", store.d1IDs)
+	}
+	if f.d1.HasUser(store.d1IDs[0]) {
+		t.Fatalf("user %s was not removed from D1", store.d1IDs[0])
+	}
+
+	// The failed user is not kept in memory either.
+	var list struct {
+		TotalResults int `json:"totalResults"`
+	}
+	f.do(http.MethodGet, "/Users", nil, http.StatusOK, &list)
+	if list.TotalResults != 0 {
+		t.Fatalf("expected no users, got %d", list.TotalResults)
+	}
+}
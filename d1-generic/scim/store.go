@@ -0,0 +1,166 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UserRecord is the stored state of a SCIM user.
+type UserRecord struct {
+	ID          string    `json:"id"`
+	D1ID        string    `json:"d1_id"`
+	ExternalID  string    `json:"external_id,omitempty"`
+	UserName    string    `json:"user_name"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// GroupRecord is the stored state of a SCIM group. Members are referenced by SCIM user ID.
+type GroupRecord struct {
+	ID          string    `json:"id"`
+	D1ID        string    `json:"d1_id"`
+	ExternalID  string    `json:"external_id,omitempty"`
+	DisplayName string    `json:"display_name"`
+	MemberIDs   []string  `json:"member_ids,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// Mapping is the full set of SCIM resources and their D1 IDs.
+type Mapping struct {
+	Users  map[string]UserRecord  `json:"users"`
+	Groups map[string]GroupRecord `json:"groups"`
+}
+
+func newMapping() *Mapping {
+	return &Mapping{
+		Users:  map[string]UserRecord{},
+		Groups: map[string]GroupRecord{},
+	}
+}
+
+// Store persists the mapping between SCIM resources and D1 identities.
+type Store interface {
+	// Load returns the persisted mapping, or an empty mapping if nothing has been saved yet.
+	Load() (*Mapping, error)
+	// Save replaces the persisted mapping.
+	Save(*Mapping) error
+}
+
+// MemoryStore is a Store that keeps the mapping in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// Load implements Store.
+func (m *MemoryStore) Load() (*Mapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return decodeMapping(m.data)
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(mapping *Mapping) error {
+	data, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = data
+	return nil
+}
+
+// FileStore is a Store that keeps the mapping in a JSON file. The file is replaced atomically on
+// every save.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore creates a FileStore backed by the file at the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load implements Store. A missing file is treated as an empty mapping.
+func (f *FileStore) Load() (*Mapping, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newMapping(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeMapping(data)
+}
+
+// Save implements Store.
+func (f *FileStore) Save(mapping *Mapping) error {
+	data, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func decodeMapping(data []byte) (*Mapping, error) {
+	mapping := newMapping()
+	if len(data) == 0 {
+		return mapping, nil
+	}
+	if err := json.Unmarshal(data, mapping); err != nil {
+		return nil, err
+	}
+	if mapping.Users == nil {
+		mapping.Users = map[string]UserRecord{}
+	}
+	if mapping.Groups == nil {
+		mapping.Groups = map[string]GroupRecord{}
+	}
+	return mapping, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+)
+
+const redacted = "[REDACTED]"
+
+// ErrMemoryLockUnsupported is returned by SecretBytes.Lock on platforms without mlock support.
+var ErrMemoryLockUnsupported = errors.New("memory locking is not supported on this platform")
+
+// SecretBytes holds sensitive data such as a plaintext or a password. The data is never printed or
+// marshalled, and is overwritten with zeros by Destroy. It is safe for concurrent use.
+//
+// The data is not zeroed when the SecretBytes is garbage collected, since slices returned by Bytes
+// may still be in use, so callers must call Destroy when they are done with it.
+//
+// Go may copy memory while the data is being produced, for example when decoding a gRPC response,
+// so SecretBytes limits the lifetime of the data rather than guaranteeing that no copies exist.
+type SecretBytes struct {
+	mu     sync.Mutex
+	data   []byte
+	locked bool
+}
+
+// NewSecretBytes returns a SecretBytes holding data. The SecretBytes takes ownership of data, which
+// is zeroed when the SecretBytes is destroyed, so the caller must not use it afterwards.
+func NewSecretBytes(data []byte) *SecretBytes {
+	return &SecretBytes{data: data}
+}
+
+// Bytes returns the data. The returned slice shares memory with the SecretBytes and must not be
+// used after Destroy. It is nil once the SecretBytes has been destroyed.
+func (s *SecretBytes) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data
+}
+
+// Len returns the length of the data.
+func (s *SecretBytes) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+// Lock locks the memory holding the data, so it is not swapped to disk. It is only supported on
+// Linux, and returns ErrMemoryLockUnsupported elsewhere. The memory is unlocked by Destroy.
+func (s *SecretBytes) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked || len(s.data) == 0 {
+		return nil
+	}
+	if err := mlock(s.data); err != nil {
+		return err
+	}
+	s.locked = true
+	return nil
+}
+
+// Destroy overwrites the data with zeros and releases it. Destroying a SecretBytes more than once
+// has no effect.
+func (s *SecretBytes) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data {
+		s.data[i] = 0
+	}
+	if s.locked {
+		_ = munlock(s.data)
+		s.locked = false
+	}
+	s.data = nil
+}
+
+// String returns a placeholder, so the data is not printed by accident.
+func (s *SecretBytes) String() string {
+	return redacted
+}
+
+// GoString returns a placeholder, so the data is not printed by accident.
+func (s *SecretBytes) GoString() string {
+	return redacted
+}
+
+// MarshalJSON returns a placeholder, so the data is not marshalled by accident.
+func (s *SecretBytes) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// DecryptSecret decrypts the request and returns the plaintext as SecretBytes, which the caller
+// should destroy when done with it.
+func (c *GenericClient) DecryptSecret(ctx context.Context, request *pb.DecryptRequest) (*SecretBytes, error) {
+	res, err := c.Generic.Decrypt(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretBytes(res.Plaintext), nil
+}
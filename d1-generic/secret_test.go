@@ -0,0 +1,131 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestSecretBytes(t *testing.T) {
+	data := []byte("hunter2")
+	s := client.NewSecretBytes(data)
+
+	for _, printed := range []string{
+		fmt.Sprint(s),
+		fmt.Sprintf("%s %v %+v %#v %x %q", s, s, s, s, s, s),
+	} {
+		if strings.Contains(printed, "hunter2") || strings.Contains(printed, fmt.Sprintf("%x", "hunter2")) {
+			t.Fatalf("secret was printed: %s", printed)
+		}
+	}
+	marshalled, err := json.Marshal(struct{ Password *client.SecretBytes }{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(marshalled), "hunter2") {
+		t.Fatalf("secret was marshalled: %s", marshalled)
+	}
+
+	err = s.Lock()
+	switch {
+	case runtime.GOOS != "linux" && !errors.Is(err, client.ErrMemoryLockUnsupported):
+		t.Fatalf("expected ErrMemoryLockUnsupported, got %v", err)
+	case runtime.GOOS == "linux" && err != nil:
+		// Locking can fail if the memory lock limit is low.
+		t.Logf("memory could not be locked: %v", err)
+	}
+	if string(s.Bytes()) != "hunter2" || s.Len() != 7 {
+		t.Fatalf("unexpected data %q", s.Bytes())
+	}
+
+	s.Destroy()
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Fatalf("data was not zeroed: %q", data)
+	}
+	if s.Bytes() != nil || s.Len() != 0 {
+		t.Fatal("data is still available after Destroy")
+	}
+	s.Destroy()
+}
+
+func TestDecryptSecret(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	encrypted, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.DecryptSecret(ctx, &pb.DecryptRequest{ObjectId: encrypted.ObjectId, Ciphertext: encrypted.Ciphertext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+	if string(s.Bytes()) != "plaintext" {
+		t.Fatalf("unexpected plaintext %q", s.Bytes())
+	}
+}
+
+func TestTokenRefreshSecret(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	password := client.NewSecretBytes([]byte(pwd))
+	opts := []client.Option{client.WithTokenRefreshSecret(uid, password)}
+	for _, opt := range server.DialOptions() {
+		opts = append(opts, client.WithGrpcOption(opt))
+	}
+	c, err := client.NewGenericClient("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Generic.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("plaintext")})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The password is not copied, so destroying it leaves nothing behind.
+	data := password.Bytes()
+	password.Destroy()
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Fatal("password was not zeroed")
+	}
+}
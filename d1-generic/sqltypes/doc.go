@@ -0,0 +1,47 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package sqltypes provides database/sql column types that are encrypted with D1.
+
+EncryptedString, EncryptedBytes and EncryptedJSON implement driver.Valuer and sql.Scanner. Values
+are encrypted with the Generic service when they are written, and stored as envelopes in binary
+form, so the column should be a binary column such as bytea. Scanning a row only parses the
+envelope; the value is decrypted the first time Get is called.
+
+Since Value and Scan do not take a context, each value is bound to a Binding holding the context
+and the client used for D1 calls:
+
+	b := sqltypes.NewBinding(ctx, c.Generic)
+	ssn := sqltypes.NewEncryptedString(b, "123-45-6789")
+	_, err := db.ExecContext(ctx, "INSERT INTO patients (ssn) VALUES ($1)", &ssn)
+
+	ssn = sqltypes.EncryptedString{}
+	ssn.Bind(b)
+	err = db.QueryRowContext(ctx, "SELECT ssn FROM patients").Scan(&ssn)
+	plaintext, err := ssn.Get()
+
+A value that was never set, or that was scanned from NULL, is written as NULL.
+
+An envelope copied from one row or column to another still decrypts unless it is tied to where it
+belongs. Set associated data identifying the table, column and row, for example with package aad,
+before writing or reading the value. Get returns ErrAssociatedDataMismatch instead of decrypting an
+envelope that was encrypted with different associated data:
+
+	ad, err := aad.NewBuilder().Table("patients").Column("ssn").RowID(id).Build()
+	ssn.SetAssociatedData(ad)
+
+WithAssociatedData sets associated data shared by every value of a Binding, such as the tenant.
+*/
+package sqltypes
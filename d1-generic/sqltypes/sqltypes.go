@@ -0,0 +1,347 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqltypes
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+)
+
+// ErrUnbound is returned when a value that needs a D1 call has no Binding.
+var ErrUnbound = errors.New("encrypted value has no binding")
+
+// ErrAssociatedDataMismatch is returned when a stored envelope was encrypted with different
+// associated data than the value expects, for example because it was copied from another row.
+var ErrAssociatedDataMismatch = errors.New("encrypted value has unexpected associated data")
+
+// Binding holds the context and client used to encrypt and decrypt values.
+type Binding struct {
+	ctx            context.Context
+	client         pb.GenericClient
+	opts           []envelope.Option
+	associatedData []byte
+}
+
+// Option is used to configure optional settings on a Binding.
+type Option func(*Binding)
+
+// WithGroups returns an Option which gives the groups access to every value encrypted through the
+// binding.
+func WithGroups(groupIDs ...string) Option {
+	return func(b *Binding) {
+		b.opts = append(b.opts, envelope.WithGroups(groupIDs...))
+	}
+}
+
+// WithCompression returns an Option which compresses values before they are encrypted.
+func WithCompression() Option {
+	return func(b *Binding) {
+		b.opts = append(b.opts, envelope.WithCompression())
+	}
+}
+
+// WithAssociatedData returns an Option which sets the associated data of values that do not set
+// their own with SetAssociatedData.
+func WithAssociatedData(associatedData []byte) Option {
+	return func(b *Binding) {
+		b.associatedData = associatedData
+	}
+}
+
+// NewBinding creates a Binding which makes D1 calls with the given context and client.
+func NewBinding(ctx context.Context, client pb.GenericClient, opts ...Option) *Binding {
+	b := &Binding{ctx: ctx, client: client}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// encrypted holds the state shared by the column types. A value is either NULL, known in
+// plaintext, known as an envelope, or both once it has been encrypted or decrypted.
+type encrypted struct {
+	binding        *Binding
+	plaintext      []byte
+	decrypted      bool
+	env            *envelope.Envelope
+	associatedData []byte
+}
+
+func (e *encrypted) set(plaintext []byte) {
+	e.plaintext = plaintext
+	e.decrypted = true
+	e.env = nil
+}
+
+// setAssociatedData sets the associated data the value is encrypted with and expected to have when
+// decrypted. A known plaintext is encrypted again on the next write.
+func (e *encrypted) setAssociatedData(associatedData []byte) {
+	e.associatedData = associatedData
+	if e.decrypted {
+		e.env = nil
+	}
+}
+
+// expectedAssociatedData returns the associated data of the value, falling back to the binding.
+func (e *encrypted) expectedAssociatedData() []byte {
+	if e.associatedData == nil && e.binding != nil {
+		return e.binding.associatedData
+	}
+	return e.associatedData
+}
+
+// verify checks that the envelope has the associated data the value expects.
+func (e *encrypted) verify() error {
+	if !bytes.Equal(e.env.AssociatedData, e.expectedAssociatedData()) {
+		return ErrAssociatedDataMismatch
+	}
+	return nil
+}
+
+func (e *encrypted) isNull() bool {
+	return !e.decrypted && e.env == nil
+}
+
+// get returns the plaintext, decrypting it on first use.
+func (e *encrypted) get() ([]byte, error) {
+	if e.decrypted || e.env == nil {
+		return e.plaintext, nil
+	}
+	if e.binding == nil {
+		return nil, ErrUnbound
+	}
+	if err := e.verify(); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := e.env.Decrypt(e.binding.ctx, e.binding.client)
+	if err != nil {
+		return nil, err
+	}
+	e.plaintext = plaintext
+	e.decrypted = true
+	return plaintext, nil
+}
+
+// value returns the envelope in binary form, encrypting the plaintext on first use.
+func (e *encrypted) value() (driver.Value, error) {
+	if e.isNull() {
+		return nil, nil
+	}
+	if e.env == nil {
+		if e.binding == nil {
+			return nil, ErrUnbound
+		}
+		env, err := envelope.Encrypt(e.binding.ctx, e.binding.client, e.plaintext, e.expectedAssociatedData(), e.binding.opts...)
+		if err != nil {
+			return nil, err
+		}
+		e.env = env
+	} else if err := e.verify(); err != nil {
+		return nil, err
+	}
+	return envelope.Marshal(e.env)
+}
+
+// scan parses an envelope without decrypting it.
+func (e *encrypted) scan(src interface{}) error {
+	e.plaintext = nil
+	e.decrypted = false
+	e.env = nil
+
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into an encrypted value", src)
+	}
+
+	env, err := envelope.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	e.env = env
+	return nil
+}
+
+// EncryptedString is a string column encrypted with D1.
+type EncryptedString struct {
+	e encrypted
+}
+
+// NewEncryptedString returns an EncryptedString holding s.
+func NewEncryptedString(b *Binding, s string) EncryptedString {
+	var v EncryptedString
+	v.Bind(b)
+	v.Set(s)
+	return v
+}
+
+// Bind sets the Binding used for D1 calls.
+func (v *EncryptedString) Bind(b *Binding) {
+	v.e.binding = b
+}
+
+// Set replaces the value.
+func (v *EncryptedString) Set(s string) {
+	v.e.set([]byte(s))
+}
+
+// Get returns the value, decrypting it if needed. NULL is returned as the empty string.
+func (v *EncryptedString) Get() (string, error) {
+	plaintext, err := v.e.get()
+	return string(plaintext), err
+}
+
+// SetAssociatedData sets the associated data the value is encrypted with, overriding the binding.
+// A scanned value is only decrypted if its envelope has the same associated data.
+func (v *EncryptedString) SetAssociatedData(associatedData []byte) {
+	v.e.setAssociatedData(associatedData)
+}
+
+// IsNull reports whether the value is NULL.
+func (v *EncryptedString) IsNull() bool {
+	return v.e.isNull()
+}
+
+// Value implements driver.Valuer.
+func (v *EncryptedString) Value() (driver.Value, error) {
+	return v.e.value()
+}
+
+// Scan implements sql.Scanner.
+func (v *EncryptedString) Scan(src interface{}) error {
+	return v.e.scan(src)
+}
+
+// EncryptedBytes is a binary column encrypted with D1.
+type EncryptedBytes struct {
+	e encrypted
+}
+
+// NewEncryptedBytes returns an EncryptedBytes holding b.
+func NewEncryptedBytes(binding *Binding, b []byte) EncryptedBytes {
+	var v EncryptedBytes
+	v.Bind(binding)
+	v.Set(b)
+	return v
+}
+
+// Bind sets the Binding used for D1 calls.
+func (v *EncryptedBytes) Bind(b *Binding) {
+	v.e.binding = b
+}
+
+// Set replaces the value.
+func (v *EncryptedBytes) Set(b []byte) {
+	v.e.set(b)
+}
+
+// Get returns the value, decrypting it if needed. NULL is returned as nil.
+func (v *EncryptedBytes) Get() ([]byte, error) {
+	return v.e.get()
+}
+
+// SetAssociatedData sets the associated data the value is encrypted with, overriding the binding.
+// A scanned value is only decrypted if its envelope has the same associated data.
+func (v *EncryptedBytes) SetAssociatedData(associatedData []byte) {
+	v.e.setAssociatedData(associatedData)
+}
+
+// IsNull reports whether the value is NULL.
+func (v *EncryptedBytes) IsNull() bool {
+	return v.e.isNull()
+}
+
+// Value implements driver.Valuer.
+func (v *EncryptedBytes) Value() (driver.Value, error) {
+	return v.e.value()
+}
+
+// Scan implements sql.Scanner.
+func (v *EncryptedBytes) Scan(src interface{}) error {
+	return v.e.scan(src)
+}
+
+// EncryptedJSON is a column holding a value of type T, marshalled to JSON and encrypted with D1.
+type EncryptedJSON[T any] struct {
+	e encrypted
+}
+
+// NewEncryptedJSON returns an EncryptedJSON holding value.
+func NewEncryptedJSON[T any](b *Binding, value T) (EncryptedJSON[T], error) {
+	var v EncryptedJSON[T]
+	v.Bind(b)
+	return v, v.Set(value)
+}
+
+// Bind sets the Binding used for D1 calls.
+func (v *EncryptedJSON[T]) Bind(b *Binding) {
+	v.e.binding = b
+}
+
+// Set replaces the value.
+func (v *EncryptedJSON[T]) Set(value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	v.e.set(data)
+	return nil
+}
+
+// Get returns the value, decrypting it if needed. NULL is returned as the zero value of T.
+func (v *EncryptedJSON[T]) Get() (T, error) {
+	var value T
+	data, err := v.e.get()
+	if err != nil || data == nil {
+		return value, err
+	}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// SetAssociatedData sets the associated data the value is encrypted with, overriding the binding.
+// A scanned value is only decrypted if its envelope has the same associated data.
+func (v *EncryptedJSON[T]) SetAssociatedData(associatedData []byte) {
+	v.e.setAssociatedData(associatedData)
+}
+
+// IsNull reports whether the value is NULL.
+func (v *EncryptedJSON[T]) IsNull() bool {
+	return v.e.isNull()
+}
+
+// Value implements driver.Valuer.
+func (v *EncryptedJSON[T]) Value() (driver.Value, error) {
+	return v.e.value()
+}
+
+// Scan implements sql.Scanner.
+func (v *EncryptedJSON[T]) Scan(src interface{}) error {
+	return v.e.scan(src)
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqltypes_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/aad"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/sqltypes"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+var (
+	_ driver.Valuer = (*sqltypes.EncryptedString)(nil)
+	_ sql.Scanner   = (*sqltypes.EncryptedString)(nil)
+	_ driver.Valuer = (*sqltypes.EncryptedBytes)(nil)
+	_ sql.Scanner   = (*sqltypes.EncryptedBytes)(nil)
+	_ driver.Valuer = (*sqltypes.EncryptedJSON[int])(nil)
+	_ sql.Scanner   = (*sqltypes.EncryptedJSON[int])(nil)
+)
+
+type record struct {
+	Name  string
+	Score int
+}
+
+func newBinding(t *testing.T, opts ...sqltypes.Option) (*d1test.Server, *sqltypes.Binding) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewGenericClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return server, sqltypes.NewBinding(context.Background(), c.Generic, opts...)
+}
+
+func buildAD(t *testing.T, b *aad.Builder) []byte {
+	t.Helper()
+	data, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncryptedString(t *testing.T) {
+	server, b := newBinding(t)
+
+	s := sqltypes.NewEncryptedString(b, "123-45-6789")
+	stored, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The envelope is reused, so a value is only encrypted once.
+	if again, _ := s.Value(); !reflect.DeepEqual(again, stored) {
+		t.Fatal("value was encrypted twice")
+	}
+
+	var scanned sqltypes.EncryptedString
+	scanned.Bind(b)
+	if err := scanned.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, decrypts := server.GenericCalls(); decrypts != 0 {
+		t.Fatal("scanning made a D1 call")
+	}
+
+	got, err := scanned.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "123-45-6789" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := scanned.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if _, decrypts := server.GenericCalls(); decrypts != 1 {
+		t.Fatalf("expected one decryption, got %d", decrypts)
+	}
+}
+
+func TestEncryptedBytesAndJSON(t *testing.T) {
+	_, b := newBinding(t)
+
+	raw := sqltypes.NewEncryptedBytes(b, []byte{1, 2, 3})
+	stored, err := raw.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scannedBytes sqltypes.EncryptedBytes
+	scannedBytes.Bind(b)
+	if err := scannedBytes.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := scannedBytes.Get(); err != nil || !reflect.DeepEqual(got, []byte{1, 2, 3}) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	want := record{Name: "Alice", Score: 42}
+	j, err := sqltypes.NewEncryptedJSON(b, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err = j.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scannedJSON sqltypes.EncryptedJSON[record]
+	scannedJSON.Bind(b)
+	// Drivers may return text columns as strings.
+	if err := scannedJSON.Scan(string(stored.([]byte))); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := scannedJSON.Get(); err != nil || got != want {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
+
+func TestEncryptedNullAndUnbound(t *testing.T) {
+	_, b := newBinding(t)
+
+	var s sqltypes.EncryptedString
+	if v, err := s.Value(); v != nil || err != nil {
+		t.Fatalf("expected NULL, got %v, %v", v, err)
+	}
+	if err := s.Scan(nil); err != nil || !s.IsNull() {
+		t.Fatalf("expected NULL after scanning nil, got %v", err)
+	}
+
+	s.Set("secret")
+	if _, err := s.Value(); !errors.Is(err, sqltypes.ErrUnbound) {
+		t.Fatalf("expected ErrUnbound, got %v", err)
+	}
+
+	bound := sqltypes.NewEncryptedString(b, "secret")
+	stored, err := bound.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unbound sqltypes.EncryptedString
+	if err := unbound.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unbound.Get(); !errors.Is(err, sqltypes.ErrUnbound) {
+		t.Fatalf("expected ErrUnbound, got %v", err)
+	}
+	if err := unbound.Scan(42); err == nil {
+		t.Fatal("expected error scanning an integer")
+	}
+}
+
+func TestEncryptedAssociatedData(t *testing.T) {
+	server, b := newBinding(t)
+	rowAD := func(id string) []byte {
+		return buildAD(t, aad.NewBuilder().Table("patients").Column("ssn").RowID(id))
+	}
+
+	s := sqltypes.NewEncryptedString(b, "123-45-6789")
+	s.SetAssociatedData(rowAD("1"))
+	stored, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned sqltypes.EncryptedString
+	scanned.Bind(b)
+	scanned.SetAssociatedData(rowAD("1"))
+	if err := scanned.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := scanned.Get(); err != nil || got != "123-45-6789" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// An envelope copied to another row is not decrypted.
+	var copied sqltypes.EncryptedString
+	copied.Bind(b)
+	copied.SetAssociatedData(rowAD("2"))
+	if err := copied.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copied.Get(); !errors.Is(err, sqltypes.ErrAssociatedDataMismatch) {
+		t.Fatalf("expected ErrAssociatedDataMismatch, got %v", err)
+	}
+	if _, err := copied.Value(); !errors.Is(err, sqltypes.ErrAssociatedDataMismatch) {
+		t.Fatalf("expected ErrAssociatedDataMismatch, got %v", err)
+	}
+	if _, decrypts := server.GenericCalls(); decrypts != 1 {
+		t.Fatalf("expected one decryption, got %d", decrypts)
+	}
+}
+
+func TestBindingAssociatedData(t *testing.T) {
+	_, b := newBinding(t, sqltypes.WithAssociatedData(buildAD(t, aad.NewBuilder().Tenant("acme"))))
+
+	s := sqltypes.NewEncryptedString(b, "secret")
+	stored, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := envelope.Unmarshal(stored.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aad.Verify(env.AssociatedData, aad.Context{aad.KeyTenant: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned sqltypes.EncryptedString
+	scanned.Bind(b)
+	if err := scanned.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := scanned.Get(); err != nil || got != "secret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// A value's own associated data takes precedence over the binding's.
+	scanned.SetAssociatedData(buildAD(t, aad.NewBuilder().Tenant("other")))
+	if err := scanned.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanned.Get(); !errors.Is(err, sqltypes.ErrAssociatedDataMismatch) {
+		t.Fatalf("expected ErrAssociatedDataMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+)
+
+// Streams are encrypted in chunks, each of which is encrypted with a separate Generic.Encrypt call
+// and therefore becomes a separate D1 object. The encrypted stream is written in the following
+// container format, where all integers are big-endian:
+//
+//	header:
+//	  magic       6 bytes  "D1STRM"
+//	  version     1 byte   1
+//	  stream ID  16 bytes  random
+//	  chunk size  4 bytes  maximum plaintext size of a chunk
+//	chunk, repeated:
+//	  flags       1 byte   0x01 if this is the final chunk
+//	  object ID   2 bytes length, followed by the object ID
+//	  ciphertext  4 bytes length, followed by the ciphertext
+//
+// The associated data of each chunk is not stored in the container. It is derived from the stream
+// ID, the chunk index and the final flag, and for the final chunk also the total number of chunks,
+// so chunks cannot be reordered, removed, or moved between streams without decryption failing. A
+// stream always ends with a final chunk, which is empty if the input was empty, so truncation is
+// detected as well.
+const (
+	streamMagic   = "D1STRM"
+	streamVersion = 1
+
+	streamFinal = 0x01
+
+	// DefaultStreamChunkSize is the default maximum plaintext size of a stream chunk.
+	DefaultStreamChunkSize = 1 << 20
+	// MaxStreamChunkSize is the largest chunk size accepted in a stream.
+	MaxStreamChunkSize = 16 << 20
+
+	streamIDSize = 16
+	// maxCiphertextOverhead bounds the ciphertext expansion accepted when reading a chunk.
+	maxCiphertextOverhead = 1 << 16
+)
+
+var (
+	// ErrInvalidStream is returned when decrypting data that is not a valid encrypted stream.
+	ErrInvalidStream = errors.New("invalid encrypted stream")
+	// ErrTruncatedStream is returned when an encrypted stream ends before its final chunk.
+	ErrTruncatedStream = errors.New("encrypted stream is truncated")
+)
+
+// StreamOption is used to configure stream encryption.
+type StreamOption func(*streamConfig)
+
+type streamConfig struct {
+	chunkSize   int
+	groupIDs    []string
+	concurrency int
+}
+
+// WithChunkSize returns a StreamOption which sets the maximum plaintext size of each chunk. The
+// default is DefaultStreamChunkSize. The encrypted chunk must fit in a single gRPC message.
+func WithChunkSize(size int) StreamOption {
+	return func(c *streamConfig) {
+		c.chunkSize = size
+	}
+}
+
+// WithStreamGroups returns a StreamOption which gives the groups access to every chunk of the
+// stream.
+func WithStreamGroups(groupIDs ...string) StreamOption {
+	return func(c *streamConfig) {
+		c.groupIDs = groupIDs
+	}
+}
+
+// WithStreamConcurrency returns a StreamOption which sets how many chunks EncryptingWriter and
+// DecryptingReader process at the same time. The default is 4.
+func WithStreamConcurrency(n int) StreamOption {
+	return func(c *streamConfig) {
+		c.concurrency = n
+	}
+}
+
+func newStreamConfig(opts []StreamOption) (streamConfig, error) {
+	config := streamConfig{chunkSize: DefaultStreamChunkSize, concurrency: 4}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.chunkSize <= 0 || config.chunkSize > MaxStreamChunkSize {
+		return config, fmt.Errorf("chunk size must be between 1 and %d", MaxStreamChunkSize)
+	}
+	if config.concurrency < 1 {
+		config.concurrency = 1
+	}
+	return config, nil
+}
+
+// EncryptStream reads plaintext from r until EOF and writes it to w as an encrypted stream.
+func (c *GenericClient) EncryptStream(ctx context.Context, r io.Reader, w io.Writer, opts ...StreamOption) error {
+	config, err := newStreamConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	header, err := newStreamHeader(config.chunkSize)
+	if err != nil {
+		return err
+	}
+	if err := header.write(w); err != nil {
+		return err
+	}
+
+	chunks := newChunker(r, config.chunkSize)
+	for index := uint64(0); ; index++ {
+		plaintext, final, err := chunks.next()
+		if err != nil {
+			return err
+		}
+
+		chunk, err := c.encryptChunk(ctx, header.streamID, index, final, plaintext, config.groupIDs)
+		if err != nil {
+			return fmt.Errorf("encrypting chunk %d: %w", index, err)
+		}
+		if err := chunk.write(w); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// DecryptStream reads an encrypted stream from r and writes the plaintext to w. Plaintext is
+// written as soon as each chunk is decrypted, so if an error is returned, anything written to w
+// must be discarded.
+func (c *GenericClient) DecryptStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	header, err := readStreamHeader(r)
+	if err != nil {
+		return err
+	}
+
+	for index := uint64(0); ; index++ {
+		chunk, err := readStreamChunk(r, header.chunkSize)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := c.decryptChunk(ctx, header.streamID, index, chunk)
+		if err != nil {
+			return fmt.Errorf("decrypting chunk %d: %w", index, err)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+
+		if chunk.final {
+			return checkStreamEnd(r)
+		}
+	}
+}
+
+func (c *GenericClient) encryptChunk(ctx context.Context, streamID []byte, index uint64, final bool, plaintext []byte, groupIDs []string) (streamChunk, error) {
+	res, err := c.Generic.Encrypt(ctx, &pb.EncryptRequest{
+		Plaintext:      plaintext,
+		AssociatedData: chunkAssociatedData(streamID, index, final),
+		GroupIds:       groupIDs,
+	})
+	if err != nil {
+		return streamChunk{}, err
+	}
+	return streamChunk{final: final, objectID: res.ObjectId, ciphertext: res.Ciphertext}, nil
+}
+
+func (c *GenericClient) decryptChunk(ctx context.Context, streamID []byte, index uint64, chunk streamChunk) ([]byte, error) {
+	res, err := c.Generic.Decrypt(ctx, &pb.DecryptRequest{
+		Ciphertext:     chunk.ciphertext,
+		AssociatedData: chunkAssociatedData(streamID, index, chunk.final),
+		ObjectId:       chunk.objectID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// chunkAssociatedData returns the associated data of a chunk: "D1STRM", the version, the stream
+// ID, the chunk index and the final flag, followed by the total number of chunks for the final
+// chunk.
+func chunkAssociatedData(streamID []byte, index uint64, final bool) []byte {
+	ad := make([]byte, 0, len(streamMagic)+1+streamIDSize+8+1+8)
+	ad = append(ad, streamMagic...)
+	ad = append(ad, streamVersion)
+	ad = append(ad, streamID...)
+	ad = appendUint64(ad, index)
+	if final {
+		ad = append(ad, streamFinal)
+		ad = appendUint64(ad, index+1)
+	} else {
+		ad = append(ad, 0)
+	}
+	return ad
+}
+
+type streamHeader struct {
+	streamID  []byte
+	chunkSize int
+}
+
+func newStreamHeader(chunkSize int) (streamHeader, error) {
+	streamID := make([]byte, streamIDSize)
+	if _, err := rand.Read(streamID); err != nil {
+		return streamHeader{}, err
+	}
+	return streamHeader{streamID: streamID, chunkSize: chunkSize}, nil
+}
+
+func (h streamHeader) write(w io.Writer) error {
+	buf := make([]byte, 0, len(streamMagic)+1+streamIDSize+4)
+	buf = append(buf, streamMagic...)
+	buf = append(buf, streamVersion)
+	buf = append(buf, h.streamID...)
+	buf = appendUint32(buf, uint32(h.chunkSize))
+	_, err := w.Write(buf)
+	return err
+}
+
+func readStreamHeader(r io.Reader) (streamHeader, error) {
+	buf := make([]byte, len(streamMagic)+1+streamIDSize+4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return streamHeader{}, fmt.Errorf("%w: reading header: %v", ErrInvalidStream, err)
+	}
+	if !bytes.Equal(buf[:len(streamMagic)], []byte(streamMagic)) {
+		return streamHeader{}, fmt.Errorf("%w: bad magic", ErrInvalidStream)
+	}
+	buf = buf[len(streamMagic):]
+	if buf[0] != streamVersion {
+		return streamHeader{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidStream, buf[0])
+	}
+	header := streamHeader{
+		streamID:  buf[1 : 1+streamIDSize],
+		chunkSize: int(binary.BigEndian.Uint32(buf[1+streamIDSize:])),
+	}
+	if header.chunkSize <= 0 || header.chunkSize > MaxStreamChunkSize {
+		return streamHeader{}, fmt.Errorf("%w: invalid chunk size %d", ErrInvalidStream, header.chunkSize)
+	}
+	return header, nil
+}
+
+type streamChunk struct {
+	final      bool
+	objectID   string
+	ciphertext []byte
+}
+
+func (c streamChunk) write(w io.Writer) error {
+	buf := make([]byte, 0, 1+2+len(c.objectID)+4+len(c.ciphertext))
+	if c.final {
+		buf = append(buf, streamFinal)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = appendUint16(buf, uint16(len(c.objectID)))
+	buf = append(buf, c.objectID...)
+	buf = appendUint32(buf, uint32(len(c.ciphertext)))
+	buf = append(buf, c.ciphertext...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readStreamChunk reads the next chunk record. Reaching the end of r before the final chunk is
+// reported as ErrTruncatedStream.
+func readStreamChunk(r io.Reader, chunkSize int) (streamChunk, error) {
+	var chunk streamChunk
+
+	var prefix [3]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return chunk, truncated(err)
+	}
+	switch prefix[0] {
+	case 0:
+	case streamFinal:
+		chunk.final = true
+	default:
+		return chunk, fmt.Errorf("%w: unknown chunk flags %#x", ErrInvalidStream, prefix[0])
+	}
+
+	objectID := make([]byte, binary.BigEndian.Uint16(prefix[1:]))
+	if _, err := io.ReadFull(r, objectID); err != nil {
+		return chunk, truncated(err)
+	}
+	chunk.objectID = string(objectID)
+
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return chunk, truncated(err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > uint32(chunkSize+maxCiphertextOverhead) {
+		return chunk, fmt.Errorf("%w: chunk of %d bytes exceeds chunk size", ErrInvalidStream, size)
+	}
+	chunk.ciphertext = make([]byte, size)
+	if _, err := io.ReadFull(r, chunk.ciphertext); err != nil {
+		return chunk, truncated(err)
+	}
+	return chunk, nil
+}
+
+// checkStreamEnd verifies that nothing follows the final chunk.
+func checkStreamEnd(r io.Reader) error {
+	var trailing [1]byte
+	if n, _ := io.ReadFull(r, trailing[:]); n > 0 {
+		return fmt.Errorf("%w: data after final chunk", ErrInvalidStream)
+	}
+	return nil
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncatedStream
+	}
+	return err
+}
+
+// chunker splits a reader into chunks, reading one chunk ahead so the final chunk can be marked.
+type chunker struct {
+	r    io.Reader
+	size int
+	buf  []byte
+	err  error
+}
+
+func newChunker(r io.Reader, size int) *chunker {
+	c := &chunker{r: r, size: size}
+	c.fill()
+	return c
+}
+
+func (c *chunker) fill() {
+	c.buf = make([]byte, c.size)
+	n, err := io.ReadFull(c.r, c.buf)
+	c.buf, c.err = c.buf[:n], err
+}
+
+// next returns the next chunk and whether it is the last one. An empty reader yields a single
+// empty final chunk.
+func (c *chunker) next() ([]byte, bool, error) {
+	chunk, err := c.buf, c.err
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return chunk, true, nil
+	case err != nil:
+		return nil, false, err
+	}
+
+	c.fill()
+	if c.err == io.EOF {
+		return chunk, true, nil
+	}
+	return chunk, false, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+)
+
+const streamHeaderSize = 6 + 1 + 16 + 4
+
+// splitStream splits an encrypted stream into its header and chunk records.
+func splitStream(t *testing.T, data []byte) ([]byte, [][]byte) {
+	header, rest := data[:streamHeaderSize], data[streamHeaderSize:]
+	var records [][]byte
+	for len(rest) > 0 {
+		idLen := int(binary.BigEndian.Uint16(rest[1:]))
+		ctLen := int(binary.BigEndian.Uint32(rest[3+idLen:]))
+		size := 3 + idLen + 4 + ctLen
+		if size > len(rest) {
+			t.Fatal("malformed stream")
+		}
+		records = append(records, rest[:size])
+		rest = rest[size:]
+	}
+	return header, records
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	server, c, _ := newTestClient(t)
+	ctx := context.Background()
+	const chunkSize = 16
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, 3*chunkSize + 5} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+
+		encBefore, _ := server.GenericCalls()
+		var encrypted bytes.Buffer
+		if err := c.EncryptStream(ctx, bytes.NewReader(plaintext), &encrypted, client.WithChunkSize(chunkSize)); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		// Only an empty stream has an empty final chunk.
+		wantChunks := (size + chunkSize - 1) / chunkSize
+		if size == 0 {
+			wantChunks = 1
+		}
+		encAfter, _ := server.GenericCalls()
+		if got := encAfter - encBefore; got != wantChunks {
+			t.Fatalf("size %d: got %d chunks, want %d", size, got, wantChunks)
+		}
+
+		var decrypted bytes.Buffer
+		if err := c.DecryptStream(ctx, &encrypted, &decrypted); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Fatalf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	plaintext := bytes.Repeat([]byte("0123456789"), 5)
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(ctx, bytes.NewReader(plaintext), &encrypted, client.WithChunkSize(16)); err != nil {
+		t.Fatal(err)
+	}
+	header, records := splitStream(t, encrypted.Bytes())
+	if len(records) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(records))
+	}
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, parts...), nil)
+	}
+	var other bytes.Buffer
+	if err := c.EncryptStream(ctx, bytes.NewReader(plaintext), &other, client.WithChunkSize(16)); err != nil {
+		t.Fatal(err)
+	}
+	_, otherRecords := splitStream(t, other.Bytes())
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"dropped final chunk", join(records[0], records[1], records[2]), client.ErrTruncatedStream},
+		{"cut inside chunk", encrypted.Bytes()[:encrypted.Len()-1], client.ErrTruncatedStream},
+		{"trailing data", append(encrypted.Bytes(), 0), client.ErrInvalidStream},
+		{"bad magic", append([]byte("X"), encrypted.Bytes()[1:]...), client.ErrInvalidStream},
+		{"reordered chunks", join(records[1], records[0], records[2], records[3]), nil},
+		{"dropped middle chunk", join(records[0], records[2], records[3]), nil},
+		{"chunk from other stream", join(records[0], otherRecords[1], records[2], records[3]), nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := c.DecryptStream(ctx, bytes.NewReader(tc.data), &bytes.Buffer{})
+			if err == nil {
+				t.Fatal("expected decryption to fail")
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
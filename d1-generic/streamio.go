@@ -0,0 +1,315 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// chunkResult is the outcome of encrypting or decrypting a single chunk.
+type chunkResult[T any] struct {
+	value T
+	err   error
+}
+
+// EncryptingWriter is an io.WriteCloser which encrypts everything written to it and writes it to
+// an underlying writer as an encrypted stream, in the format used by EncryptStream. Chunks are
+// encrypted in the background, several at a time, and written in order.
+//
+// The stream is only complete once Close has returned without error. Close does not close the
+// underlying writer.
+type EncryptingWriter struct {
+	c      *GenericClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	config streamConfig
+	header streamHeader
+
+	buf    []byte
+	index  uint64
+	closed bool
+
+	// queue holds the results of dispatched chunks in stream order.
+	queue chan chan chunkResult[streamChunk]
+	done  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewEncryptingWriter returns an EncryptingWriter which writes an encrypted stream to w.
+func (c *GenericClient) NewEncryptingWriter(ctx context.Context, w io.Writer, opts ...StreamOption) (*EncryptingWriter, error) {
+	config, err := newStreamConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	header, err := newStreamHeader(config.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ew := &EncryptingWriter{
+		c:      c,
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
+		header: header,
+		queue:  make(chan chan chunkResult[streamChunk], config.concurrency),
+		done:   make(chan struct{}),
+	}
+	go ew.writeChunks(w)
+	return ew, nil
+}
+
+// Write starts encrypting every complete chunk of the data written so far. The last chunk is held
+// back until more data arrives or the writer is closed, since only then is it known whether it is
+// final. An error from an earlier chunk is returned by the next Write.
+func (ew *EncryptingWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, fmt.Errorf("write to closed EncryptingWriter")
+	}
+	if err := ew.error(); err != nil {
+		return 0, err
+	}
+
+	// Every byte of p is copied once, either into a chunk taken directly from p or into the buffer
+	// holding the chunk that is held back.
+	chunkSize := ew.config.chunkSize
+	n := len(p)
+	for len(p) > 0 {
+		if len(ew.buf) == chunkSize {
+			// More data follows, so the buffered chunk is not the final one.
+			ew.dispatch(ew.buf, false)
+			ew.buf = nil
+		}
+		if len(ew.buf) == 0 && len(p) > chunkSize {
+			chunk := make([]byte, chunkSize)
+			copy(chunk, p)
+			p = p[chunkSize:]
+			ew.dispatch(chunk, false)
+			continue
+		}
+		if ew.buf == nil {
+			ew.buf = make([]byte, 0, chunkSize)
+		}
+		size := chunkSize - len(ew.buf)
+		if size > len(p) {
+			size = len(p)
+		}
+		ew.buf = append(ew.buf, p[:size]...)
+		p = p[size:]
+	}
+	return n, nil
+}
+
+// Close encrypts the final chunk and waits until all chunks have been written. It returns the
+// first error encountered while encrypting or writing the stream.
+func (ew *EncryptingWriter) Close() error {
+	if !ew.closed {
+		ew.closed = true
+		if ew.error() == nil {
+			ew.dispatch(ew.buf, true)
+			ew.buf = nil
+		}
+		close(ew.queue)
+	}
+	<-ew.done
+	ew.cancel()
+	return ew.error()
+}
+
+// dispatch starts encrypting a chunk in the background. It blocks while the maximum number of
+// chunks are in flight.
+func (ew *EncryptingWriter) dispatch(plaintext []byte, final bool) {
+	result := make(chan chunkResult[streamChunk], 1)
+	ew.queue <- result
+
+	index := ew.index
+	ew.index++
+	go func() {
+		chunk, err := ew.c.encryptChunk(ew.ctx, ew.header.streamID, index, final, plaintext, ew.config.groupIDs)
+		if err != nil {
+			err = fmt.Errorf("encrypting chunk %d: %w", index, err)
+		}
+		result <- chunkResult[streamChunk]{chunk, err}
+	}()
+}
+
+// writeChunks writes the header and the encrypted chunks in order. After an error, the remaining
+// results are drained so that dispatch never blocks.
+func (ew *EncryptingWriter) writeChunks(w io.Writer) {
+	defer close(ew.done)
+
+	headerWritten := false
+	for result := range ew.queue {
+		res := <-result
+		if ew.error() != nil {
+			continue
+		}
+
+		err := res.err
+		if err == nil && !headerWritten {
+			err = ew.header.write(w)
+			headerWritten = true
+		}
+		if err == nil {
+			err = res.value.write(w)
+		}
+		if err != nil {
+			ew.fail(err)
+		}
+	}
+}
+
+func (ew *EncryptingWriter) fail(err error) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.err == nil {
+		ew.err = err
+		ew.cancel()
+	}
+}
+
+func (ew *EncryptingWriter) error() error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	return ew.err
+}
+
+// DecryptingReader is an io.ReadCloser which reads an encrypted stream, in the format used by
+// EncryptStream, from an underlying reader and returns the plaintext. Chunks are decrypted in the
+// background, several at a time, and returned in order.
+//
+// Read only returns io.EOF after the final chunk has been verified, so a truncated or tampered
+// stream always results in an error. Plaintext read before an error must be discarded. Close
+// stops the background work but does not close the underlying reader.
+type DecryptingReader struct {
+	cancel context.CancelFunc
+	// wg tracks the goroutine reading the stream and those decrypting chunks.
+	wg sync.WaitGroup
+
+	queue chan chan chunkResult[[]byte]
+	buf   []byte
+	err   error
+}
+
+// NewDecryptingReader returns a DecryptingReader which decrypts the encrypted stream read from r.
+// Only the concurrency of the options is used; the chunk size is read from the stream.
+func (c *GenericClient) NewDecryptingReader(ctx context.Context, r io.Reader, opts ...StreamOption) (*DecryptingReader, error) {
+	config, err := newStreamConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	dr := &DecryptingReader{
+		cancel: cancel,
+		queue:  make(chan chan chunkResult[[]byte], config.concurrency),
+	}
+	dr.wg.Add(1)
+	go dr.readChunks(ctx, c, r)
+	return dr, nil
+}
+
+// Read implements io.Reader.
+func (dr *DecryptingReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		result, ok := <-dr.queue
+		if !ok {
+			dr.err = io.EOF
+			continue
+		}
+		res := <-result
+		dr.buf, dr.err = res.value, res.err
+	}
+
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// Close stops decrypting and waits for the background work to finish, which includes a read from
+// the underlying reader in progress. Subsequent reads fail.
+func (dr *DecryptingReader) Close() error {
+	dr.cancel()
+	dr.wg.Wait()
+	if dr.err == nil || dr.err == io.EOF {
+		dr.err = fmt.Errorf("read from closed DecryptingReader")
+	}
+	dr.buf = nil
+	return nil
+}
+
+// readChunks parses the stream and starts decrypting each chunk in the background. Parse errors
+// are queued as results so they are returned in stream order.
+func (dr *DecryptingReader) readChunks(ctx context.Context, c *GenericClient, r io.Reader) {
+	defer dr.wg.Done()
+	defer close(dr.queue)
+
+	enqueue := func(res chunkResult[[]byte]) bool {
+		result := make(chan chunkResult[[]byte], 1)
+		result <- res
+		select {
+		case dr.queue <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	header, err := readStreamHeader(r)
+	if err != nil {
+		enqueue(chunkResult[[]byte]{err: err})
+		return
+	}
+
+	for index := uint64(0); ; index++ {
+		chunk, err := readStreamChunk(r, header.chunkSize)
+		if err != nil {
+			enqueue(chunkResult[[]byte]{err: err})
+			return
+		}
+
+		result := make(chan chunkResult[[]byte], 1)
+		select {
+		case dr.queue <- result:
+		case <-ctx.Done():
+			return
+		}
+		dr.wg.Add(1)
+		go func(index uint64, chunk streamChunk) {
+			defer dr.wg.Done()
+			plaintext, err := c.decryptChunk(ctx, header.streamID, index, chunk)
+			if err != nil {
+				err = fmt.Errorf("decrypting chunk %d: %w", index, err)
+			}
+			result <- chunkResult[[]byte]{plaintext, err}
+		}(index, chunk)
+
+		if chunk.final {
+			if err := checkStreamEnd(r); err != nil {
+				enqueue(chunkResult[[]byte]{err: err})
+			}
+			return
+		}
+	}
+}
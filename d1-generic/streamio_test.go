@@ -0,0 +1,234 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestEncryptingWriterWithGzip(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+	opts := []client.StreamOption{client.WithChunkSize(64), client.WithStreamConcurrency(3)}
+
+	plaintext := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 200)
+
+	var encrypted bytes.Buffer
+	ew, err := c.NewEncryptingWriter(ctx, &encrypted, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(ew)
+	// Write in uneven pieces to exercise buffering.
+	for rest := plaintext; len(rest) > 0; {
+		n := 37
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := zw.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer produces the same format as EncryptStream.
+	var compressed bytes.Buffer
+	if err := c.DecryptStream(ctx, bytes.NewReader(encrypted.Bytes()), &compressed); err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := c.NewDecryptingReader(ctx, bytes.NewReader(encrypted.Bytes()), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+	zr, err := gzip.NewReader(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("plaintext mismatch")
+	}
+}
+
+func TestEncryptingWriterErrors(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	ew, err := c.NewEncryptingWriter(ctx, failingWriter{}, client.WithChunkSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ew.Write(make([]byte, 100)); err != nil && err.Error() != "disk full" {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected write error from Close, got %v", err)
+	}
+
+	ew, err = c.NewEncryptingWriter(ctx, io.Discard, client.WithStreamGroups("group-missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ew.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err == nil {
+		t.Fatal("expected encryption error from Close")
+	}
+	if _, err := ew.Write([]byte("more")); err == nil {
+		t.Fatal("expected write after close to fail")
+	}
+}
+
+func TestDecryptingReaderTruncated(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(ctx, bytes.NewReader(make([]byte, 100)), &encrypted, client.WithChunkSize(16)); err != nil {
+		t.Fatal(err)
+	}
+	_, records := splitStream(t, encrypted.Bytes())
+	cut := encrypted.Len() - len(records[len(records)-1])
+
+	dr, err := c.NewDecryptingReader(ctx, bytes.NewReader(encrypted.Bytes()[:cut]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+	if _, err := io.ReadAll(dr); !errors.Is(err, client.ErrTruncatedStream) {
+		t.Fatalf("expected truncation error, got %v", err)
+	}
+}
+
+func TestEncryptingWriterChunkBoundaries(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	for _, size := range []int{96, 100} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+		var expected bytes.Buffer
+		if err := c.EncryptStream(ctx, bytes.NewReader(plaintext), &expected, client.WithChunkSize(16)); err != nil {
+			t.Fatal(err)
+		}
+		_, expectedRecords := splitStream(t, expected.Bytes())
+
+		for _, writeSize := range []int{1, 15, 16, 17, 40, size} {
+			var encrypted bytes.Buffer
+			ew, err := c.NewEncryptingWriter(ctx, &encrypted, client.WithChunkSize(16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rest := plaintext; len(rest) > 0; {
+				n := writeSize
+				if n > len(rest) {
+					n = len(rest)
+				}
+				if _, err := ew.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := ew.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The chunks are the same as those of EncryptStream, however the data is written.
+			if _, records := splitStream(t, encrypted.Bytes()); len(records) != len(expectedRecords) {
+				t.Fatalf("size %d, writes of %d: expected %d chunks, got %d", size, writeSize, len(expectedRecords), len(records))
+			}
+			var decrypted bytes.Buffer
+			if err := c.DecryptStream(ctx, &encrypted, &decrypted); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Fatalf("size %d, writes of %d: plaintext mismatch", size, writeSize)
+			}
+		}
+	}
+}
+
+// closeCheckingReader fails the test if it is read after closed is set.
+type closeCheckingReader struct {
+	t      *testing.T
+	r      io.Reader
+	closed int32
+}
+
+func (r *closeCheckingReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.closed) != 0 {
+		r.t.Error("read after Close returned")
+	}
+	return r.r.Read(p)
+}
+
+func TestDecryptingReaderClose(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(ctx, bytes.NewReader(make([]byte, 1000)), &encrypted, client.WithChunkSize(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &closeCheckingReader{t: t, r: iotest.OneByteReader(&encrypted)}
+	dr, err := c.NewDecryptingReader(ctx, r, client.WithStreamConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dr.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&r.closed, 1)
+	// Give a goroutine left running the chance to read.
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := dr.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected read after close to fail")
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+)
+
+// EncryptStruct encrypts the fields of the struct pointed to by v that are tagged with
+// `d1:"encrypt"`. Tagged fields are encrypted in place, and untagged fields holding structs,
+// pointers, slices, arrays or maps are searched for further tagged fields.
+//
+// The tag takes the following comma separated options after "encrypt":
+//
+//	target=Field   store the envelope in the sibling field Field and clear the tagged field
+//	groups=A+B     give the groups in the sibling fields A and B, of type string or []string,
+//	               access to the encrypted field
+//	ad=A+B         bind the encrypted field to the values of the sibling fields A and B
+//
+// Without a target, the tagged field must be a string or []byte and is replaced by the envelope,
+// in text form for strings and binary form for byte slices. With a target, the tagged field may
+// have any type that can be marshalled to JSON, and the target must be a string, []byte or
+// *envelope.Envelope.
+//
+// The associated data of every encrypted field contains the struct type, the field name and the
+// values of the fields listed in ad, so an envelope cannot be moved to another field or to a field
+// of the same name in another struct type, and decryption fails if any of the listed fields have
+// been changed. Renaming or moving the struct type to another package therefore prevents
+// decryption of its existing envelopes.
+func (c *GenericClient) EncryptStruct(ctx context.Context, v interface{}) error {
+	return c.walkStruct(ctx, v, c.encryptField)
+}
+
+// ErrNotEncrypted is returned by DecryptStruct for a tagged field which is not empty but does not
+// hold an envelope.
+var ErrNotEncrypted = errors.New("field is not encrypted")
+
+// StructOption is used to configure DecryptStruct.
+type StructOption func(*structConfig)
+
+type structConfig struct {
+	allowPlaintext bool
+}
+
+// WithPlaintextFields returns a StructOption which leaves tagged fields that do not hold an
+// envelope unchanged instead of failing with ErrNotEncrypted. It is meant for migrating data
+// written before the fields were encrypted, and should not be used once the migration is done, as
+// it lets a plaintext replace an encrypted value unnoticed.
+func WithPlaintextFields() StructOption {
+	return func(c *structConfig) {
+		c.allowPlaintext = true
+	}
+}
+
+// DecryptStruct decrypts the fields of the struct pointed to by v that were encrypted by
+// EncryptStruct. Tagged fields which are empty are left unchanged. A tagged field which does not
+// hold an envelope fails with ErrNotEncrypted unless WithPlaintextFields is given, and envelopes
+// that fail to parse or decrypt are always reported as errors.
+func (c *GenericClient) DecryptStruct(ctx context.Context, v interface{}, opts ...StructOption) error {
+	var config structConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return c.walkStruct(ctx, v, func(ctx context.Context, parent reflect.Value, spec *fieldSpec) error {
+		return c.decryptField(ctx, parent, spec, &config)
+	})
+}
+
+type fieldFunc func(ctx context.Context, parent reflect.Value, spec *fieldSpec) error
+
+func (c *GenericClient) walkStruct(ctx context.Context, v interface{}, fn fieldFunc) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("value must be a non-nil pointer")
+	}
+	w := &structWalker{fn: fn, seen: map[visit]bool{}}
+	return w.walk(ctx, rv.Elem())
+}
+
+// visit identifies memory reached through a pointer, map or slice, so that values shared or
+// referenced in a cycle are processed only once.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+type structWalker struct {
+	fn   fieldFunc
+	seen map[visit]bool
+}
+
+// enter reports whether the memory has not been visited before, and marks it as visited.
+func (w *structWalker) enter(v reflect.Value, length int) bool {
+	key := visit{v.Pointer(), v.Type(), length}
+	if w.seen[key] {
+		return false
+	}
+	w.seen[key] = true
+	return true
+}
+
+func (w *structWalker) walk(ctx context.Context, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() && w.enter(v, 0) {
+			return w.walk(ctx, v.Elem())
+		}
+	case reflect.Struct:
+		specs, err := structSpecs(v.Type())
+		if err != nil {
+			return err
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if spec, ok := specs[i]; ok {
+				if err := w.fn(ctx, v, spec); err != nil {
+					return fmt.Errorf("%s.%s: %w", v.Type().Name(), field.Name, err)
+				}
+				continue
+			}
+			if err := w.walk(ctx, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if !mayContainStructs(v.Type().Elem()) {
+			return nil
+		}
+		if v.Kind() == reflect.Slice && (v.Len() == 0 || !w.enter(v, v.Len())) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := w.walk(ctx, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !mayContainStructs(v.Type().Elem()) || v.IsNil() || !w.enter(v, 0) {
+			return nil
+		}
+		// Map values are not addressable, so each value is processed as a copy and stored back.
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := w.walk(ctx, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+func mayContainStructs(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return t != reflect.TypeOf([]byte(nil))
+	}
+	return false
+}
+
+func (c *GenericClient) encryptField(ctx context.Context, parent reflect.Value, spec *fieldSpec) error {
+	field := parent.Field(spec.index)
+
+	var plaintext []byte
+	switch {
+	case field.Kind() == reflect.String:
+		plaintext = []byte(field.String())
+	case field.Type() == bytesType:
+		plaintext = field.Bytes()
+	default:
+		var err error
+		if plaintext, err = json.Marshal(field.Interface()); err != nil {
+			return err
+		}
+	}
+
+	associatedData, err := spec.associatedData(parent)
+	if err != nil {
+		return err
+	}
+	env, err := envelope.Encrypt(ctx, c.Generic, plaintext, associatedData, envelope.WithGroups(spec.groupIDs(parent)...))
+	if err != nil {
+		return err
+	}
+
+	if spec.target < 0 {
+		return setEnvelope(field, env)
+	}
+	if err := setEnvelope(parent.Field(spec.target), env); err != nil {
+		return err
+	}
+	field.Set(reflect.Zero(field.Type()))
+	return nil
+}
+
+func (c *GenericClient) decryptField(ctx context.Context, parent reflect.Value, spec *fieldSpec, config *structConfig) error {
+	field := parent.Field(spec.index)
+	source := field
+	if spec.target >= 0 {
+		source = parent.Field(spec.target)
+	}
+
+	env, err := getEnvelope(source)
+	if errors.Is(err, ErrNotEncrypted) && config.allowPlaintext {
+		return nil
+	}
+	if err != nil || env == nil {
+		return err
+	}
+	// The associated data is derived from the current field values rather than taken from the
+	// envelope, so that changes to the bound fields are detected.
+	if env.AssociatedData, err = spec.associatedData(parent); err != nil {
+		return err
+	}
+	plaintext, err := env.Decrypt(ctx, c.Generic)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(string(plaintext))
+	case field.Type() == bytesType:
+		field.SetBytes(plaintext)
+	default:
+		value := reflect.New(field.Type())
+		if err := json.Unmarshal(plaintext, value.Interface()); err != nil {
+			return err
+		}
+		field.Set(value.Elem())
+	}
+
+	if spec.target >= 0 {
+		source.Set(reflect.Zero(source.Type()))
+	}
+	return nil
+}
+
+var (
+	bytesType    = reflect.TypeOf([]byte(nil))
+	envelopeType = reflect.TypeOf((*envelope.Envelope)(nil))
+)
+
+func setEnvelope(dst reflect.Value, env *envelope.Envelope) error {
+	switch {
+	case dst.Kind() == reflect.String:
+		text, err := env.MarshalText()
+		if err != nil {
+			return err
+		}
+		dst.SetString(string(text))
+	case dst.Type() == bytesType:
+		data, err := envelope.Marshal(env)
+		if err != nil {
+			return err
+		}
+		dst.SetBytes(data)
+	default:
+		dst.Set(reflect.ValueOf(env))
+	}
+	return nil
+}
+
+// getEnvelope returns the envelope held by src, or nil if src is empty. It returns
+// ErrNotEncrypted if src is not empty but does not hold an envelope.
+func getEnvelope(src reflect.Value) (*envelope.Envelope, error) {
+	switch {
+	case src.Kind() == reflect.String:
+		if src.Len() == 0 {
+			return nil, nil
+		}
+		if !envelope.IsEnvelope([]byte(src.String())) {
+			return nil, ErrNotEncrypted
+		}
+		env := &envelope.Envelope{}
+		return env, env.UnmarshalText([]byte(src.String()))
+	case src.Type() == bytesType:
+		if src.Len() == 0 {
+			return nil, nil
+		}
+		if !envelope.IsEnvelope(src.Bytes()) {
+			return nil, ErrNotEncrypted
+		}
+		return envelope.Unmarshal(src.Bytes())
+	default:
+		if src.IsNil() {
+			return nil, nil
+		}
+		env := *src.Interface().(*envelope.Envelope)
+		return &env, nil
+	}
+}
+
+// fieldSpec is the parsed d1 tag of a field.
+type fieldSpec struct {
+	index  int
+	typ    string
+	name   string
+	target int
+	groups []int
+	ad     []int
+	adName []string
+}
+
+// associatedData returns the associated data of the field: a JSON object containing the struct
+// type, the field name and the values of the bound fields.
+func (s *fieldSpec) associatedData(parent reflect.Value) ([]byte, error) {
+	values := make(map[string]interface{}, len(s.ad))
+	for i, index := range s.ad {
+		values[s.adName[i]] = parent.Field(index).Interface()
+	}
+	return json.Marshal(struct {
+		Type   string                 `json:"type"`
+		Field  string                 `json:"field"`
+		Values map[string]interface{} `json:"values,omitempty"`
+	}{s.typ, s.name, values})
+}
+
+func (s *fieldSpec) groupIDs(parent reflect.Value) []string {
+	var groupIDs []string
+	for _, index := range s.groups {
+		switch field := parent.Field(index); field.Kind() {
+		case reflect.String:
+			if field.Len() > 0 {
+				groupIDs = append(groupIDs, field.String())
+			}
+		default:
+			groupIDs = append(groupIDs, field.Interface().([]string)...)
+		}
+	}
+	return groupIDs
+}
+
+// specCache holds the parsed tags of each struct type, keyed by reflect.Type.
+var specCache sync.Map
+
+func structSpecs(t reflect.Type) (map[int]*fieldSpec, error) {
+	if specs, ok := specCache.Load(t); ok {
+		return specs.(map[int]*fieldSpec), nil
+	}
+
+	specs := map[int]*fieldSpec{}
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("d1")
+		if !ok {
+			continue
+		}
+		spec, err := parseFieldTag(t, i, tag)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), t.Field(i).Name, err)
+		}
+		specs[i] = spec
+	}
+
+	specCache.Store(t, specs)
+	return specs, nil
+}
+
+func parseFieldTag(t reflect.Type, index int, tag string) (*fieldSpec, error) {
+	field := t.Field(index)
+	if !field.IsExported() {
+		return nil, errors.New("d1 tag on unexported field")
+	}
+
+	options := strings.Split(tag, ",")
+	if options[0] != "encrypt" {
+		return nil, fmt.Errorf("unsupported d1 tag %q", tag)
+	}
+
+	spec := &fieldSpec{index: index, typ: typeName(t), name: field.Name, target: -1}
+	for _, option := range options[1:] {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "target":
+			target, ok := t.FieldByName(value)
+			if !ok || len(target.Index) != 1 || !target.IsExported() {
+				return nil, fmt.Errorf("unknown target field %q", value)
+			}
+			if target.Type.Kind() != reflect.String && target.Type != bytesType && target.Type != envelopeType {
+				return nil, fmt.Errorf("target field %s must be a string, []byte or *envelope.Envelope", value)
+			}
+			spec.target = target.Index[0]
+		case "groups":
+			for _, name := range strings.Split(value, "+") {
+				group, ok := t.FieldByName(name)
+				if !ok || len(group.Index) != 1 || !group.IsExported() {
+					return nil, fmt.Errorf("unknown groups field %q", name)
+				}
+				if group.Type.Kind() != reflect.String && group.Type != reflect.TypeOf([]string(nil)) {
+					return nil, fmt.Errorf("groups field %s must be a string or []string", name)
+				}
+				spec.groups = append(spec.groups, group.Index[0])
+			}
+		case "ad":
+			for _, name := range strings.Split(value, "+") {
+				ad, ok := t.FieldByName(name)
+				if !ok || len(ad.Index) != 1 || !ad.IsExported() {
+					return nil, fmt.Errorf("unknown associated data field %q", name)
+				}
+				if _, tagged := ad.Tag.Lookup("d1"); tagged || ad.Index[0] == index {
+					return nil, fmt.Errorf("associated data field %s must not be encrypted", name)
+				}
+				spec.ad = append(spec.ad, ad.Index[0])
+				spec.adName = append(spec.adName, name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown d1 tag option %q", option)
+		}
+	}
+
+	if spec.target == index {
+		return nil, errors.New("field cannot be its own target")
+	}
+	for _, ad := range spec.ad {
+		if ad == spec.target {
+			return nil, errors.New("target field cannot be used as associated data")
+		}
+	}
+	if spec.target < 0 && field.Type.Kind() != reflect.String && field.Type != bytesType {
+		return nil, errors.New("field encrypted in place must be a string or []byte")
+	}
+	return spec, nil
+}
+
+// typeName returns the name of t qualified by its package path, or the type literal for an
+// unnamed struct.
+func typeName(t reflect.Type) string {
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
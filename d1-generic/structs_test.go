@@ -0,0 +1,244 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/envelope"
+)
+
+type address struct {
+	Street string `d1:"encrypt"`
+	City   string
+}
+
+type birthDate struct {
+	Year, Month, Day int
+}
+
+type patient struct {
+	ID      string
+	Team    string
+	Readers []string
+
+	SSN       string    `d1:"encrypt,groups=Team+Readers,ad=ID"`
+	Notes     []byte    `d1:"encrypt"`
+	Birth     birthDate `d1:"encrypt,target=BirthEnv,ad=ID"`
+	BirthEnv  *envelope.Envelope
+	Salary    int `d1:"encrypt,target=SalaryEnc"`
+	SalaryEnc string
+
+	Home     address
+	Previous []address
+	ByName   map[string]*address
+	Next     *address
+}
+
+func TestEncryptStruct(t *testing.T) {
+	server, c, _ := newTestClient(t)
+	groups := newGroups(t, c, 2)
+	ctx := context.Background()
+
+	newPatient := func() patient {
+		return patient{
+			ID:       "p-1",
+			Team:     groups[0],
+			Readers:  []string{groups[1]},
+			SSN:      "123-45-6789",
+			Notes:    []byte("allergic to penicillin"),
+			Birth:    birthDate{1980, 4, 1},
+			Salary:   50000,
+			Home:     address{Street: "1 Main St", City: "Copenhagen"},
+			Previous: []address{{Street: "2 Side St", City: "Aarhus"}},
+			ByName:   map[string]*address{"work": {Street: "3 Office Rd", City: "Odense"}},
+			Next:     &address{Street: "4 New St", City: "Aalborg"},
+		}
+	}
+	p := newPatient()
+
+	if err := c.EncryptStruct(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SSN == "123-45-6789" || p.Home.Street == "1 Main St" || p.Previous[0].Street == "2 Side St" ||
+		p.ByName["work"].Street == "3 Office Rd" || p.Next.Street == "4 New St" {
+		t.Fatal("field was not encrypted")
+	}
+	if p.Birth != (birthDate{}) || p.BirthEnv == nil || p.Salary != 0 || p.SalaryEnc == "" {
+		t.Fatal("field with target was not moved to its target")
+	}
+	if p.Home.City != "Copenhagen" {
+		t.Fatal("untagged field was changed")
+	}
+
+	var env envelope.Envelope
+	if err := env.UnmarshalText([]byte(p.SSN)); err != nil {
+		t.Fatal(err)
+	}
+	objectGroups := server.ObjectGroups(env.ObjectID)
+	for _, gid := range groups {
+		if !contains(objectGroups, gid) {
+			t.Fatalf("group %s has no access to the encrypted field: %v", gid, objectGroups)
+		}
+	}
+
+	encrypted := p
+	if err := c.DecryptStruct(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := newPatient(); !reflect.DeepEqual(p, want) {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+
+	// Changing a field bound as associated data makes decryption fail.
+	encrypted.ID = "p-2"
+	encrypted.Home, encrypted.Previous, encrypted.ByName, encrypted.Next = address{}, nil, nil, nil
+	if err := c.DecryptStruct(ctx, &encrypted); err == nil {
+		t.Fatal("expected decryption to fail after changing associated data")
+	}
+}
+
+func TestEncryptStructMovedEnvelope(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	a := address{Street: "1 Main St"}
+	if err := c.EncryptStruct(ctx, &a); err != nil {
+		t.Fatal(err)
+	}
+
+	// An envelope copied into another encrypted field does not decrypt.
+	type other struct {
+		Secret string `d1:"encrypt"`
+	}
+	o := other{Secret: a.Street}
+	if err := c.DecryptStruct(ctx, &o); err == nil {
+		t.Fatal("expected decryption of moved envelope to fail")
+	}
+
+	// So does an envelope copied into a field of the same name in another struct type.
+	type otherAddress struct {
+		Street string `d1:"encrypt"`
+	}
+	oa := otherAddress{Street: a.Street}
+	if err := c.DecryptStruct(ctx, &oa); err == nil {
+		t.Fatal("expected decryption of envelope moved to another type to fail")
+	}
+}
+
+type listNode struct {
+	Value string `d1:"encrypt"`
+	Next  *listNode
+}
+
+func TestEncryptStructCycles(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	// A cyclic list is walked once, and a shared node is encrypted once.
+	a := &listNode{Value: "a"}
+	b := &listNode{Value: "b", Next: a}
+	a.Next = b
+	nodes := []*listNode{a, b, a}
+	if err := c.EncryptStruct(ctx, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if a.Value == "a" || b.Value == "b" {
+		t.Fatal("field was not encrypted")
+	}
+	if err := c.DecryptStruct(ctx, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if a.Value != "a" || b.Value != "b" {
+		t.Fatalf("unexpected values %q, %q", a.Value, b.Value)
+	}
+}
+
+func TestDecryptStructPlaintext(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	// Empty fields are left as they are.
+	var empty address
+	if err := c.DecryptStruct(ctx, &empty); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields that were never encrypted are an error, unless plaintext is explicitly allowed.
+	a := address{Street: "1 Main St", City: "Copenhagen"}
+	if err := c.DecryptStruct(ctx, &a); !errors.Is(err, client.ErrNotEncrypted) {
+		t.Fatalf("expected ErrNotEncrypted, got %v", err)
+	}
+	if err := c.DecryptStruct(ctx, &a, client.WithPlaintextFields()); err != nil {
+		t.Fatal(err)
+	}
+	if a.Street != "1 Main St" {
+		t.Fatalf("plaintext field was changed to %q", a.Street)
+	}
+
+	// A damaged envelope is still an error, even when plaintext is allowed.
+	if err := c.EncryptStruct(ctx, &a); err != nil {
+		t.Fatal(err)
+	}
+	a.Street = a.Street[:len(a.Street)-8]
+	if err := c.DecryptStruct(ctx, &a, client.WithPlaintextFields()); err == nil {
+		t.Fatal("expected decryption of a damaged envelope to fail")
+	}
+}
+
+func TestEncryptStructInvalidTags(t *testing.T) {
+	_, c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	tests := []interface{}{
+		&struct {
+			N int `d1:"encrypt"`
+		}{},
+		&struct {
+			S string `d1:"encrypt,target=Missing"`
+		}{},
+		&struct {
+			S string `d1:"encrypt,groups=N"`
+			N int
+		}{},
+		&struct {
+			S string `d1:"encrypt,compress"`
+		}{},
+		&struct {
+			S string `d1:"sign"`
+		}{},
+	}
+	for _, v := range tests {
+		if err := c.EncryptStruct(ctx, v); err == nil {
+			t.Errorf("expected error for %T", v)
+		}
+	}
+	if err := c.EncryptStruct(ctx, address{}); err == nil {
+		t.Error("expected error for non-pointer")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
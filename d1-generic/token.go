@@ -12,16 +12,12 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Code generated by copy-client.sh. DO NOT EDIT.
-// version: v2.0.0
-// source: https://github.com/cybercryptio/d1-service-generic.git
-// commit: 05a3b04a1916d3e6c1bfea65041b7536cf93778e
-
 package client
 
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
@@ -65,16 +61,27 @@ func (getToken perRPCToken) RequireTransportSecurity() bool {
 // NewStandalonePerRPCToken creates a new instance of PerRPCToken to be used with the Standalone ID Provider.
 // It requires the transport credentials used to communicate with the D1 Service in order to call the Login endpoint.
 func newStandalonePerRPCToken(c *BaseClient, uid, pwd string) perRPCToken {
+	return newRefreshingPerRPCToken(c, uid, func() string { return pwd })
+}
+
+// newRefreshingPerRPCToken creates a PerRPCToken which logs in with the password returned by
+// password, and logs in again before the token expires. Calls may be made concurrently, so the
+// token is refreshed by one call at a time.
+func newRefreshingPerRPCToken(c *BaseClient, uid string, password func() string) perRPCToken {
+	var mu sync.Mutex
 	var token string
 	var tokenExpiry time.Time
 	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
 		// To avoid clock drift issues, refresh the token if it will expire within 1 minute.
 		if time.Now().After(tokenExpiry.Add(time.Duration(-1) * time.Minute)) {
 			res, err := c.Authn.LoginUser(
 				ctx,
 				&pbauthn.LoginUserRequest{
 					UserId:   uid,
-					Password: pwd,
+					Password: password(),
 				},
 			)
 			if err != nil {
@@ -88,9 +95,11 @@ func newStandalonePerRPCToken(c *BaseClient, uid, pwd string) perRPCToken {
 	}
 }
 
-// WithTokenRefresh returns an Option that configures token refresh.
+// WithTokenRefresh returns an Option that configures token refresh. The token is also used by
+// ExplainAccess and WithPolicy to read the caller's claims.
 func WithTokenRefresh(uid, pwd string) Option {
 	return func(bc *BaseClient) grpc.DialOption {
-		return grpc.WithPerRPCCredentials(newStandalonePerRPCToken(bc, uid, pwd))
+		bc.state.tokenSource = newStandalonePerRPCToken(bc, uid, pwd)
+		return grpc.WithPerRPCCredentials(bc.state.tokenSource)
 	}
 }
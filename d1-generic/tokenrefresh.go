@@ -0,0 +1,48 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"unsafe"
+
+	"google.golang.org/grpc"
+)
+
+// newSecretPerRPCToken is like newStandalonePerRPCToken, but keeps the password as SecretBytes.
+func newSecretPerRPCToken(c *BaseClient, uid string, pwd *SecretBytes) perRPCToken {
+	return newRefreshingPerRPCToken(c, uid, func() string { return secretString(pwd.Bytes()) })
+}
+
+// secretString returns a string sharing memory with b, so a password can be passed to the login
+// request without leaving a copy behind. The string must not be kept after the call, since the
+// memory is zeroed when the SecretBytes is destroyed.
+func secretString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// WithTokenRefreshSecret returns an Option that configures token refresh with a password held as
+// SecretBytes. The password is never copied to a string, so it is gone once the SecretBytes is
+// destroyed, which must not happen while the client is in use. WithTokenRefresh, in contrast, keeps
+// the password as a string for the lifetime of the client, so only WithTokenRefreshSecret protects
+// it.
+//
+// Like WithTokenRefresh, the client can be used concurrently, and the token is also used by
+// ExplainAccess and WithPolicy to read the caller's claims.
+func WithTokenRefreshSecret(uid string, pwd *SecretBytes) Option {
+	return func(bc *BaseClient) grpc.DialOption {
+		bc.state.tokenSource = newSecretPerRPCToken(bc, uid, pwd)
+		return grpc.WithPerRPCCredentials(bc.state.tokenSource)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	gclient "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+)
+
+// StoreBatch stores every request as described for gclient.Batch.
+func (c *StorageClient) StoreBatch(ctx context.Context, requests []*pb.StoreRequest, opts ...gclient.BatchOption) ([]gclient.BatchResult[*pb.StoreResponse], error) {
+	return gclient.Batch(ctx, requests, c.store, opts...)
+}
+
+// StoreBatchStream stores every request received from requests as described for
+// gclient.BatchStream.
+func (c *StorageClient) StoreBatchStream(ctx context.Context, requests <-chan *pb.StoreRequest, opts ...gclient.BatchOption) <-chan gclient.BatchResult[*pb.StoreResponse] {
+	return gclient.BatchStream(ctx, requests, c.store, opts...)
+}
+
+// RetrieveBatch retrieves every request as described for gclient.Batch.
+func (c *StorageClient) RetrieveBatch(ctx context.Context, requests []*pb.RetrieveRequest, opts ...gclient.BatchOption) ([]gclient.BatchResult[*pb.RetrieveResponse], error) {
+	return gclient.Batch(ctx, requests, c.retrieve, opts...)
+}
+
+// RetrieveBatchStream retrieves every request received from requests as described for
+// gclient.BatchStream.
+func (c *StorageClient) RetrieveBatchStream(ctx context.Context, requests <-chan *pb.RetrieveRequest, opts ...gclient.BatchOption) <-chan gclient.BatchResult[*pb.RetrieveResponse] {
+	return gclient.BatchStream(ctx, requests, c.retrieve, opts...)
+}
+
+func (c *StorageClient) store(ctx context.Context, request *pb.StoreRequest) (*pb.StoreResponse, error) {
+	return c.Storage.Store(ctx, request)
+}
+
+func (c *StorageClient) retrieve(ctx context.Context, request *pb.RetrieveRequest) (*pb.RetrieveResponse, error) {
+	return c.Storage.Retrieve(ctx, request)
+}
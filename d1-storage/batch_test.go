@@ -0,0 +1,154 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	gclient "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	client "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func newBatchClient(t *testing.T) client.StorageClient {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewStorageClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestStorageBatch(t *testing.T) {
+	c := newBatchClient(t)
+	ctx := context.Background()
+
+	var storeRequests []*pbstorage.StoreRequest
+	for i := 0; i < 20; i++ {
+		storeRequests = append(storeRequests, &pbstorage.StoreRequest{Plaintext: []byte(fmt.Sprint(i))})
+	}
+	stored, err := c.StoreBatch(ctx, storeRequests, gclient.WithBatchConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retrieve in reverse order to check that results follow the requests.
+	var retrieveRequests []*pbstorage.RetrieveRequest
+	for i := len(stored) - 1; i >= 0; i-- {
+		if stored[i].Index != i || stored[i].Err != nil {
+			t.Fatalf("unexpected result %d: %+v", i, stored[i])
+		}
+		retrieveRequests = append(retrieveRequests, &pbstorage.RetrieveRequest{ObjectId: stored[i].Response.ObjectId})
+	}
+	retrieved, err := c.RetrieveBatch(ctx, retrieveRequests, gclient.WithBatchConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range retrieved {
+		if result.Index != i || string(result.Response.Plaintext) != fmt.Sprint(len(retrieved)-1-i) {
+			t.Fatalf("result %d out of order: %+v", i, result)
+		}
+	}
+}
+
+func TestStorageBatchPartialFailure(t *testing.T) {
+	c := newBatchClient(t)
+	ctx := context.Background()
+
+	stored, err := c.StoreBatch(ctx, []*pbstorage.StoreRequest{
+		{Plaintext: []byte("a")},
+		{Plaintext: []byte("b"), GroupIds: []string{"unknown"}},
+		{Plaintext: []byte("c")},
+	})
+	var batchErr *gclient.BatchError
+	if !errors.As(err, &batchErr) || fmt.Sprint(batchErr.Failed) != "[1]" || batchErr.Total != 3 {
+		t.Fatalf("expected item 1 to fail, got %v", err)
+	}
+	if stored[0].Err != nil || stored[2].Err != nil || stored[1].Response != nil {
+		t.Fatalf("unexpected results: %+v", stored)
+	}
+
+	retrieved, err := c.RetrieveBatch(ctx, []*pbstorage.RetrieveRequest{
+		{ObjectId: "unknown"},
+		{ObjectId: stored[2].Response.ObjectId},
+		{ObjectId: stored[0].Response.ObjectId},
+	})
+	if !errors.As(err, &batchErr) || fmt.Sprint(batchErr.Failed) != "[0]" {
+		t.Fatalf("expected item 0 to fail, got %v", err)
+	}
+	if string(retrieved[1].Response.Plaintext) != "c" || string(retrieved[2].Response.Plaintext) != "a" {
+		t.Fatalf("unexpected results: %+v", retrieved)
+	}
+}
+
+func TestStorageBatchStream(t *testing.T) {
+	c := newBatchClient(t)
+	ctx := context.Background()
+
+	storeRequests := make(chan *pbstorage.StoreRequest)
+	go func() {
+		defer close(storeRequests)
+		for i := 0; i < 10; i++ {
+			storeRequests <- &pbstorage.StoreRequest{Plaintext: []byte(fmt.Sprint(i))}
+		}
+	}()
+
+	var objectIDs []string
+	for result := range c.StoreBatchStream(ctx, storeRequests, gclient.WithBatchConcurrency(3)) {
+		if result.Index != len(objectIDs) || result.Err != nil {
+			t.Fatalf("unexpected result %d: %+v", len(objectIDs), result)
+		}
+		objectIDs = append(objectIDs, result.Response.ObjectId)
+	}
+	if len(objectIDs) != 10 {
+		t.Fatalf("expected 10 results, got %d", len(objectIDs))
+	}
+
+	// Every other request refers to an unknown object.
+	retrieveRequests := make(chan *pbstorage.RetrieveRequest)
+	go func() {
+		defer close(retrieveRequests)
+		for i, oid := range objectIDs {
+			if i%2 == 1 {
+				oid = "unknown"
+			}
+			retrieveRequests <- &pbstorage.RetrieveRequest{ObjectId: oid}
+		}
+	}()
+
+	n := 0
+	for result := range c.RetrieveBatchStream(ctx, retrieveRequests, gclient.WithBatchConcurrency(3)) {
+		if result.Index != n {
+			t.Fatalf("result %d out of order: %+v", n, result)
+		}
+		if n%2 == 1 {
+			if result.Err == nil {
+				t.Fatalf("expected result %d to fail", n)
+			}
+		} else if result.Err != nil || string(result.Response.Plaintext) != fmt.Sprint(n) {
+			t.Fatalf("unexpected result %d: %+v", n, result)
+		}
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 results, got %d", n)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	gclient "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	client "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+)
+
+// PropagationError is returned when a change to a parent was applied, but could not be propagated
+// to all of its children. Propagate can be used to retry.
+type PropagationError struct {
+	ParentID string
+	Failed   []gclient.BulkResult
+}
+
+func (e *PropagationError) Error() string {
+	return fmt.Sprintf("access change on %s could not be propagated to %d children, first error: %v", e.ParentID, len(e.Failed), e.Failed[0].Err)
+}
+
+// Manager manages collections of stored objects. It is safe for concurrent use.
+type Manager struct {
+	mu          sync.Mutex
+	client      *client.StorageClient
+	store       Store
+	collections map[string][]string
+	bulkOptions []gclient.BulkOption
+}
+
+// Option is used to configure optional settings on the manager.
+type Option func(*Manager)
+
+// WithBulkOptions returns an Option which configures how permission changes are propagated to
+// children, e.g. their concurrency.
+func WithBulkOptions(opts ...gclient.BulkOption) Option {
+	return func(m *Manager) {
+		m.bulkOptions = opts
+	}
+}
+
+// NewManager creates a Manager using the given client and store.
+func NewManager(c *client.StorageClient, store Store, opts ...Option) (*Manager, error) {
+	collections, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		client:      c,
+		store:       store,
+		collections: collections,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+// Create stores a new parent object with the given contents and groups, and returns its ID.
+func (m *Manager) Create(ctx context.Context, req *pb.StoreRequest) (string, error) {
+	res, err := m.client.Storage.Store(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collections[res.ObjectId] = []string{}
+	if err := m.store.Save(m.collections); err != nil {
+		return "", err
+	}
+	return res.ObjectId, nil
+}
+
+// Store stores a new child of the parent. The child is given access for the parent's groups in
+// addition to those in the request.
+func (m *Manager) Store(ctx context.Context, parentID string, req *pb.StoreRequest) (*pb.StoreResponse, error) {
+	groupIDs, err := m.parentGroups(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.client.Storage.Store(ctx, &pb.StoreRequest{
+		Plaintext:      req.Plaintext,
+		AssociatedData: req.AssociatedData,
+		GroupIds:       union(req.GroupIds, groupIDs),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.addChild(parentID, res.ObjectId); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Register adds an existing object to the collection and grants it the parent's groups.
+func (m *Manager) Register(ctx context.Context, parentID, childID string) error {
+	groupIDs, err := m.parentGroups(ctx, parentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.client.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: childID, GroupIds: groupIDs})
+	if err != nil {
+		return err
+	}
+
+	return m.addChild(parentID, childID)
+}
+
+// Unregister removes an object from the collection. Its ACL is left unchanged.
+func (m *Manager) Unregister(parentID, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children, ok := m.collections[parentID]
+	if !ok {
+		return fmt.Errorf("unknown collection %s", parentID)
+	}
+	m.collections[parentID] = without(children, childID)
+	return m.store.Save(m.collections)
+}
+
+// Delete deletes a child from D1 Storage and removes it from the collection.
+func (m *Manager) Delete(ctx context.Context, parentID, childID string) error {
+	if _, err := m.client.Storage.Delete(ctx, &pb.DeleteRequest{ObjectId: childID}); err != nil {
+		return err
+	}
+	return m.Unregister(parentID, childID)
+}
+
+// AddPermission gives the groups access to the parent and all of its children.
+func (m *Manager) AddPermission(ctx context.Context, parentID string, groupIDs []string) error {
+	if _, err := m.children(parentID); err != nil {
+		return err
+	}
+	_, err := m.client.Authz.AddPermission(ctx, &pbauthz.AddPermissionRequest{ObjectId: parentID, GroupIds: groupIDs})
+	if err != nil {
+		return err
+	}
+	return m.propagate(ctx, parentID, groupIDs, m.client.GrantAll)
+}
+
+// RemovePermission removes the groups' access to the parent and all of its children.
+func (m *Manager) RemovePermission(ctx context.Context, parentID string, groupIDs []string) error {
+	if _, err := m.children(parentID); err != nil {
+		return err
+	}
+	_, err := m.client.Authz.RemovePermission(ctx, &pbauthz.RemovePermissionRequest{ObjectId: parentID, GroupIds: groupIDs})
+	if err != nil {
+		return err
+	}
+	return m.propagate(ctx, parentID, groupIDs, m.client.RevokeAll)
+}
+
+// Propagate grants the parent's current groups to all of its children, repairing children that
+// missed an earlier change.
+func (m *Manager) Propagate(ctx context.Context, parentID string) error {
+	groupIDs, err := m.parentGroups(ctx, parentID)
+	if err != nil {
+		return err
+	}
+	return m.propagate(ctx, parentID, groupIDs, m.client.GrantAll)
+}
+
+// Children returns the IDs of the children of a parent.
+func (m *Manager) Children(parentID string) ([]string, error) {
+	return m.children(parentID)
+}
+
+// ObjectIDs returns the IDs of all parents and children known to the manager, so a Manager can be
+// used as a source of objects for an ACL audit.
+func (m *Manager) ObjectIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var objectIDs []string
+	for parentID, children := range m.collections {
+		objectIDs = append(objectIDs, parentID)
+		objectIDs = append(objectIDs, children...)
+	}
+	sort.Strings(objectIDs)
+	return objectIDs
+}
+
+type bulkFunc func(context.Context, <-chan string, []string, ...gclient.BulkOption) (<-chan gclient.BulkResult, error)
+
+func (m *Manager) propagate(ctx context.Context, parentID string, groupIDs []string, bulk bulkFunc) error {
+	children, err := m.children(parentID)
+	if err != nil {
+		return err
+	}
+
+	results, err := bulk(ctx, gclient.ObjectIDsFromSlice(children), groupIDs, m.bulkOptions...)
+	if err != nil {
+		return err
+	}
+
+	var failed []gclient.BulkResult
+	for result := range results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &PropagationError{ParentID: parentID, Failed: failed}
+	}
+	return nil
+}
+
+func (m *Manager) parentGroups(ctx context.Context, parentID string) ([]string, error) {
+	if _, err := m.children(parentID); err != nil {
+		return nil, err
+	}
+	res, err := m.client.Authz.GetPermissions(ctx, &pbauthz.GetPermissionsRequest{ObjectId: parentID})
+	if err != nil {
+		return nil, err
+	}
+	return res.GroupIds, nil
+}
+
+func (m *Manager) children(parentID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children, ok := m.collections[parentID]
+	if !ok {
+		return nil, fmt.Errorf("unknown collection %s", parentID)
+	}
+	return append([]string(nil), children...), nil
+}
+
+func (m *Manager) addChild(parentID, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children, ok := m.collections[parentID]
+	if !ok {
+		return fmt.Errorf("unknown collection %s", parentID)
+	}
+	for _, id := range children {
+		if id == childID {
+			return nil
+		}
+	}
+	m.collections[parentID] = append(children, childID)
+	return m.store.Save(m.collections)
+}
+
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, list := range [][]string{a, b} {
+		for _, v := range list {
+			if !seen[v] {
+				seen[v] = true
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+func without(list []string, value string) []string {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != value {
+			out = append(out, v)
+		}
+	}
+	return out
+}
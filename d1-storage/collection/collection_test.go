@@ -0,0 +1,114 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collection_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	"github.com/cybercryptio/d1-client-go/v2/d1-storage/collection"
+	pb "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestCollectionInheritance(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	g1, _ := server.NewUser()
+	g2, _ := server.NewUser()
+
+	c, err := client.NewStorageClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	store := collection.NewFileStore(filepath.Join(t.TempDir(), "collections.json"))
+	m, err := collection.NewManager(&c, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	parent, err := m.Create(ctx, &pb.StoreRequest{Plaintext: []byte("folder"), GroupIds: []string{g1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var children []string
+	for i := 0; i < 3; i++ {
+		res, err := m.Store(ctx, parent, &pb.StoreRequest{Plaintext: []byte("document")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, res.ObjectId)
+	}
+
+	// An existing object can be added to the collection.
+	existing := server.NewObject(uid)
+	if err := m.Register(ctx, parent, existing); err != nil {
+		t.Fatal(err)
+	}
+	children = append(children, existing)
+
+	expectACLs := func(expected ...string) {
+		t.Helper()
+		sort.Strings(expected)
+		for _, oid := range append([]string{parent}, children...) {
+			if groups := server.ObjectGroups(oid); !reflect.DeepEqual(groups, expected) {
+				t.Fatalf("unexpected ACL for %s: %v, expected %v", oid, groups, expected)
+			}
+		}
+	}
+	expectACLs(uid, g1)
+
+	if err := m.AddPermission(ctx, parent, []string{g2}); err != nil {
+		t.Fatal(err)
+	}
+	expectACLs(uid, g1, g2)
+
+	if err := m.RemovePermission(ctx, parent, []string{g1}); err != nil {
+		t.Fatal(err)
+	}
+	expectACLs(uid, g2)
+
+	// The collection survives a restart.
+	m, err = collection.NewManager(&c, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Children(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, children) {
+		t.Fatalf("unexpected children %v, expected %v", got, children)
+	}
+
+	if err := m.Delete(ctx, parent, children[0]); err != nil {
+		t.Fatal(err)
+	}
+	if ids := m.ObjectIDs(); len(ids) != 4 {
+		t.Fatalf("unexpected objects: %v", ids)
+	}
+
+	if _, err := m.Store(ctx, "unknown", &pb.StoreRequest{}); err == nil {
+		t.Fatal("expected storing into an unknown collection to fail")
+	}
+}
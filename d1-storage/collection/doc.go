@@ -0,0 +1,30 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package collection groups objects stored in D1 Storage under a parent, such as a folder, whose ACL
+applies to all of its children.
+
+D1 ACLs are per object, so inheritance is emulated client-side. The parent is itself a stored
+object, and its ACL is the source of truth for the collection:
+
+  - children stored through a Manager are given the parent's groups via StoreRequest.GroupIds,
+  - existing objects registered as children are granted the parent's groups,
+  - groups added to or removed from the parent through a Manager are added to or removed from all
+    children.
+
+Which objects belong to which parent is kept in a Store. Access granted directly on a child is left
+alone, unless the same group is later removed from the parent.
+*/
+package collection
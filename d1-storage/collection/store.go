@@ -0,0 +1,126 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the children of every collection, as a map from parent ID to child IDs.
+type Store interface {
+	// Load returns the persisted collections, or an empty map if nothing has been saved yet.
+	Load() (map[string][]string, error)
+	// Save replaces the persisted collections.
+	Save(map[string][]string) error
+}
+
+// MemoryStore is a Store that keeps collections in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// Load implements Store.
+func (m *MemoryStore) Load() (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return decode(m.data)
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(collections map[string][]string) error {
+	data, err := json.Marshal(collections)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = data
+	return nil
+}
+
+// FileStore is a Store that keeps collections in a JSON file. The file is replaced atomically on
+// every save.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore creates a FileStore backed by the file at the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load implements Store. A missing file is treated as an empty store.
+func (f *FileStore) Load() (map[string][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Save implements Store.
+func (f *FileStore) Save(collections map[string][]string) error {
+	data, err := json.MarshalIndent(collections, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func decode(data []byte) (map[string][]string, error) {
+	collections := map[string][]string{}
+	if len(data) == 0 {
+		return collections, nil
+	}
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-storage"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+	"github.com/cybercryptio/d1-client-go/v2/internal/d1test"
+)
+
+func TestRetrieveSecret(t *testing.T) {
+	server := d1test.NewServer(t)
+	uid, pwd := server.NewUser(d1test.AllScopes()...)
+	c, err := client.NewStorageClient("bufnet", server.ClientOptions(uid, pwd)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	stored, err := c.Storage.Store(ctx, &pbstorage.StoreRequest{Plaintext: []byte("plaintext"), AssociatedData: []byte("context")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, associatedData, err := c.RetrieveSecret(ctx, &pbstorage.RetrieveRequest{ObjectId: stored.ObjectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Bytes()) != "plaintext" || string(associatedData) != "context" {
+		t.Fatalf("unexpected retrieval: %q, %q", s.Bytes(), associatedData)
+	}
+	data := s.Bytes()
+	s.Destroy()
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Fatal("plaintext was not zeroed")
+	}
+
+	if _, _, err := c.RetrieveSecret(ctx, &pbstorage.RetrieveRequest{ObjectId: "unknown"}); err == nil {
+		t.Fatal("expected an error for an unknown object")
+	}
+}
@@ -3,6 +3,7 @@ module github.com/cybercryptio/d1-client-go/v2
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.15.9
 	google.golang.org/grpc v1.47.0
 	google.golang.org/protobuf v1.28.0
 )
@@ -0,0 +1,135 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package checkpoint records which items of a long running job have been completed, so the job can
+// be resumed after an interruption.
+package checkpoint
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// File is a checkpoint backed by a text file. The first line holds a signature describing the
+// job, and every following line holds the key of a completed item.
+type File struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	done   map[string]bool
+}
+
+// Open opens the checkpoint file at path, creating it if it does not exist. The signature must
+// match the one the file was created with, which prevents resuming a different job by accident.
+// Keys must not contain newlines.
+func Open(path, signature string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &File{file: file, done: map[string]bool{}}
+	if err := c.load(signature); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.writer = bufio.NewWriter(file)
+
+	return c, nil
+}
+
+func (c *File) load(signature string) error {
+	scanner := bufio.NewScanner(c.file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		// A new checkpoint starts with the signature.
+		_, err := io.WriteString(c.file, "#"+signature+"\n")
+		return err
+	}
+
+	if scanner.Text() != "#"+signature {
+		return errors.New("checkpoint belongs to a different job")
+	}
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			c.done[key] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	_, err := c.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Len returns the number of completed items.
+func (c *File) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// Done reports whether the item has been completed.
+func (c *File) Done(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[key]
+}
+
+// Mark records the item as completed. The record reaches the operating system before Mark
+// returns, but is only synced to disk by Close.
+func (c *File) Mark(key string) error {
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("invalid checkpoint key %q", key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done[key] {
+		return nil
+	}
+	if _, err := c.writer.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	c.done[key] = true
+	return nil
+}
+
+// Close syncs and closes the checkpoint file.
+func (c *File) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.writer.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	if err := c.file.Sync(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
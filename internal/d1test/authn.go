@@ -0,0 +1,130 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+)
+
+type authnServer struct {
+	pbauthn.UnimplementedAuthnServer
+	s *Server
+}
+
+func (a *authnServer) CreateUser(ctx context.Context, req *pbauthn.CreateUserRequest) (*pbauthn.CreateUserResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, err := a.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	uid, pwd := a.s.newUser(req.Scopes)
+	return &pbauthn.CreateUserResponse{UserId: uid, Password: pwd}, nil
+}
+
+func (a *authnServer) LoginUser(_ context.Context, req *pbauthn.LoginUserRequest) (*pbauthn.LoginUserResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	u, ok := a.s.users[req.UserId]
+	if !ok || u.password != req.Password {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	return &pbauthn.LoginUserResponse{
+		AccessToken: a.s.newToken(req.UserId, expiry),
+		ExpiryTime:  expiry.Unix(),
+	}, nil
+}
+
+func (a *authnServer) RemoveUser(ctx context.Context, req *pbauthn.RemoveUserRequest) (*pbauthn.RemoveUserResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, err := a.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := a.s.users[req.UserId]; !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	delete(a.s.users, req.UserId)
+	delete(a.s.groups, req.UserId)
+	return &pbauthn.RemoveUserResponse{}, nil
+}
+
+func (a *authnServer) CreateGroup(ctx context.Context, req *pbauthn.CreateGroupRequest) (*pbauthn.CreateGroupResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	uid, err := a.s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gid := a.s.newID("group")
+	a.s.groups[gid] = &group{scopes: scopeSet(req.Scopes)}
+	a.s.users[uid].groups[gid] = true
+	return &pbauthn.CreateGroupResponse{GroupId: gid}, nil
+}
+
+func (a *authnServer) AddUserToGroups(ctx context.Context, req *pbauthn.AddUserToGroupsRequest) (*pbauthn.AddUserToGroupsResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, err := a.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	u, ok := a.s.users[req.UserId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	for _, gid := range req.GroupIds {
+		if _, ok := a.s.groups[gid]; !ok {
+			return nil, status.Errorf(codes.NotFound, "group %s not found", gid)
+		}
+	}
+
+	for _, gid := range req.GroupIds {
+		u.groups[gid] = true
+	}
+	return &pbauthn.AddUserToGroupsResponse{}, nil
+}
+
+func (a *authnServer) RemoveUserFromGroups(ctx context.Context, req *pbauthn.RemoveUserFromGroupsRequest) (*pbauthn.RemoveUserFromGroupsResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, err := a.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	u, ok := a.s.users[req.UserId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	for _, gid := range req.GroupIds {
+		delete(u.groups, gid)
+	}
+	return &pbauthn.RemoveUserFromGroupsResponse{}, nil
+}
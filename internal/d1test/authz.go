@@ -0,0 +1,142 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+type authzServer struct {
+	pbauthz.UnimplementedAuthzServer
+	s *Server
+}
+
+// NewObject creates an object directly on the server, accessible to the given groups.
+func (s *Server) NewObject(groupIDs ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newObject(groupIDs)
+}
+
+// ObjectGroups returns the groups with access to an object, ordered by ID.
+func (s *Server) ObjectGroups(oid string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := keys(s.acls[oid])
+	sort.Strings(groups)
+	return groups
+}
+
+// newObject creates an object ACL. The caller must hold s.mu.
+func (s *Server) newObject(groupIDs []string) string {
+	oid := s.newID("object")
+	s.acls[oid] = map[string]bool{}
+	for _, gid := range groupIDs {
+		s.acls[oid][gid] = true
+	}
+	return oid
+}
+
+// authorize checks that the caller is authenticated with the required scopes and has access to the
+// object. The caller must hold s.mu.
+func (s *Server) authorize(ctx context.Context, oid string, required ...scopes.Scope) error {
+	uid, err := s.authenticate(ctx, required...)
+	if err != nil {
+		return err
+	}
+	if !s.hasAccess(uid, oid) {
+		return status.Error(codes.PermissionDenied, "access denied")
+	}
+	return nil
+}
+
+// hasAccess reports whether the user is in one of the object's groups. The caller must hold s.mu.
+func (s *Server) hasAccess(uid, oid string) bool {
+	acl, ok := s.acls[oid]
+	if !ok {
+		return false
+	}
+	for gid := range s.users[uid].groups {
+		if acl[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authzServer) GetPermissions(ctx context.Context, req *pbauthz.GetPermissionsRequest) (*pbauthz.GetPermissionsResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if err := a.s.authorize(ctx, req.ObjectId, scopes.Scope_GETACCESS); err != nil {
+		return nil, err
+	}
+
+	groups := keys(a.s.acls[req.ObjectId])
+	sort.Strings(groups)
+	return &pbauthz.GetPermissionsResponse{GroupIds: groups}, nil
+}
+
+func (a *authzServer) AddPermission(ctx context.Context, req *pbauthz.AddPermissionRequest) (*pbauthz.AddPermissionResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if err := a.s.authorize(ctx, req.ObjectId, scopes.Scope_MODIFYACCESS); err != nil {
+		return nil, err
+	}
+	for _, gid := range req.GroupIds {
+		if _, ok := a.s.groups[gid]; !ok {
+			return nil, status.Errorf(codes.NotFound, "group %s not found", gid)
+		}
+	}
+
+	for _, gid := range req.GroupIds {
+		a.s.acls[req.ObjectId][gid] = true
+	}
+	return &pbauthz.AddPermissionResponse{}, nil
+}
+
+func (a *authzServer) RemovePermission(ctx context.Context, req *pbauthz.RemovePermissionRequest) (*pbauthz.RemovePermissionResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if err := a.s.authorize(ctx, req.ObjectId, scopes.Scope_MODIFYACCESS); err != nil {
+		return nil, err
+	}
+
+	for _, gid := range req.GroupIds {
+		delete(a.s.acls[req.ObjectId], gid)
+	}
+	return &pbauthz.RemovePermissionResponse{}, nil
+}
+
+func (a *authzServer) CheckPermission(ctx context.Context, req *pbauthz.CheckPermissionRequest) (*pbauthz.CheckPermissionResponse, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	uid, err := a.s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbauthz.CheckPermissionResponse{HasPermission: a.s.hasAccess(uid, req.ObjectId)}, nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package d1test provides an in-memory fake of the CYBERCRYPT D1 services for use in tests.
+
+The fake is served over an in-process gRPC listener and only emulates the parts of the D1 semantics
+that the client packages in this repository rely on.
+*/
+package d1test
@@ -0,0 +1,121 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbgeneric "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+// genericServer encrypts with a single AES-GCM key. The object ID and the associated data are
+// authenticated, so decryption fails if either is changed.
+type genericServer struct {
+	pbgeneric.UnimplementedGenericServer
+	s    *Server
+	aead cipher.AEAD
+
+	encryptCalls int
+	decryptCalls int
+}
+
+func newAEAD() cipher.AEAD {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// GenericCalls returns the number of Encrypt and Decrypt calls handled by the Generic service.
+func (s *Server) GenericCalls() (encrypt, decrypt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.genericServer.encryptCalls, s.genericServer.decryptCalls
+}
+
+func (g *genericServer) Encrypt(ctx context.Context, req *pbgeneric.EncryptRequest) (*pbgeneric.EncryptResponse, error) {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+
+	uid, err := g.s.authenticate(ctx, scopes.Scope_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	for _, gid := range req.GroupIds {
+		if _, ok := g.s.groups[gid]; !ok {
+			return nil, status.Errorf(codes.NotFound, "group %s not found", gid)
+		}
+	}
+	g.encryptCalls++
+
+	oid := g.s.newObject(append([]string{uid}, req.GroupIds...))
+	nonce := make([]byte, g.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	ciphertext := g.aead.Seal(nonce, nonce, req.Plaintext, additionalData(oid, req.AssociatedData))
+
+	return &pbgeneric.EncryptResponse{
+		ObjectId:       oid,
+		Ciphertext:     ciphertext,
+		AssociatedData: req.AssociatedData,
+	}, nil
+}
+
+func (g *genericServer) Decrypt(ctx context.Context, req *pbgeneric.DecryptRequest) (*pbgeneric.DecryptResponse, error) {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+
+	if err := g.s.authorize(ctx, req.ObjectId, scopes.Scope_READ); err != nil {
+		return nil, err
+	}
+	g.decryptCalls++
+
+	nonceSize := g.aead.NonceSize()
+	if len(req.Ciphertext) < nonceSize {
+		return nil, status.Error(codes.InvalidArgument, "ciphertext too short")
+	}
+	nonce, ciphertext := req.Ciphertext[:nonceSize], req.Ciphertext[nonceSize:]
+	plaintext, err := g.aead.Open(nil, nonce, ciphertext, additionalData(req.ObjectId, req.AssociatedData))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed")
+	}
+
+	return &pbgeneric.DecryptResponse{
+		Plaintext:      plaintext,
+		AssociatedData: req.AssociatedData,
+	}, nil
+}
+
+func additionalData(oid string, associatedData []byte) []byte {
+	data := append([]byte(oid), 0)
+	return append(data, associatedData...)
+}
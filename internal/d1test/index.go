@@ -0,0 +1,90 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+	"sort"
+
+	pbindex "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/index"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+)
+
+// indexServer keeps a single secure index shared by all users.
+type indexServer struct {
+	pbindex.UnimplementedIndexServer
+	s *Server
+
+	// keywords maps each keyword to its identifiers.
+	keywords map[string]map[string]bool
+}
+
+// IndexSize returns the number of keyword and identifier pairs in the secure index.
+func (s *Server) IndexSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, ids := range s.indexServer.keywords {
+		n += len(ids)
+	}
+	return n
+}
+
+func (ix *indexServer) Add(ctx context.Context, req *pbindex.AddRequest) (*pbindex.AddResponse, error) {
+	ix.s.mu.Lock()
+	defer ix.s.mu.Unlock()
+
+	if _, err := ix.s.authenticate(ctx, scopes.Scope_INDEX); err != nil {
+		return nil, err
+	}
+	for _, keyword := range req.Keywords {
+		if ix.keywords[keyword] == nil {
+			ix.keywords[keyword] = map[string]bool{}
+		}
+		ix.keywords[keyword][req.Identifier] = true
+	}
+	return &pbindex.AddResponse{}, nil
+}
+
+func (ix *indexServer) Search(ctx context.Context, req *pbindex.SearchRequest) (*pbindex.SearchResponse, error) {
+	ix.s.mu.Lock()
+	defer ix.s.mu.Unlock()
+
+	if _, err := ix.s.authenticate(ctx, scopes.Scope_INDEX); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for id := range ix.keywords[req.Keyword] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return &pbindex.SearchResponse{Identifiers: ids}, nil
+}
+
+func (ix *indexServer) Delete(ctx context.Context, req *pbindex.DeleteRequest) (*pbindex.DeleteResponse, error) {
+	ix.s.mu.Lock()
+	defer ix.s.mu.Unlock()
+
+	if _, err := ix.s.authenticate(ctx, scopes.Scope_INDEX); err != nil {
+		return nil, err
+	}
+	for _, keyword := range req.Keywords {
+		delete(ix.keywords[keyword], req.Identifier)
+		if len(ix.keywords[keyword]) == 0 {
+			delete(ix.keywords, keyword)
+		}
+	}
+	return &pbindex.DeleteResponse{}, nil
+}
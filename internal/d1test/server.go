@@ -0,0 +1,306 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	client "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pbauthn "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authn"
+	pbauthz "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/authz"
+	pbgeneric "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/generic"
+	pbindex "github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/index"
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+)
+
+const bufferSize = 1024 * 1024
+
+type user struct {
+	password string
+	scopes   map[scopes.Scope]bool
+	groups   map[string]bool
+}
+
+type group struct {
+	scopes map[scopes.Scope]bool
+}
+
+// Server is a fake D1 server.
+type Server struct {
+	mu     sync.Mutex
+	nextID int
+	users  map[string]*user
+	groups map[string]*group
+	acls   map[string]map[string]bool
+	stored map[string]*storedObject
+
+	listener *bufconn.Listener
+	server   *grpc.Server
+
+	authnServer
+	authzServer
+	genericServer
+	indexServer
+	storageServer
+}
+
+// NewServer starts a new fake D1 server, configured with the given gRPC server options. The server
+// is stopped when the test finishes.
+func NewServer(t testing.TB, opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		users:    map[string]*user{},
+		groups:   map[string]*group{},
+		acls:     map[string]map[string]bool{},
+		stored:   map[string]*storedObject{},
+		listener: bufconn.Listen(bufferSize),
+		server:   grpc.NewServer(opts...),
+	}
+	s.authnServer.s = s
+	s.authzServer.s = s
+	s.genericServer.s = s
+	s.genericServer.aead = newAEAD()
+	s.indexServer.s = s
+	s.indexServer.keywords = map[string]map[string]bool{}
+	s.storageServer.s = s
+
+	pbauthn.RegisterAuthnServer(s.server, &s.authnServer)
+	pbauthz.RegisterAuthzServer(s.server, &s.authzServer)
+	pbgeneric.RegisterGenericServer(s.server, &s.genericServer)
+	pbindex.RegisterIndexServer(s.server, &s.indexServer)
+	pbstorage.RegisterStorageServer(s.server, &s.storageServer)
+
+	go func() {
+		_ = s.server.Serve(s.listener)
+	}()
+	t.Cleanup(s.server.Stop)
+
+	return s
+}
+
+// DialOptions returns the gRPC options needed to connect to the server. The endpoint passed to
+// grpc.Dial is ignored.
+func (s *Server) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+}
+
+// ClientOptions returns the client options needed to connect to the server as the given user.
+func (s *Server) ClientOptions(uid, pwd string) []client.Option {
+	opts := []client.Option{client.WithTokenRefresh(uid, pwd)}
+	for _, opt := range s.DialOptions() {
+		opts = append(opts, client.WithGrpcOption(opt))
+	}
+	return opts
+}
+
+// NewUser creates a user with the given scopes directly on the server and returns its credentials.
+func (s *Server) NewUser(userScopes ...scopes.Scope) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newUser(userScopes)
+}
+
+// HasUser reports whether the user exists.
+func (s *Server) HasUser(uid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[uid]
+	return ok
+}
+
+// HasGroup reports whether the group exists.
+func (s *Server) HasGroup(gid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.groups[gid]
+	return ok
+}
+
+// UserGroups returns the groups of a user, including the user's own group.
+func (s *Server) UserGroups(uid string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[uid]
+	if !ok {
+		return nil
+	}
+	return keys(u.groups)
+}
+
+// AddUserToGroups adds a user to groups directly on the server.
+func (s *Server) AddUserToGroups(uid string, gids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, gid := range gids {
+		s.users[uid].groups[gid] = true
+	}
+}
+
+// AllScopes returns every scope known to D1.
+func AllScopes() []scopes.Scope {
+	return []scopes.Scope{
+		scopes.Scope_READ,
+		scopes.Scope_CREATE,
+		scopes.Scope_GETACCESS,
+		scopes.Scope_MODIFYACCESS,
+		scopes.Scope_UPDATE,
+		scopes.Scope_DELETE,
+		scopes.Scope_INDEX,
+	}
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%04d", prefix, s.nextID)
+}
+
+// newUser creates a user and its personal group. The caller must hold s.mu.
+func (s *Server) newUser(userScopes []scopes.Scope) (string, string) {
+	uid := s.newID("user")
+	pwd := "password-" + uid
+	s.users[uid] = &user{
+		password: pwd,
+		scopes:   scopeSet(userScopes),
+		groups:   map[string]bool{uid: true},
+	}
+	s.groups[uid] = &group{scopes: scopeSet(userScopes)}
+	return uid, pwd
+}
+
+// authenticate returns the ID of the calling user. The caller must hold s.mu.
+func (s *Server) authenticate(ctx context.Context, required ...scopes.Scope) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", status.Error(codes.InvalidArgument, "missing authorization header")
+	}
+
+	uid, err := parseToken(strings.TrimPrefix(values[0], "bearer "))
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "invalid access token")
+	}
+	u, ok := s.users[uid]
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	for _, scope := range required {
+		if !s.hasScope(u, scope) {
+			return "", status.Errorf(codes.PermissionDenied, "missing scope %s", scope)
+		}
+	}
+
+	return uid, nil
+}
+
+// Token returns a valid access token for the user, for tests that attach tokens manually.
+func (s *Server) Token(uid string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newToken(uid, time.Now().Add(time.Hour))
+}
+
+// newToken issues an unsigned JWT for the user carrying its scopes. The caller must hold s.mu.
+func (s *Server) newToken(uid string, expiry time.Time) string {
+	var names []string
+	if u, ok := s.users[uid]; ok {
+		for _, scope := range AllScopes() {
+			if s.hasScope(u, scope) {
+				names = append(names, scope.String())
+			}
+		}
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"sub":    uid,
+		"exp":    expiry.Unix(),
+		"scopes": names,
+	})
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims) + "."
+}
+
+// parseToken returns the subject of a token issued by newToken.
+func parseToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+		Expiry  int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", err
+	}
+	if time.Now().After(time.Unix(claims.Expiry, 0)) {
+		return "", errors.New("token expired")
+	}
+	return claims.Subject, nil
+}
+
+// hasScope reports whether any of the user's groups grant the scope. The caller must hold s.mu.
+func (s *Server) hasScope(u *user, scope scopes.Scope) bool {
+	if u.scopes[scope] {
+		return true
+	}
+	for gid := range u.groups {
+		if g, ok := s.groups[gid]; ok && g.scopes[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+func scopeSet(list []scopes.Scope) map[scopes.Scope]bool {
+	set := map[scopes.Scope]bool{}
+	for _, scope := range list {
+		set[scope] = true
+	}
+	return set
+}
+
+func keys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for key := range set {
+		list = append(list, key)
+	}
+	return list
+}
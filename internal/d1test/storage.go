@@ -0,0 +1,113 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package d1test
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cybercryptio/d1-client-go/v2/d1-generic/protobuf/scopes"
+	pbstorage "github.com/cybercryptio/d1-client-go/v2/d1-storage/protobuf/storage"
+)
+
+type storedObject struct {
+	plaintext      []byte
+	associatedData []byte
+}
+
+type storageServer struct {
+	pbstorage.UnimplementedStorageServer
+	s *Server
+}
+
+// StoredObjects returns the number of objects held by the Storage service.
+func (s *Server) StoredObjects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stored)
+}
+
+func (st *storageServer) Store(ctx context.Context, req *pbstorage.StoreRequest) (*pbstorage.StoreResponse, error) {
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+
+	uid, err := st.s.authenticate(ctx, scopes.Scope_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	for _, gid := range req.GroupIds {
+		if _, ok := st.s.groups[gid]; !ok {
+			return nil, status.Errorf(codes.NotFound, "group %s not found", gid)
+		}
+	}
+
+	oid := st.s.newObject(append([]string{uid}, req.GroupIds...))
+	st.s.stored[oid] = &storedObject{
+		plaintext:      append([]byte(nil), req.Plaintext...),
+		associatedData: append([]byte(nil), req.AssociatedData...),
+	}
+	return &pbstorage.StoreResponse{ObjectId: oid}, nil
+}
+
+func (st *storageServer) Retrieve(ctx context.Context, req *pbstorage.RetrieveRequest) (*pbstorage.RetrieveResponse, error) {
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+
+	if err := st.s.authorize(ctx, req.ObjectId, scopes.Scope_READ); err != nil {
+		return nil, err
+	}
+	obj, ok := st.s.stored[req.ObjectId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "object not found")
+	}
+
+	return &pbstorage.RetrieveResponse{
+		Plaintext:      append([]byte(nil), obj.plaintext...),
+		AssociatedData: append([]byte(nil), obj.associatedData...),
+	}, nil
+}
+
+func (st *storageServer) Update(ctx context.Context, req *pbstorage.UpdateRequest) (*pbstorage.UpdateResponse, error) {
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+
+	if err := st.s.authorize(ctx, req.ObjectId, scopes.Scope_UPDATE); err != nil {
+		return nil, err
+	}
+	if _, ok := st.s.stored[req.ObjectId]; !ok {
+		return nil, status.Error(codes.NotFound, "object not found")
+	}
+
+	st.s.stored[req.ObjectId] = &storedObject{
+		plaintext:      append([]byte(nil), req.Plaintext...),
+		associatedData: append([]byte(nil), req.AssociatedData...),
+	}
+	return &pbstorage.UpdateResponse{}, nil
+}
+
+func (st *storageServer) Delete(ctx context.Context, req *pbstorage.DeleteRequest) (*pbstorage.DeleteResponse, error) {
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+
+	if err := st.s.authorize(ctx, req.ObjectId, scopes.Scope_DELETE); err != nil {
+		return nil, err
+	}
+
+	delete(st.s.stored, req.ObjectId)
+	delete(st.s.acls, req.ObjectId)
+	return &pbstorage.DeleteResponse{}, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parallel contains helpers for running bounded numbers of goroutines.
+package parallel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Range calls fn for every index in [0, n) using at most workers goroutines, and returns once all
+// calls have finished. Indices that have not been started when the context is cancelled are
+// skipped.
+func Range(ctx context.Context, n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// ForEach calls fn for every item received from items using at most workers goroutines, and
+// returns once items is closed and all calls have finished. If the context is cancelled, ForEach
+// stops receiving items and returns once the calls in progress have finished.
+func ForEach[T any](ctx context.Context, items <-chan T, workers int, fn func(item T)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item, ok := <-items:
+					if !ok {
+						return
+					}
+					fn(item)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Ordered calls fn for every item received from items using at most workers goroutines, and sends
+// the results on the returned channel in the order the items were received. The channel is closed
+// once items is closed and all results have been sent. If the context is cancelled, Ordered stops
+// receiving items, and the results of the calls already started are still sent. The caller must
+// drain the returned channel.
+func Ordered[T, R any](ctx context.Context, items <-chan T, workers int, fn func(item T) R) <-chan R {
+	if workers < 1 {
+		workers = 1
+	}
+
+	out := make(chan R)
+	// queue holds the pending results in item order, and sem bounds the calls in progress.
+	queue := make(chan chan R, workers)
+	sem := make(chan struct{}, workers)
+
+	go func() {
+		defer close(queue)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-items:
+				if !ok {
+					return
+				}
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+
+				result := make(chan R, 1)
+				queue <- result
+				go func() {
+					defer func() { <-sem }()
+					result <- fn(item)
+				}()
+			}
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		for result := range queue {
+			out <- <-result
+		}
+	}()
+	return out
+}
+
+// Limiter limits the rate of operations shared between goroutines. A nil *Limiter does not limit.
+type Limiter struct {
+	ticker *time.Ticker
+}
+
+// NewLimiter returns a Limiter allowing perSecond operations per second, or nil if perSecond is
+// not positive.
+func NewLimiter(perSecond float64) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Limiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+// Wait blocks until the next operation is allowed or the context is cancelled.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop releases the resources of the limiter.
+func (l *Limiter) Stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	gclient "github.com/cybercryptio/d1-client-go/v2/d1-generic"
+	pb "github.com/cybercryptio/d1-client-go/v2/k1/protobuf"
+)
+
+// GetKeySetSecret gets the key set for the request and returns the wrapped keys as SecretBytes,
+// which the caller should destroy when done with them, along with the nonce.
+func (c *Client) GetKeySetSecret(ctx context.Context, request *pb.GetKeySetRequest) (*gclient.SecretBytes, []byte, error) {
+	res, err := c.GetKeySet(ctx, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gclient.NewSecretBytes(res.WrappedKeys), res.Nonce, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 CYBERCRYPT
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	client "github.com/cybercryptio/d1-client-go/v2/k1"
+	pb "github.com/cybercryptio/d1-client-go/v2/k1/protobuf"
+)
+
+type fakeKeyAPI struct {
+	pb.UnimplementedKeyAPIServer
+}
+
+func (fakeKeyAPI) GetKeySet(_ context.Context, req *pb.GetKeySetRequest) (*pb.GetKeySetResponse, error) {
+	if req.KikId != "kik" {
+		return nil, status.Error(codes.NotFound, "unknown key initialization key")
+	}
+	return &pb.GetKeySetResponse{Nonce: req.Nonce, WrappedKeys: []byte("wrapped keys")}, nil
+}
+
+func TestGetKeySetSecret(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterKeyAPIServer(server, fakeKeyAPI{})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	c, err := client.NewClient(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	keys, nonce, err := c.GetKeySetSecret(ctx, &pb.GetKeySetRequest{KikId: "kik", Nonce: []byte("nonce")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keys.Bytes()) != "wrapped keys" || string(nonce) != "nonce" {
+		t.Fatalf("unexpected key set: %q, %q", keys.Bytes(), nonce)
+	}
+	data := keys.Bytes()
+	keys.Destroy()
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Fatal("wrapped keys were not zeroed")
+	}
+
+	if _, _, err := c.GetKeySetSecret(ctx, &pb.GetKeySetRequest{KikId: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
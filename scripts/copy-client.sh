@@ -29,8 +29,17 @@ TARGET_MAP[generic]="d1-generic"
 TARGET_MAP[storage]="d1-storage"
 TARGET_MAP[k1]="k1"
 
+# Map input name to client files maintained in this repository instead of being copied. The
+# generic BaseClient holds state set by the options of this repository, and its token refresh
+# must be safe for concurrent use.
+declare -A LOCAL_MAP
+LOCAL_MAP[generic]="base.go token.go"
+LOCAL_MAP[storage]=""
+LOCAL_MAP[k1]=""
+
 REPO="https://github.com/cybercryptio/${REPO_MAP[$CLIENT]}.git"
 TARGET="${TARGET_MAP[$CLIENT]}"
+LOCAL_FILES="${LOCAL_MAP[$CLIENT]}"
 
 CLIENT_DIR=$(realpath "$TARGET")
 CLIENT_PROTOBUF_DIR=$CLIENT_DIR/protobuf
@@ -126,10 +135,24 @@ fix_proto_source() {
     fix_go_imports
 }
 
+# is_local returns successfully if the given client file is maintained in this repository.
+is_local() {
+    for LOCAL_FILE in ${LOCAL_FILES//\ /$'\n'}; do
+        if [ "./${LOCAL_FILE}" == "$1" ]; then
+            return 0
+        fi
+    done
+    return 1
+}
+
 # copy and process client source files
 cd "${SRC_DIR}/client"
 GO_FILES=$(find . -name \*.go)
 for GO_FILE in $GO_FILES; do
+    if is_local "$GO_FILE"; then
+        echo "Skipping ${GO_FILE}, which is maintained in this repository"
+        continue
+    fi
     SRC_PATH=$(realpath "$GO_FILE")
     DST_PATH=$(realpath --canonicalize-missing "${CLIENT_DIR}/${GO_FILE}")
     DST_DIR=$(dirname "$DST_PATH")